@@ -0,0 +1,153 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const backfillPageSize = 100
+
+// Between two pages, to be nice to the relays
+var backfillPause = 2 * time.Second
+
+/**
+ * Works through the queued backfills one at a time, so the relays do not get hammered.
+ */
+func backfillTask(wg *sync.WaitGroup, ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, interval time.Duration) {
+	defer wg.Done()
+
+	if err := st.ResumeBackfills(ctx); err != nil {
+		slog.Error(err.Error())
+	}
+
+	for {
+		job, err := st.NextBackfill(ctx)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		if job != nil {
+			runBackfill(ctx, st, nostrWrapper, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+/**
+ * Walk back in time with until, page by page. Every relay has its own history, so we only move until
+ * as far back as the relay that still had a full page got. Progress is stored after every page.
+ */
+func runBackfill(ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, job *db.Backfill) {
+	slog.Info("Backfill started", "pubkey", job.Pubkey, "until", job.Until)
+
+	for job.Status == db.BackfillRunning {
+		until := nostr.Now()
+		if job.Until > 0 {
+			until = nostr.Timestamp(job.Until)
+		}
+		stopAt := nostr.Timestamp(job.StopAt)
+
+		filter := nostr.Filter{
+			Kinds:   []int{nostr.KindTextNote},
+			Authors: []string{job.Pubkey},
+			Until:   &until,
+			Since:   &stopAt,
+			Limit:   backfillPageSize,
+		}
+
+		pageCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		result := nostrWrapper.GetPage(pageCtx, filter)
+		pageErr := pageError(result)
+		if len(result.Events) > 0 {
+			if _, err := st.SaveEvents(pageCtx, result.Events); err != nil {
+				pageErr = err
+			}
+		}
+		cancel()
+
+		perRelay := make(map[string]int)
+		oldest := make(map[string]nostr.Timestamp)
+		for _, ev := range result.Events {
+			for _, url := range ev.Urls {
+				perRelay[url]++
+				if t, ok := oldest[url]; !ok || ev.Event.CreatedAt < t {
+					oldest[url] = ev.Event.CreatedAt
+				}
+			}
+		}
+
+		var next nostr.Timestamp = 0
+		for url, count := range perRelay {
+			if count >= backfillPageSize && oldest[url] > next {
+				next = oldest[url]
+			}
+		}
+
+		job.Pages++
+		job.NotesFetched += len(result.Events)
+
+		switch {
+		case ctx.Err() != nil:
+			job.Status = db.BackfillPending // Try again next time
+		case pageErr != nil:
+			// Without an answer we do not know if there is more, a restart of the backfill tries again
+			job.Status = db.BackfillFailed
+			job.LastError = pageErr.Error()
+		case next == 0 || next <= stopAt || next >= until:
+			job.Status = db.BackfillDone // No relay has more or we are back far enough
+		case job.NotesFetched >= job.MaxNotes:
+			job.Status = db.BackfillDone
+		default:
+			job.Until = int64(next)
+		}
+
+		if job.Status == db.BackfillDone || job.Status == db.BackfillFailed {
+			now := time.Now()
+			job.FinishedAt = &now
+		}
+
+		if err := st.SaveBackfill(context.Background(), job); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+
+		if job.Status == db.BackfillRunning {
+			select {
+			case <-ctx.Done():
+			case <-time.After(backfillPause):
+			}
+		}
+	}
+
+	slog.Info("Backfill finished", "pubkey", job.Pubkey, "status", job.Status, "notes", job.NotesFetched, "pages", job.Pages)
+}
+
+// A page fails when no relay ended it with an EOSE, an empty page is then no proof that there is nothing more
+func pageError(result wrapper.SyncResult) error {
+	var errs []error
+	for url, stats := range result.Relays {
+		if stats.Complete {
+			return nil
+		}
+		if stats.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", url, stats.Error))
+		}
+	}
+	if len(errs) == 0 {
+		return errors.New("no relay answered")
+	}
+	return errors.Join(errs...)
+}
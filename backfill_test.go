@@ -0,0 +1,168 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"amavis442/nostr-reader/internal/relaytest"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func testWrapper(t *testing.T, relayUrls ...string) *wrapper.Wrapper {
+	relays := make(map[string]db.Relay)
+	for _, relayUrl := range relayUrls {
+		relays[relayUrl] = db.Relay{Read: true}
+	}
+	w := &wrapper.Wrapper{}
+	w.SetConfig(&wrapper.WrapperConfig{Relays: relays})
+	t.Cleanup(w.Close)
+	return w
+}
+
+// Notes of one author, one minute apart and the newest one minute ago
+func authorNotes(t *testing.T, n int) (string, []*nostr.Event) {
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	now := time.Now().Unix()
+	evs := make([]*nostr.Event, 0, n)
+	for i := 0; i < n; i++ {
+		ev := &nostr.Event{Kind: nostr.KindTextNote, Content: "note " + strconv.Itoa(i), CreatedAt: nostr.Timestamp(now - int64(i+1)*60), Tags: nostr.Tags{}}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	return pubkey, evs
+}
+
+/**
+ * Only the methods the background tasks use, anything else panics on the nil Store.
+ */
+type fakeStore struct {
+	db.Store
+	mu        sync.Mutex
+	saved     map[string]bool
+	saveErr   error
+	backfills []db.Backfill
+	due       []db.MissingEvent
+	dueCalls  int
+	resolved  []string
+	notFound  []db.MissingEvent
+	cursors   map[string]int64
+}
+
+func (st *fakeStore) SaveEvents(ctx context.Context, evs []*db.Event) ([]string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.saveErr != nil {
+		return nil, st.saveErr
+	}
+	if st.saved == nil {
+		st.saved = make(map[string]bool)
+	}
+	ids := make([]string, 0, len(evs))
+	for _, ev := range evs {
+		st.saved[ev.Event.ID] = true
+		ids = append(ids, ev.Event.ID)
+	}
+	return ids, nil
+}
+
+func (st *fakeStore) SaveBackfill(ctx context.Context, backfill *db.Backfill) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.backfills = append(st.backfills, *backfill)
+	return nil
+}
+
+func runTestBackfill(t *testing.T, st *fakeStore, w *wrapper.Wrapper, job *db.Backfill) {
+	backfillPause = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	job.Status = db.BackfillRunning
+	runBackfill(ctx, st, w, job)
+}
+
+func TestBackfillPagesBack(t *testing.T) {
+	pubkey, evs := authorNotes(t, 250)
+	st := &fakeStore{}
+	job := &db.Backfill{Pubkey: pubkey, StopAt: time.Now().AddDate(0, 0, -30).Unix(), MaxNotes: 1000}
+	runTestBackfill(t, st, testWrapper(t, relaytest.New(t, evs, relaytest.Options{}).Url), job)
+
+	if job.Status != db.BackfillDone || job.FinishedAt == nil {
+		t.Logf("the backfill should be done when the relay has no full page anymore, got %s", job.Status)
+		t.Fail()
+	}
+	if len(st.saved) != 250 {
+		t.Logf("all 250 notes should be saved, got %d", len(st.saved))
+		t.Fail()
+	}
+	if job.Pages != 3 || len(st.backfills) != 3 {
+		t.Logf("250 notes in pages of 100 are 3 pages with progress stored after every page, got %d", job.Pages)
+		t.Fail()
+	}
+}
+
+func TestBackfillStopsAtMaxNotes(t *testing.T) {
+	pubkey, evs := authorNotes(t, 250)
+	st := &fakeStore{}
+	job := &db.Backfill{Pubkey: pubkey, StopAt: time.Now().AddDate(0, 0, -30).Unix(), MaxNotes: 150}
+	runTestBackfill(t, st, testWrapper(t, relaytest.New(t, evs, relaytest.Options{}).Url), job)
+
+	if job.Status != db.BackfillDone || job.Pages != 2 {
+		t.Logf("the backfill should stop after the page that got past max notes, got %s after %d pages", job.Status, job.Pages)
+		t.Fail()
+	}
+}
+
+func TestBackfillStopsAtStopAt(t *testing.T) {
+	pubkey, evs := authorNotes(t, 250)
+	st := &fakeStore{}
+	// Between note 149 and 150
+	job := &db.Backfill{Pubkey: pubkey, StopAt: int64(evs[149].CreatedAt) - 30, MaxNotes: 1000}
+	runTestBackfill(t, st, testWrapper(t, relaytest.New(t, evs, relaytest.Options{}).Url), job)
+
+	if job.Status != db.BackfillDone {
+		t.Logf("the backfill should be done, got %s", job.Status)
+		t.Fail()
+	}
+	if len(st.saved) != 150 || st.saved[evs[150].ID] {
+		t.Logf("only the 150 notes after stop at should be saved, got %d", len(st.saved))
+		t.Fail()
+	}
+}
+
+func TestBackfillFailsWithoutAnswer(t *testing.T) {
+	pubkey, evs := authorNotes(t, 10)
+	st := &fakeStore{}
+	job := &db.Backfill{Pubkey: pubkey, StopAt: time.Now().AddDate(0, 0, -30).Unix(), MaxNotes: 1000}
+	runTestBackfill(t, st, testWrapper(t, relaytest.New(t, evs, relaytest.Options{ReqClosed: true}).Url), job)
+
+	if job.Status != db.BackfillFailed || job.FinishedAt == nil {
+		t.Logf("a page without an answer is no proof there is nothing more, the backfill should fail, got %s", job.Status)
+		t.Fail()
+	}
+	if !strings.Contains(job.LastError, "not now") {
+		t.Logf("the error of the relay should be kept, got %q", job.LastError)
+		t.Fail()
+	}
+}
+
+func TestBackfillFailsWhenSaveFails(t *testing.T) {
+	pubkey, evs := authorNotes(t, 10)
+	st := &fakeStore{saveErr: errors.New("disk full")}
+	job := &db.Backfill{Pubkey: pubkey, StopAt: time.Now().AddDate(0, 0, -30).Unix(), MaxNotes: 1000}
+	runTestBackfill(t, st, testWrapper(t, relaytest.New(t, evs, relaytest.Options{}).Url), job)
+
+	if job.Status != db.BackfillFailed || job.LastError != "disk full" {
+		t.Logf("the backfill should fail with the error of the store, got %s %q", job.Status, job.LastError)
+		t.Fail()
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/**
+ * The wrapper keeps the relay health in memory, this stores it every interval.
+ */
+func relayHealthTask(wg *sync.WaitGroup, ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, interval time.Duration) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := st.SaveRelayHealth(ctx, nostrWrapper.GetRelayHealth()); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+	}
+}
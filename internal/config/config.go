@@ -25,6 +25,7 @@ type Config struct {
 	Env      string
 	Interval uint
 	Nostr    *wrapper.WrapperConfig
+	Sync     *wrapper.SyncConfig
 }
 
 func configDir() (string, error) {
@@ -87,6 +88,11 @@ func LoadConfig() (*Config, error) {
 		pubKey, _ = nostr.GetPublicKey(cfg.Nostr.PrivateKey)
 	}
 
+	// The sqlite database is next to the config, unless you put it somewhere else
+	if cfg.Database.Driver == db.Sqlite && cfg.Database.Path == "" {
+		cfg.Database.Path = filepath.Join(dir, "nostr-reader.db")
+	}
+
 	cfg.Nostr.PubKey = pubKey
 	cfg.Nostr.Nsec, _ = nip19.EncodePrivateKey(cfg.Nostr.PrivateKey)
 	cfg.Nostr.Npub, _ = nip19.EncodePublicKey(pubKey)
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * The profile page of an author: the profile, if we follow or block the author and the counts of what we know.
+ */
+type Author struct {
+	Profile   Profile `json:"profile"`
+	Followed  bool    `json:"followed"`
+	Blocked   bool    `json:"blocked"`
+	Notes     int64   `json:"notes"`
+	Replies   int64   `json:"replies"`
+	Following int     `json:"following"`
+	Followers int64   `json:"followers"` // Only the kind 3 lists we have
+	KnownList bool    `json:"known_list"`
+}
+
+/**
+ * Store the follows of a kind 3 event, only a newer list replaces the one we have. When the newer list is the
+ * first with us in it, the author is a new follower.
+ */
+func (st *Storage) SaveContactList(ctx context.Context, ev *nostr.Event) error {
+	var previous ContactList
+	if err := st.GormDB.WithContext(ctx).Where("pubkey = ?", ev.PubKey).Find(&previous).Error; err != nil {
+		return err
+	}
+	if previous.ID > 0 && previous.EventCreatedAt >= ev.CreatedAt.Time().Unix() {
+		return nil
+	}
+
+	follows := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range ev.Tags.GetAll([]string{"p"}) {
+		pubkey := t.Value()
+		if !nostr.IsValid32ByteHex(pubkey) || seen[pubkey] {
+			continue
+		}
+		seen[pubkey] = true
+		follows = append(follows, pubkey)
+	}
+
+	list := ContactList{
+		Pubkey:         ev.PubKey,
+		EventId:        ev.ID,
+		EventCreatedAt: ev.CreatedAt.Time().Unix(),
+		Follows:        follows,
+		UpdatedAt:      time.Now(),
+	}
+
+	err := st.GormDB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "pubkey"}},
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "contact_lists.event_created_at < excluded.event_created_at"}}},
+		DoUpdates: clause.AssignmentColumns([]string{"event_id", "event_created_at", "follows", "updated_at"}),
+	}).Create(&list).Error
+	if err != nil {
+		return err
+	}
+
+	if !seen[st.Pubkey] || slices.Contains(previous.Follows, st.Pubkey) {
+		return nil
+	}
+	return st.notify(ctx, Notification{
+		Type:           NotificationFollower,
+		EventId:        ev.ID,
+		Pubkey:         ev.PubKey,
+		EventCreatedAt: list.EventCreatedAt,
+	})
+}
+
+func (st *Storage) GetAuthor(ctx context.Context, pubkey string) (Author, error) {
+	author := Author{}
+
+	var profile Profile
+	if err := st.GormDB.WithContext(ctx).Where("pubkey = ?", pubkey).Find(&profile).Error; err != nil {
+		return author, err
+	}
+	if profile.ID == 0 {
+		profile.Pubkey = pubkey
+		profile.Name.String = pubkey
+	}
+
+	var count int64
+	st.GormDB.WithContext(ctx).Model(&Follow{}).Where("pubkey = ?", pubkey).Count(&count)
+	author.Followed = count > 0
+	st.GormDB.WithContext(ctx).Model(&Block{}).Where("pubkey = ?", pubkey).Count(&count)
+	author.Blocked = count > 0
+	profile.Followed = author.Followed
+	profile.Blocked = author.Blocked
+	author.Profile = profile
+
+	st.GormDB.WithContext(ctx).Model(&Note{}).Where("pubkey = ? AND kind = 1 AND root = true", pubkey).Count(&author.Notes)
+	st.GormDB.WithContext(ctx).Model(&Note{}).Where("pubkey = ? AND kind = 1 AND root = false", pubkey).Count(&author.Replies)
+
+	var list ContactList
+	st.GormDB.WithContext(ctx).Where("pubkey = ?", pubkey).Find(&list)
+	author.KnownList = list.ID > 0
+	author.Following = len(list.Follows)
+
+	err := st.GormDB.WithContext(ctx).Model(&ContactList{}).Where(st.arrayContains("follows"), pubkey).Count(&author.Followers).Error
+
+	return author, err
+}
+
+// Do we have a kind 0 and a kind 3 of the author
+func (st *Storage) HasAuthor(ctx context.Context, pubkey string) bool {
+	var profiles, lists int64
+	st.GormDB.WithContext(ctx).Model(&Profile{}).Where("pubkey = ?", pubkey).Count(&profiles)
+	st.GormDB.WithContext(ctx).Model(&ContactList{}).Where("pubkey = ?", pubkey).Count(&lists)
+
+	return profiles > 0 && lists > 0
+}
+
+/**
+ * The notes and replies of an author, newest first. The cursor is the created_at and event id of the last
+ * note of the previous page, both empty for the first page.
+ */
+func (st *Storage) GetAuthorNotes(ctx context.Context, pubkey string, until int64, untilId string, limit int) ([]Event, error) {
+	qry := noteRowsQuery + ` AND notes.pubkey = ?`
+	args := []interface{}{pubkey}
+	if until > 0 {
+		qry = qry + ` AND (notes.event_created_at, notes.event_id) < (?, ?)`
+		args = append(args, until, untilId)
+	}
+	qry = qry + ` ORDER BY notes.event_created_at DESC, notes.event_id DESC LIMIT ?`
+	args = append(args, limit)
+
+	var rows []NotesAndProfiles
+	if err := st.GormDB.WithContext(ctx).Raw(qry, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	eventMap, keys, _, err := st.procesEventRows(&rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.addCounters(ctx, eventMap); err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(keys))
+	for _, k := range keys {
+		events = append(events, eventMap[k])
+	}
+	return events, nil
+}
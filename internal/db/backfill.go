@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * Queue getting the history of an author. When restart is false an existing backfill is left alone,
+ * otherwise it starts again from now.
+ */
+func (st *Storage) CreateBackfill(ctx context.Context, pubkey string, restart bool) error {
+	backfill := Backfill{
+		Pubkey:   pubkey,
+		Status:   BackfillPending,
+		StopAt:   time.Now().AddDate(0, 0, -1*st.DbConfig.BackfillDays).Unix(),
+		MaxNotes: st.DbConfig.BackfillNotes,
+	}
+
+	onConflict := clause.OnConflict{DoNothing: true}
+	if restart {
+		onConflict = clause.OnConflict{
+			Columns: []clause.Column{{Name: "pubkey"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"status":        BackfillPending,
+				"until":         0,
+				"stop_at":       backfill.StopAt,
+				"max_notes":     backfill.MaxNotes,
+				"notes_fetched": 0,
+				"pages":         0,
+				"last_error":    "",
+				"started_at":    nil,
+				"finished_at":   nil,
+				"updated_at":    time.Now(),
+			}),
+		}
+	}
+
+	return st.GormDB.WithContext(ctx).Clauses(onConflict).Create(&backfill).Error
+}
+
+/**
+ * Take the oldest pending backfill and mark it as running. Returns nil when there is nothing to do.
+ */
+func (st *Storage) NextBackfill(ctx context.Context) (*Backfill, error) {
+	var backfill Backfill
+	err := st.GormDB.WithContext(ctx).Where("status = ?", BackfillPending).Order("id ASC").Limit(1).Find(&backfill).Error
+	if err != nil || backfill.ID == 0 {
+		return nil, err
+	}
+
+	now := time.Now()
+	backfill.Status = BackfillRunning
+	if backfill.StartedAt == nil {
+		backfill.StartedAt = &now
+	}
+	if err := st.SaveBackfill(ctx, &backfill); err != nil {
+		return nil, err
+	}
+	return &backfill, nil
+}
+
+// Store the progress, so we can resume after a restart
+func (st *Storage) SaveBackfill(ctx context.Context, backfill *Backfill) error {
+	return st.GormDB.WithContext(ctx).Save(backfill).Error
+}
+
+/**
+ * Backfills which were running when the app stopped, continue where they left off.
+ */
+func (st *Storage) ResumeBackfills(ctx context.Context) error {
+	return st.GormDB.WithContext(ctx).Model(&Backfill{}).Where("status = ?", BackfillRunning).Update("status", BackfillPending).Error
+}
+
+func (st *Storage) GetBackfills(ctx context.Context) ([]Backfill, error) {
+	var backfills []Backfill
+	err := st.GormDB.WithContext(ctx).Model(&Backfill{}).Order("id DESC").Find(&backfills).Error
+	return backfills, err
+}
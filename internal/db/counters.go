@@ -0,0 +1,83 @@
+package db
+
+import (
+	"amavis442/nostr-reader/internal/tag"
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var counterColumns = []string{"likes", "dislikes", "emojis", "replies", "reposts", "zaps", "zap_amount"}
+
+// The note a reply replies to, the root when it has no reply tag
+func replyParent(tree tag.EventTree) string {
+	if tree.ReplyTag != "" {
+		return tree.ReplyTag
+	}
+	return tree.RootTag
+}
+
+// A + or nothing is a like and a - a dislike (NIP-25), everything else is an emoji
+func reactionCounters(content string) Counters {
+	switch content {
+	case "+", "":
+		return Counters{Likes: 1}
+	case "-":
+		return Counters{Dislikes: 1}
+	}
+	return Counters{Emojis: 1}
+}
+
+/**
+ * Add an event to the counters of the note with the event id. An event is only counted once, also when we
+ * get it again from another relay. The note does not have to be there yet.
+ */
+func (st *Storage) count(ctx context.Context, eventId string, sourceEventId string, counters Counters) error {
+	if eventId == "" || sourceEventId == "" {
+		return nil
+	}
+
+	return st.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		source := CounterSource{EventId: eventId, SourceEventId: sourceEventId}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&source)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		updates := make(map[string]interface{}, len(counterColumns)+1)
+		for _, column := range counterColumns {
+			updates[column] = gorm.Expr(fmt.Sprintf("event_counters.%s + excluded.%s", column, column))
+		}
+		updates["updated_at"] = gorm.Expr("CURRENT_TIMESTAMP")
+
+		counter := EventCounter{EventId: eventId, Counters: counters}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "event_id"}},
+			DoUpdates: clause.Assignments(updates),
+		}).Create(&counter).Error
+	})
+}
+
+// Put the counters on the notes, with one query for all of them
+func (st *Storage) addCounters(ctx context.Context, eventMap map[string]Event) error {
+	if len(eventMap) == 0 {
+		return nil
+	}
+	eventIds := make([]string, 0, len(eventMap))
+	for eventId := range eventMap {
+		eventIds = append(eventIds, eventId)
+	}
+
+	var counters []EventCounter
+	if err := st.GormDB.WithContext(ctx).Where("event_id IN (?)", eventIds).Find(&counters).Error; err != nil {
+		return err
+	}
+	for _, counter := range counters {
+		ev := eventMap[counter.EventId]
+		ev.Counters = counter.Counters
+		eventMap[counter.EventId] = ev
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package db
+
+import "fmt"
+
+// The backends we can store in
+const (
+	Postgres = "postgres"
+	Sqlite   = "sqlite"
+)
+
+/**
+ * Most queries are the same for both backends. Postgres has arrays and json operators, in sqlite the arrays are
+ * text in the postgres array format ({"a","b"}) and we use the json functions.
+ */
+func (st *Storage) isSqlite() bool {
+	return st.GormDB.Dialector.Name() == Sqlite
+}
+
+// The text[] column has the value, which is the only parameter of the condition
+func (st *Storage) arrayContains(column string) string {
+	if st.isSqlite() {
+		return fmt.Sprintf(`%s LIKE '%%"' || ? || '"%%'`, column)
+	}
+	return fmt.Sprintf("%s @> ARRAY[?]::text[]", column)
+}
+
+// The distinct values of a group as a text[], the values should not have a comma
+func (st *Storage) arrayAgg(expr string) string {
+	if st.isSqlite() {
+		return fmt.Sprintf("'{' || group_concat(DISTINCT %s) || '}'", expr)
+	}
+	return fmt.Sprintf("array_agg(DISTINCT %s)", expr)
+}
+
+// A field of a json(b) column as text
+func (st *Storage) jsonText(column string, field string) string {
+	if st.isSqlite() {
+		return fmt.Sprintf("json_extract(CAST(%s AS TEXT), '$.%s')", column, field)
+	}
+	return fmt.Sprintf("%s->>'%s'", column, field)
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,28 +14,34 @@ import (
 
 // Note godoc
 type Event struct {
-	Event    *nostr.Event      `json:"event"`
-	Profile  Profile           `json:"profile"`
-	Etags    []string          `json:"-"`
-	Ptags    []string          `json:"-"`
-	Garbage  bool              `json:"gargabe"`
-	Children map[string]*Event `json:"children"`
-	Tree     int64             `json:"tree"`
-	RootId   string            `json:"-"`
-	Bookmark bool              `json:"bookmark"`
-	Content  string            `json:"content"`
-	Refs     Refs              `json:"refs"`
-	Urls     pq.StringArray    `json:"urls"`
+	Event      *nostr.Event   `json:"event"`
+	Profile    Profile        `json:"profile"`
+	Etags      []string       `json:"-"`
+	Ptags      []string       `json:"-"`
+	Garbage    bool           `json:"gargabe"`
+	Children   []*Event       `json:"children"` // Oldest first
+	Tree       int64          `json:"tree"`
+	RootId     string         `json:"-"`
+	Bookmark   bool           `json:"bookmark"`
+	Content    string         `json:"content"`
+	Refs       Refs           `json:"refs"`
+	Urls       pq.StringArray `json:"urls"`
+	Replies    int64          `json:"replies"`     // Direct replies, also the ones not in children
+	AllReplies int64          `json:"all_replies"` // All replies under the note
+	Orphan     bool           `json:"orphan"`      // We do not have the note it replies to
+	Read       bool           `json:"read"`        // Marked as read
+	Counters   Counters       `json:"counters"`
 }
 
 type Relay struct {
-	ID        uint      `json:"-"`
-	Url       string    `gorm:"not null; unique; index,type:btree;type:varchar(255)" json:"url"`
-	Read      bool      `gorm:"default: false;" json:"read"`
-	Write     bool      `gorm:"default: false;" json:"write"`
-	Search    bool      `gorm:"default: false;" json:"search"`
-	CreatedAt time.Time `gorm:"default:current_timestamp" json:"-"`
-	UpdatedAt time.Time `gorm:"default:null" json:"-"`
+	ID        uint         `json:"-"`
+	Url       string       `gorm:"not null; unique; index,type:btree;type:varchar(255)" json:"url"`
+	Read      bool         `gorm:"default: false;" json:"read"`
+	Write     bool         `gorm:"default: false;" json:"write"`
+	Search    bool         `gorm:"default: false;" json:"search"`
+	Health    *RelayHealth `gorm:"-" json:"health,omitempty"`
+	CreatedAt time.Time    `gorm:"default:current_timestamp" json:"-"`
+	UpdatedAt time.Time    `gorm:"default:null" json:"-"`
 }
 
 func (entity *Relay) BeforeUpdate(tx *gorm.DB) error {
@@ -60,7 +67,6 @@ type Note struct {
 	CreatedAt      sql.NullTime   `gorm:"type:TIMESTAMPTZ;default:current_timestamp" json:"-" db:"created_at"`
 	UpdatedAt      sql.NullTime   `gorm:"type:TIMESTAMPTZ;default:null" json:"-" db:"updated_at"`
 	Root           bool           `gorm:"type:bool;not null;default:false;index;comment:Is this the root note" json:"-" db:"root"`
-	Urls           pq.StringArray `gorm:"type:text[];index:idx_notes_urls,type:gin" json:"urls" db:"urls"`
 	ProfileID      *uint          `gorm:"type:bigint;default null;" json:"profile_id,omitempty" db:"profile_id"`
 }
 
@@ -76,13 +82,25 @@ func (entity *Note) BeforeUpdate(tx *gorm.DB) error {
 	return nil
 }
 
+/**
+ * Something someone did with us or our notes. Mentions and replies are a note, the others are about one of our
+ * notes, the target, except a new follower.
+ */
 type Notification struct {
-	ID        uint `gorm:"primaryKey" json:"id"`
-	NoteID    uint `gorm:"not null"  json:"note_id"`
-	Note      Note
-	Seen      bool      `gorm:"default:false" json:"seen"`
-	CreatedAt time.Time `gorm:"default:current_timestamp" json:"-"`
-	UpdatedAt time.Time `gorm:"default:null" json:"-"`
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	NoteID         *uint     `gorm:"type:bigint;default:null;index" json:"-"` // The note of a mention or reply
+	Type           string    `gorm:"type:varchar(20);not null;default:'mention';uniqueIndex:idx_notifications_type_event" json:"type"`
+	EventId        string    `gorm:"type:varchar(100);not null;default:'';uniqueIndex:idx_notifications_type_event" json:"event_id"`
+	TargetEventId  string    `gorm:"type:varchar(100);not null;default:'';index" json:"target_event_id"`
+	Pubkey         string    `gorm:"type:varchar(100);not null;default:''" json:"pubkey"` // Who did it
+	Content        string    `gorm:"type:text;not null;default:''" json:"content"`        // The reaction or the message of a zap
+	Amount         int64     `gorm:"type:bigint;not null;default:0" json:"amount"`        // Millisats of a zap
+	EventCreatedAt int64     `gorm:"type:bigint;not null;default:0" json:"event_created_at"`
+	Seen           bool      `gorm:"default:false" json:"seen"`
+	Profile        *Profile  `gorm:"-" json:"profile,omitempty"`
+	Event          *Event    `gorm:"-" json:"event,omitempty"` // The note of a mention or reply
+	CreatedAt      time.Time `gorm:"default:current_timestamp" json:"-"`
+	UpdatedAt      time.Time `gorm:"default:null" json:"-"`
 }
 
 func (entity *Notification) BeforeUpdate(tx *gorm.DB) error {
@@ -91,22 +109,23 @@ func (entity *Notification) BeforeUpdate(tx *gorm.DB) error {
 }
 
 type Profile struct {
-	ID          uint           `gorm:"primaryKey" json:"-" db:"id"`
-	UID         uuid.UUID      `gorm:"type:uuid;" db:"uid"`
-	Pubkey      string         `gorm:"index,type:btree;not null;unique;type:varchar(100)" json:"pubkey" db:"pubkey"`
-	Name        NullString     `gorm:"type:varchar(255)" json:"name" db:"name"`
-	About       NullString     `gorm:"type:text" json:"about" db:"about"`
-	Picture     NullString     `gorm:"type:varchar(255)" json:"picture" db:"picture"`
-	Website     NullString     `gorm:"type:varchar(255)" json:"website" db:"website"`
-	Nip05       NullString     `gorm:"type:varchar(255)" json:"nip05" db:"nip05"`
-	Lud16       NullString     `gorm:"type:varchar(255)" json:"lud16" db:"lud16"`
-	DisplayName NullString     `gorm:"type:varchar(255)" json:"display_name" db:"display_name"`
-	Raw         []byte         `gorm:"not null;type:jsonb" json:"-" db:"raw"`
-	Urls        pq.StringArray `gorm:"type:text[];index:idx_profile_urls,type:gin" json:"urls" db:"urls"`
-	CreatedAt   sql.NullTime   `gorm:"type:timestamp;default:current_timestamp" json:"-" db:"created_at"`
-	UpdatedAt   sql.NullTime   `gorm:"type:timestamp;default:null" json:"-" db:"updated_at"`
-	Followed    bool           `gorm:"type:bool;default:false;not null" json:"followed" db:"-"`
-	Blocked     bool           `gorm:"type:bool;default:false;not null" json:"blocked" db:"-"`
+	ID             uint         `gorm:"primaryKey" json:"-" db:"id"`
+	UID            uuid.UUID    `gorm:"type:uuid;" db:"uid"`
+	Pubkey         string       `gorm:"index,type:btree;not null;unique;type:varchar(100)" json:"pubkey" db:"pubkey"`
+	Name           NullString   `gorm:"type:varchar(255)" json:"name" db:"name"`
+	About          NullString   `gorm:"type:text" json:"about" db:"about"`
+	Picture        NullString   `gorm:"type:varchar(255)" json:"picture" db:"picture"`
+	Website        NullString   `gorm:"type:varchar(255)" json:"website" db:"website"`
+	Nip05          NullString   `gorm:"type:varchar(255)" json:"nip05" db:"nip05"`
+	Lud16          NullString   `gorm:"type:varchar(255)" json:"lud16" db:"lud16"`
+	DisplayName    NullString   `gorm:"type:varchar(255)" json:"display_name" db:"display_name"`
+	Raw            []byte       `gorm:"not null;type:jsonb" json:"-" db:"raw"`
+	EventCreatedAt int64        `gorm:"type:bigint;not null;default:0" json:"-" db:"event_created_at"` // Only a newer kind 0 event replaces the profile
+	FetchedAt      sql.NullTime `gorm:"type:timestamp;default:null;index" json:"-" db:"fetched_at"`
+	CreatedAt      sql.NullTime `gorm:"type:timestamp;default:current_timestamp" json:"-" db:"created_at"`
+	UpdatedAt      sql.NullTime `gorm:"type:timestamp;default:null" json:"-" db:"updated_at"`
+	Followed       bool         `gorm:"type:bool;default:false;not null" json:"followed" db:"-"`
+	Blocked        bool         `gorm:"type:bool;default:false;not null" json:"blocked" db:"-"`
 	//Notes       []Note         `gorm:"foreignKey:ProfileID;references:ID"`
 }
 
@@ -200,7 +219,14 @@ const (
 )
 
 func (p *Vote) Scan(value interface{}) error {
-	*p = Vote(value.([]byte))
+	switch v := value.(type) {
+	case []byte:
+		*p = Vote(v)
+	case string:
+		*p = Vote(v) // sqlite
+	default:
+		return fmt.Errorf("can not scan %T into a vote", value)
+	}
 	return nil
 }
 
@@ -224,3 +250,262 @@ func (entity *Reaction) BeforeUpdate(tx *gorm.DB) error {
 	entity.UpdatedAt = time.Now()
 	return nil
 }
+
+const KindReporting int = 1984
+
+// Reports (NIP-56) we made ourselves or received from people we follow
+type Report struct {
+	ID             uint      `gorm:"primaryKey" json:"-"`
+	EventId        string    `gorm:"type:varchar(100);index,type:btree;not null;unique;" json:"event_id"`
+	Pubkey         string    `gorm:"type:varchar(100);index,type:btree;not null;" json:"pubkey"`
+	TargetPubkey   string    `gorm:"type:varchar(100);index,type:btree;not null;" json:"target_pubkey"`
+	TargetEventId  string    `gorm:"type:varchar(100);index,type:btree;not null;default:''" json:"target_event_id"`
+	ReportType     string    `gorm:"type:varchar(50);not null;" json:"report_type"`
+	Content        string    `gorm:"type:text;" json:"content"`
+	EventCreatedAt int64     `gorm:"type:bigint;not null;" json:"event_created_at"`
+	CreatedAt      time.Time `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+	UpdatedAt      time.Time `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+func (entity *Report) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+// Until when a relay is synced for a certain filter, so every relay continues where it left off
+type RelaySyncState struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	RelayUrl  string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_relay_sync_state_relay_filter" json:"relay_url"`
+	FilterKey string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_relay_sync_state_relay_filter" json:"filter_key"`
+	Since     int64     `gorm:"type:bigint;not null;default:0" json:"since"`
+	GapSince  int64     `gorm:"type:bigint;not null;default:0" json:"gap_since"`
+	GapUntil  int64     `gorm:"type:bigint;not null;default:0" json:"gap_until"` // 0 when there is no gap
+	CreatedAt time.Time `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+	UpdatedAt time.Time `gorm:"type:timestamp;default:null" json:"updated_at"`
+}
+
+func (RelaySyncState) TableName() string {
+	return "relay_sync_state"
+}
+
+func (entity *RelaySyncState) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+type BackfillStatus string
+
+const (
+	BackfillPending BackfillStatus = "pending"
+	BackfillRunning BackfillStatus = "running"
+	BackfillDone    BackfillStatus = "done"
+	BackfillFailed  BackfillStatus = "failed"
+)
+
+// Getting the history of an author, walking backwards in time with until
+type Backfill struct {
+	ID           uint           `gorm:"primaryKey" json:"-"`
+	Pubkey       string         `gorm:"type:varchar(100);index,type:btree;not null;unique;" json:"pubkey"`
+	Status       BackfillStatus `gorm:"type:varchar(20);index;not null;default:'pending'" json:"status"`
+	Until        int64          `gorm:"type:bigint;not null;default:0;comment:Everything newer is done" json:"until"`
+	StopAt       int64          `gorm:"type:bigint;not null;default:0;comment:Do not go back further then this" json:"stop_at"`
+	MaxNotes     int            `gorm:"type:int;not null;default:0" json:"max_notes"`
+	NotesFetched int            `gorm:"type:int;not null;default:0" json:"notes_fetched"`
+	Pages        int            `gorm:"type:int;not null;default:0" json:"pages"`
+	LastError    string         `gorm:"type:text;not null;default:''" json:"last_error"`
+	StartedAt    *time.Time     `gorm:"type:timestamp;default:null" json:"started_at"`
+	FinishedAt   *time.Time     `gorm:"type:timestamp;default:null" json:"finished_at"`
+	CreatedAt    time.Time      `gorm:"type:timestamp;default:current_timestamp" json:"created_at"`
+	UpdatedAt    time.Time      `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+func (entity *Backfill) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+// Notes which are referenced as root or reply, but we do not have yet
+type MissingEvent struct {
+	ID           uint           `gorm:"primaryKey" json:"-"`
+	EventId      string         `gorm:"type:varchar(100);index,type:btree;not null;unique;" json:"event_id"`
+	ReferencedBy string         `gorm:"type:varchar(100);not null;" json:"referenced_by"`
+	RelayHints   pq.StringArray `gorm:"type:text[]" json:"relay_hints"`
+	Attempts     int            `gorm:"type:int;not null;default:0" json:"attempts"`
+	NextRetryAt  int64          `gorm:"type:bigint;index;not null;default:0" json:"next_retry_at"`
+	CreatedAt    time.Time      `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+	UpdatedAt    time.Time      `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+func (entity *MissingEvent) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+// How well a relay is doing. A relay that keeps failing is skipped until RetryAt.
+type RelayHealth struct {
+	ID                  uint       `gorm:"primaryKey" json:"-"`
+	Url                 string     `gorm:"type:varchar(255);not null;unique;" json:"url"`
+	LatencyMs           int64      `gorm:"type:bigint;not null;default:0" json:"latency_ms"`
+	Successes           int64      `gorm:"type:bigint;not null;default:0" json:"successes"`
+	Errors              int64      `gorm:"type:bigint;not null;default:0" json:"errors"`
+	Rejected            int64      `gorm:"type:bigint;not null;default:0" json:"rejected"`
+	ConsecutiveFailures int        `gorm:"type:int;not null;default:0" json:"consecutive_failures"`
+	LastError           string     `gorm:"type:text;not null;default:''" json:"last_error"`
+	LastSuccessAt       *time.Time `gorm:"type:timestamp" json:"last_success_at"`
+	LastErrorAt         *time.Time `gorm:"type:timestamp" json:"last_error_at"`
+	RetryAt             *time.Time `gorm:"type:timestamp" json:"retry_at"`
+	CreatedAt           time.Time  `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+	UpdatedAt           time.Time  `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+func (RelayHealth) TableName() string {
+	return "relay_health"
+}
+
+func (entity *RelayHealth) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// Every signed event we create, so it can be send again when the relays did not take it
+type OutboxEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	EventId   string    `gorm:"type:varchar(100);not null;unique;" json:"event_id"`
+	Kind      int       `gorm:"type:int;not null;" json:"kind"`
+	Raw       []byte    `gorm:"type:jsonb;not null;" json:"-"`
+	CreatedAt time.Time `gorm:"type:timestamp;default:current_timestamp" json:"created_at"`
+	UpdatedAt time.Time `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox"
+}
+
+func (entity *OutboxEvent) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+// Delivery of an outbox event to one relay. Message is the reason the relay gave in its OK or the error we got.
+type OutboxDelivery struct {
+	ID          uint           `gorm:"primaryKey" json:"-"`
+	EventId     string         `gorm:"type:varchar(100);not null;uniqueIndex:idx_outbox_deliveries_event_relay" json:"event_id"`
+	RelayUrl    string         `gorm:"type:varchar(255);not null;uniqueIndex:idx_outbox_deliveries_event_relay" json:"relay_url"`
+	Status      DeliveryStatus `gorm:"type:varchar(20);index;not null;default:'pending'" json:"status"`
+	Message     string         `gorm:"type:text;not null;default:''" json:"message"`
+	Attempts    int            `gorm:"type:int;not null;default:0" json:"attempts"`
+	NextRetryAt int64          `gorm:"type:bigint;not null;default:0" json:"next_retry_at"`
+	DeliveredAt *time.Time     `gorm:"type:timestamp;default:null" json:"delivered_at"`
+	CreatedAt   time.Time      `gorm:"type:timestamp;default:current_timestamp" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"type:timestamp;default:null" json:"updated_at"`
+}
+
+func (entity *OutboxDelivery) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+// A relay where we saw an event, updated on every sighting
+type EventRelay struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	EventId   string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_event_relays_event_relay" json:"event_id"`
+	RelayUrl  string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_event_relays_event_relay;index" json:"relay_url"`
+	FirstSeen time.Time `gorm:"type:timestamp;not null;default:current_timestamp" json:"first_seen"`
+	LastSeen  time.Time `gorm:"type:timestamp;not null;default:current_timestamp" json:"last_seen"`
+}
+
+// The newest kind 3 list we know of an author, so we can count followers
+type ContactList struct {
+	ID             uint           `gorm:"primaryKey" json:"-"`
+	Pubkey         string         `gorm:"type:varchar(100);not null;unique" json:"pubkey"`
+	EventId        string         `gorm:"type:varchar(100);not null" json:"event_id"`
+	EventCreatedAt int64          `gorm:"type:bigint;not null" json:"event_created_at"`
+	Follows        pq.StringArray `gorm:"type:text[];index:idx_contact_lists_follows,type:gin" json:"follows"`
+	CreatedAt      time.Time      `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+	UpdatedAt      time.Time      `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+// Near-duplicate notes from different authors, garbage when enough authors posted it within the spam window
+type SpamCluster struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Simhash     int64     `gorm:"type:bigint;not null" json:"-"`
+	Fingerprint string    `gorm:"-" json:"fingerprint"` // The simhash in hex
+	Authors     int       `gorm:"type:int;not null;default:0" json:"authors"`
+	Notes       int       `gorm:"type:int;not null;default:0" json:"notes"`
+	Garbage     bool      `gorm:"type:bool;not null;default:false" json:"garbage"`
+	Sample      string    `gorm:"type:text;not null;default:''" json:"sample"`
+	FirstSeen   int64     `gorm:"type:bigint;not null;default:0" json:"first_seen"`
+	LastSeen    int64     `gorm:"type:bigint;not null;default:0;index" json:"last_seen"`
+	CreatedAt   time.Time `gorm:"type:timestamp;default:current_timestamp" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+func (entity *SpamCluster) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+// The simhash of a note, the bands are its 4 parts of 16 bits
+type ContentFingerprint struct {
+	ID             uint      `gorm:"primaryKey" json:"-"`
+	NoteID         uint      `gorm:"not null;unique" json:"-"`
+	Pubkey         string    `gorm:"type:varchar(100);not null" json:"pubkey"`
+	Simhash        int64     `gorm:"type:bigint;not null" json:"-"`
+	Band0          int       `gorm:"type:int;not null;index" json:"-"`
+	Band1          int       `gorm:"type:int;not null;index" json:"-"`
+	Band2          int       `gorm:"type:int;not null;index" json:"-"`
+	Band3          int       `gorm:"type:int;not null;index" json:"-"`
+	ClusterID      *uint     `gorm:"type:bigint;default:null;index" json:"cluster_id"`
+	EventCreatedAt int64     `gorm:"type:bigint;not null;index" json:"event_created_at"`
+	CreatedAt      time.Time `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+}
+
+// A type of notifications that is muted is not shown and not counted, but still stored
+type NotificationSetting struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	Type      string    `gorm:"type:varchar(20);not null;unique" json:"type"`
+	Muted     bool      `gorm:"type:bool;not null;default:false" json:"muted"`
+	CreatedAt time.Time `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+	UpdatedAt time.Time `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+func (entity *NotificationSetting) BeforeUpdate(tx *gorm.DB) error {
+	entity.UpdatedAt = time.Now()
+	return nil
+}
+
+// What others did with a note, zap_amount is in millisats
+type Counters struct {
+	Likes     int64 `gorm:"type:bigint;not null;default:0" json:"likes"`
+	Dislikes  int64 `gorm:"type:bigint;not null;default:0" json:"dislikes"`
+	Emojis    int64 `gorm:"type:bigint;not null;default:0" json:"emojis"`  // Reactions that are not a like or dislike
+	Replies   int64 `gorm:"type:bigint;not null;default:0" json:"replies"` // Direct replies
+	Reposts   int64 `gorm:"type:bigint;not null;default:0" json:"reposts"`
+	Zaps      int64 `gorm:"type:bigint;not null;default:0" json:"zaps"`
+	ZapAmount int64 `gorm:"type:bigint;not null;default:0" json:"zap_amount"`
+}
+
+// The counters of a note, also of notes we do not have yet
+type EventCounter struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	EventId   string    `gorm:"type:varchar(100);not null;unique" json:"event_id"`
+	Counters  Counters  `gorm:"embedded" json:"counters"`
+	CreatedAt time.Time `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+	UpdatedAt time.Time `gorm:"type:timestamp;default:null" json:"-"`
+}
+
+// An event that is counted in the counters of the note with the event id
+type CounterSource struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	EventId       string    `gorm:"type:varchar(100);not null;index" json:"event_id"`
+	SourceEventId string    `gorm:"type:varchar(100);not null;unique" json:"source_event_id"`
+	CreatedAt     time.Time `gorm:"type:timestamp;default:current_timestamp" json:"-"`
+}
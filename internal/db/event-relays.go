@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * Remember on which relays we saw the events. A relay we already knew only gets a new last_seen.
+ */
+func (st *Storage) SaveEventRelays(ctx context.Context, evs []*Event) error {
+	now := time.Now()
+	seen := make(map[string]bool)
+	sightings := make([]EventRelay, 0, len(evs))
+	for _, ev := range evs {
+		for _, url := range ev.Urls {
+			url = nostr.NormalizeURL(url)
+			key := ev.Event.ID + url
+			if url == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			sightings = append(sightings, EventRelay{EventId: ev.Event.ID, RelayUrl: url, FirstSeen: now, LastSeen: now})
+		}
+	}
+	if len(sightings) == 0 {
+		return nil
+	}
+
+	return st.GormDB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "event_id"}, {Name: "relay_url"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_seen"}),
+	}).CreateInBatches(&sightings, 500).Error
+}
+
+// The relays that carry the event, last seen first
+func (st *Storage) GetEventRelays(ctx context.Context, eventId string) ([]EventRelay, error) {
+	var relays []EventRelay
+	err := st.GormDB.WithContext(ctx).Where("event_id = ?", eventId).Order("last_seen DESC").Find(&relays).Error
+
+	return relays, err
+}
+
+/**
+ * Relays to put in the e tag of a reply, so other clients know where to find the note.
+ */
+func (st *Storage) GetRelayHints(ctx context.Context, eventId string, limit int) []string {
+	var hints []string
+	st.GormDB.WithContext(ctx).Model(&EventRelay{}).
+		Where("event_id = ?", eventId).
+		Order("last_seen DESC").
+		Limit(limit).
+		Pluck("relay_url", &hints)
+
+	return hints
+}
+
+/**
+ * Our own notes that we never saw on the relay, newest first. These should be published there again.
+ */
+func (st *Storage) GetOwnNotesMissingFromRelay(ctx context.Context, relayUrl string, limit int) ([]Note, error) {
+	var notes []Note
+	err := st.GormDB.WithContext(ctx).Model(&Note{}).
+		Where("pubkey = ?", st.Pubkey).
+		Where("NOT EXISTS (SELECT 1 FROM event_relays er WHERE er.event_id = notes.event_id AND er.relay_url = ?)", nostr.NormalizeURL(relayUrl)).
+		Order("event_created_at DESC").
+		Limit(limit).
+		Find(&notes).Error
+
+	return notes, err
+}
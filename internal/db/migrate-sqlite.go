@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+/**
+ * golang-migrate has a sqlite driver, but it brings its own sqlite which registers the same driver name as
+ * the one gorm uses. This one runs the migrations on the connection of the storage, with the same
+ * schema_migrations table as the other golang-migrate drivers.
+ */
+type sqliteMigrations struct {
+	db     *sql.DB
+	locked atomic.Bool
+}
+
+func newSqliteMigrations(db *sql.DB) (*sqliteMigrations, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteMigrations{db: db}, nil
+}
+
+func (m *sqliteMigrations) Open(url string) (database.Driver, error) {
+	return nil, errors.New("the sqlite migrations use the connection of the storage")
+}
+
+// The connection belongs to the storage
+func (m *sqliteMigrations) Close() error {
+	return nil
+}
+
+// Sqlite is only used by us, so we only have to keep out our own goroutines
+func (m *sqliteMigrations) Lock() error {
+	if !m.locked.CompareAndSwap(false, true) {
+		return database.ErrLocked
+	}
+	return nil
+}
+
+func (m *sqliteMigrations) Unlock() error {
+	if !m.locked.CompareAndSwap(true, false) {
+		return database.ErrNotLocked
+	}
+	return nil
+}
+
+// A migration is applied completely or not at all
+func (m *sqliteMigrations) Run(migration io.Reader) error {
+	query, err := io.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(string(query)); err != nil {
+		tx.Rollback()
+		return &database.Error{OrigErr: err, Query: query}
+	}
+	return tx.Commit()
+}
+
+func (m *sqliteMigrations) SetVersion(version int, dirty bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM schema_migrations`); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Like the other drivers, no row when there is no migration and it is not dirty
+	if version >= 0 || (version == database.NilVersion && dirty) {
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`, version, dirty); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *sqliteMigrations) Version() (int, bool, error) {
+	var version int
+	var dirty bool
+	err := m.db.QueryRow(`SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.NilVersion, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return version, dirty, nil
+}
+
+// Drop all the tables and views, a virtual table goes first because it drops its own tables
+func (m *sqliteMigrations) Drop() error {
+	rows, err := m.db.Query(`SELECT type, name FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'
+		ORDER BY sql LIKE 'CREATE VIRTUAL%' DESC`)
+	if err != nil {
+		return err
+	}
+	var drops []string
+	for rows.Next() {
+		var kind, name string
+		if err := rows.Scan(&kind, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		drops = append(drops, `DROP `+kind+` IF EXISTS "`+name+`"`)
+	}
+	rows.Close()
+
+	for _, drop := range drops {
+		if _, err := m.db.Exec(drop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
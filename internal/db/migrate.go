@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"gorm.io/gorm"
+)
+
+// Every backend has its own migrations, sqlite starts at the version postgres was at when it was added
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+/**
+ * The gorm entities and the tables or views they are read from, these must have the same columns
+ */
+var entities = []interface{}{
+	&Relay{}, &Note{}, &Notification{}, &Profile{}, &Block{}, &Follow{}, &Seen{}, &Tree{}, &Bookmark{},
+	&Reaction{}, &Report{}, &RelaySyncState{}, &Backfill{}, &MissingEvent{}, &RelayHealth{},
+	&OutboxEvent{}, &OutboxDelivery{}, &EventRelay{}, &ContactList{}, &SpamCluster{}, &ContentFingerprint{},
+	&NotificationSetting{}, &EventCounter{}, &CounterSource{}, &NotesAndProfiles{},
+}
+
+// Columns that are only used in the where clause of queries, the entities do not need them
+var queryColumns = map[string]bool{"notes.search": true}
+
+type MigrationStatus struct {
+	Version uint `json:"version"` // 0 when no migration is applied
+	Dirty   bool `json:"dirty"`   // The last migration failed halfway and must be fixed by hand
+	Latest  uint `json:"latest"`
+}
+
+func (st *Storage) migrationSource() (source.Driver, error) {
+	files, err := fs.Sub(migrationFiles, "migrations/"+st.DbConfig.Driver)
+	if err != nil {
+		return nil, err
+	}
+	return iofs.New(files, ".")
+}
+
+/**
+ * The migrations of the backend we are connected to. Postgres gets its own connection, because
+ * golang-migrate closes it when it is done.
+ */
+func (st *Storage) migrations() (*migrate.Migrate, error) {
+	src, err := st.migrationSource()
+	if err != nil {
+		return nil, err
+	}
+
+	var driver database.Driver
+	switch st.DbConfig.Driver {
+	case Sqlite:
+		sqlDB, err := st.GormDB.DB()
+		if err != nil {
+			return nil, err
+		}
+		driver, err = newSqliteMigrations(sqlDB)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		sqlDB, err := sql.Open("postgres", postgresDsn(st.DbConfig))
+		if err != nil {
+			return nil, err
+		}
+		driver, err = postgres.WithInstance(sqlDB, &postgres.Config{})
+		if err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+	}
+
+	return migrate.NewWithInstance("iofs", src, st.DbConfig.Driver, driver)
+}
+
+// Apply all the migrations we do not have yet
+func (st *Storage) MigrateUp() error {
+	m, err := st.migrations()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// Undo the last steps migrations
+func (st *Storage) MigrateDown(steps int) error {
+	m, err := st.migrations()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) GetMigrationStatus() (MigrationStatus, error) {
+	status := MigrationStatus{}
+
+	m, err := st.migrations()
+	if err != nil {
+		return status, err
+	}
+	defer m.Close()
+
+	status.Version, status.Dirty, err = m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return status, err
+	}
+
+	src, err := st.migrationSource()
+	if err != nil {
+		return status, err
+	}
+	defer src.Close()
+	for version, err := src.First(); err == nil; version, err = src.Next(version) {
+		status.Latest = version
+	}
+
+	return status, nil
+}
+
+/**
+ * Compare the columns of the entities with the columns of their tables. A column that is only in the entity or
+ * only in the table is an error, so an entity that was changed without a migration is found.
+ */
+func (st *Storage) CheckSchema(ctx context.Context) error {
+	errs := make([]error, 0)
+	for _, entity := range entities {
+		stmt := &gorm.Statement{DB: st.GormDB}
+		if err := stmt.Parse(entity); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+
+		columnTypes, err := st.GormDB.WithContext(ctx).Migrator().ColumnTypes(entity)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", table, err))
+			continue
+		}
+		if len(columnTypes) == 0 {
+			errs = append(errs, fmt.Errorf("%s does not exist", table))
+			continue
+		}
+
+		columns := make(map[string]bool, len(columnTypes))
+		for _, columnType := range columnTypes {
+			columns[columnType.Name()] = true
+		}
+		for _, name := range stmt.Schema.DBNames {
+			if !columns[name] {
+				errs = append(errs, fmt.Errorf("%s.%s is in the entity but not in the table", table, name))
+			}
+			delete(columns, name)
+		}
+		extra := make([]string, 0, len(columns))
+		for name := range columns {
+			if queryColumns[table+"."+name] {
+				continue
+			}
+			extra = append(extra, name)
+		}
+		sort.Strings(extra)
+		for _, name := range extra {
+			errs = append(errs, fmt.Errorf("%s.%s is in the table but not in the entity", table, name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -1 +0,0 @@
-DROP TABLE IF EXISTS notes;
\ No newline at end of file
@@ -1,6 +1,5 @@
-ALTER SCHEMA public OWNER TO nostr;
 
-CREATE IF NOT EXISTS TABLE public.notes (
+CREATE TABLE IF NOT EXISTS public.notes (
     id bigint NOT NULL,
     event_id text NOT NULL,
     pubkey character varying(100) NOT NULL,
@@ -22,7 +21,6 @@ CREATE IF NOT EXISTS TABLE public.notes (
 );
 
 
-ALTER TABLE public.notes OWNER TO nostr;
 COMMENT ON COLUMN public.notes.root IS 'Is this the root note';
 
 CREATE SEQUENCE public.notes_id_seq
@@ -32,6 +30,5 @@ CREATE SEQUENCE public.notes_id_seq
     NO MAXVALUE
     CACHE 1;
 
-ALTER SEQUENCE public.notes_id_seq OWNER TO nostr;
 
 ALTER SEQUENCE public.notes_id_seq OWNED BY public.notes.id;
\ No newline at end of file
@@ -24,7 +24,6 @@ CREATE TABLE IF NOT EXISTS public.profiles (
     is_followed boolean
 );
 
-ALTER TABLE public.profiles OWNER TO nostr;
 
 CREATE SEQUENCE public.profiles_id_seq
     AS integer
@@ -34,5 +33,4 @@ CREATE SEQUENCE public.profiles_id_seq
     NO MAXVALUE
     CACHE 1;
 
-ALTER SEQUENCE public.profiles_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.profiles_id_seq OWNED BY public.profiles.id;
\ No newline at end of file
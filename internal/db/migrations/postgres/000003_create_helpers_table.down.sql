@@ -0,0 +1,34 @@
+DROP TRIGGER IF EXISTS delete_trigger ON public.notes;
+DROP FUNCTION IF EXISTS public.delete_submission();
+
+-- The sequences are owned by the tables and go with them
+DROP TABLE IF EXISTS public.reactions;
+DROP TABLE IF EXISTS public.notifications;
+DROP TABLE IF EXISTS public.trees;
+DROP TABLE IF EXISTS public.seens;
+DROP TABLE IF EXISTS public.relays;
+DROP TABLE IF EXISTS public.follows;
+DROP TABLE IF EXISTS public.bookmarks;
+DROP TABLE IF EXISTS public.blocks;
+
+-- The constraints, indexes and defaults this migration put on notes and profiles
+ALTER TABLE IF EXISTS public.notes DROP CONSTRAINT IF EXISTS fk_profiles_notes;
+ALTER TABLE IF EXISTS public.notes DROP CONSTRAINT IF EXISTS notes_event_id_key;
+ALTER TABLE IF EXISTS public.notes DROP CONSTRAINT IF EXISTS notes_pkey;
+ALTER TABLE IF EXISTS public.notes ALTER COLUMN id DROP DEFAULT;
+ALTER TABLE IF EXISTS public.profiles DROP CONSTRAINT IF EXISTS profiles_pubkey_key;
+ALTER TABLE IF EXISTS public.profiles DROP CONSTRAINT IF EXISTS profiles_pkey1;
+ALTER TABLE IF EXISTS public.profiles ALTER COLUMN id DROP DEFAULT;
+
+DROP INDEX IF EXISTS public.idx_notes_etags;
+DROP INDEX IF EXISTS public.idx_notes_event_id;
+DROP INDEX IF EXISTS public.idx_notes_kind;
+DROP INDEX IF EXISTS public.idx_notes_ptags;
+DROP INDEX IF EXISTS public.idx_notes_pubkey;
+DROP INDEX IF EXISTS public.idx_notes_root;
+DROP INDEX IF EXISTS public.idx_notes_urls;
+DROP INDEX IF EXISTS public.idx_profile_pubkey;
+DROP INDEX IF EXISTS public.idx_profile_urls;
+DROP INDEX IF EXISTS public.idx_profiles_pubkey;
+
+DROP TYPE IF EXISTS public.vote;
@@ -3,7 +3,6 @@ CREATE TYPE public.vote AS ENUM (
     'dislike'
 );
 
-ALTER TYPE public.vote OWNER TO nostr;
 
 CREATE FUNCTION public.delete_submission() RETURNS trigger
     LANGUAGE plpgsql
@@ -18,7 +17,6 @@ CREATE FUNCTION public.delete_submission() RETURNS trigger
 	$$;
 
 
-ALTER FUNCTION public.delete_submission() OWNER TO nostr;
 
 -- Block anoying user
 CREATE TABLE public.blocks (
@@ -28,7 +26,6 @@ CREATE TABLE public.blocks (
     updated_at timestamp with time zone
 );
 
-ALTER TABLE public.blocks OWNER TO nostr;
 
 CREATE SEQUENCE public.blocks_id_seq
     START WITH 1
@@ -37,7 +34,6 @@ CREATE SEQUENCE public.blocks_id_seq
     NO MAXVALUE
     CACHE 1;
 
-ALTER SEQUENCE public.blocks_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.blocks_id_seq OWNED BY public.blocks.id;
 
 -- Bookmark interesting notes
@@ -49,7 +45,6 @@ CREATE TABLE public.bookmarks (
     note_id bigint
 );
 
-ALTER TABLE public.bookmarks OWNER TO nostr;
 CREATE SEQUENCE public.bookmarks_id_seq
     START WITH 1
     INCREMENT BY 1
@@ -57,7 +52,6 @@ CREATE SEQUENCE public.bookmarks_id_seq
     NO MAXVALUE
     CACHE 1;
 
-ALTER SEQUENCE public.bookmarks_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.bookmarks_id_seq OWNED BY public.bookmarks.id;
 
 -- Follow interesting users
@@ -67,14 +61,12 @@ CREATE TABLE public.follows (
     created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
     updated_at timestamp with time zone
 );
-ALTER TABLE public.follows OWNER TO nostr;
 CREATE SEQUENCE public.follows_id_seq
     START WITH 1
     INCREMENT BY 1
     NO MINVALUE
     NO MAXVALUE
     CACHE 1;
-ALTER SEQUENCE public.follows_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.follows_id_seq OWNED BY public.follows.id;
 
 -- Notications for you (someone responded on your post)
@@ -85,14 +77,12 @@ CREATE TABLE public.notifications (
     created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
     updated_at timestamp with time zone
 );
-ALTER TABLE public.notifications OWNER TO nostr;
 CREATE SEQUENCE public.notifications_id_seq
     START WITH 1
     INCREMENT BY 1
     NO MINVALUE
     NO MAXVALUE
     CACHE 1;
-ALTER SEQUENCE public.notifications_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.notifications_id_seq OWNED BY public.notifications.id;
 
 -- The likes and dislikes
@@ -107,14 +97,12 @@ CREATE TABLE public.reactions (
     created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
     updated_at timestamp with time zone
 );
-ALTER TABLE public.reactions OWNER TO nostr;
 CREATE SEQUENCE public.reactions_id_seq
     START WITH 1
     INCREMENT BY 1
     NO MINVALUE
     NO MAXVALUE
     CACHE 1;
-ALTER SEQUENCE public.reactions_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.reactions_id_seq OWNED BY public.reactions.id;
 
 -- Which realays should be used for getting the notes and profiles
@@ -127,14 +115,12 @@ CREATE TABLE public.relays (
     created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
     updated_at timestamp with time zone
 );
-ALTER TABLE public.relays OWNER TO nostr;
 CREATE SEQUENCE public.relays_id_seq
     START WITH 1
     INCREMENT BY 1
     NO MINVALUE
     NO MAXVALUE
     CACHE 1;
-ALTER SEQUENCE public.relays_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.relays_id_seq OWNED BY public.relays.id;
 
 -- Which notes was last seen
@@ -145,14 +131,12 @@ CREATE TABLE public.seens (
     updated_at timestamp with time zone,
     note_id bigint
 );
-ALTER TABLE public.seens OWNER TO nostr;
 CREATE SEQUENCE public.seens_id_seq
     START WITH 1
     INCREMENT BY 1
     NO MINVALUE
     NO MAXVALUE
     CACHE 1;
-ALTER SEQUENCE public.seens_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.seens_id_seq OWNED BY public.seens.id;
 
 
@@ -165,14 +149,12 @@ CREATE TABLE public.trees (
     created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
     updated_at timestamp with time zone
 );
-ALTER TABLE public.trees OWNER TO nostr;
 CREATE SEQUENCE public.trees_id_seq
     START WITH 1
     INCREMENT BY 1
     NO MINVALUE
     NO MAXVALUE
     CACHE 1;
-ALTER SEQUENCE public.trees_id_seq OWNER TO nostr;
 ALTER SEQUENCE public.trees_id_seq OWNED BY public.trees.id;
 
 
@@ -221,7 +203,7 @@ ALTER TABLE ONLY public.notifications ALTER COLUMN id SET DEFAULT nextval('publi
 -- Name: profiles id; Type: DEFAULT; Schema: public; Owner: nostr
 --
 
-ALTER TABLE ONLY public.profiles ALTER COLUMN id SET DEFAULT nextval('public.profiles_id_seq1'::regclass);
+ALTER TABLE ONLY public.profiles ALTER COLUMN id SET DEFAULT nextval('public.profiles_id_seq'::regclass);
 
 
 --
@@ -0,0 +1 @@
+DROP VIEW IF EXISTS public.notes_and_profiles;
@@ -0,0 +1,19 @@
+-- Reports (NIP-56 kind 1984) from ourselves and the people we follow
+CREATE TABLE IF NOT EXISTS public.reports (
+    id bigserial PRIMARY KEY,
+    event_id character varying(100) NOT NULL,
+    pubkey character varying(100) NOT NULL,
+    target_pubkey character varying(100) NOT NULL,
+    target_event_id character varying(100) DEFAULT '' NOT NULL,
+    report_type character varying(50) NOT NULL,
+    content text,
+    event_created_at bigint NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone,
+    CONSTRAINT reports_event_id_key UNIQUE (event_id)
+);
+
+
+CREATE INDEX IF NOT EXISTS idx_reports_pubkey ON public.reports USING btree (pubkey);
+CREATE INDEX IF NOT EXISTS idx_reports_target_pubkey ON public.reports USING btree (target_pubkey);
+CREATE INDEX IF NOT EXISTS idx_reports_target_event_id ON public.reports USING btree (target_event_id);
@@ -0,0 +1,12 @@
+-- Until when every relay is synced, per filter
+CREATE TABLE IF NOT EXISTS public.relay_sync_state (
+    id bigserial PRIMARY KEY,
+    relay_url character varying(255) NOT NULL,
+    filter_key character varying(100) NOT NULL,
+    since bigint DEFAULT 0 NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone
+);
+
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_relay_sync_state_relay_filter ON public.relay_sync_state USING btree (relay_url, filter_key);
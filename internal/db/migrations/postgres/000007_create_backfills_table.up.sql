@@ -0,0 +1,23 @@
+-- History of followed authors we still need to get from the relays
+CREATE TABLE IF NOT EXISTS public.backfills (
+    id bigserial PRIMARY KEY,
+    pubkey character varying(100) NOT NULL,
+    status character varying(20) DEFAULT 'pending' NOT NULL,
+    until bigint DEFAULT 0 NOT NULL,
+    stop_at bigint DEFAULT 0 NOT NULL,
+    max_notes integer DEFAULT 0 NOT NULL,
+    notes_fetched integer DEFAULT 0 NOT NULL,
+    pages integer DEFAULT 0 NOT NULL,
+    last_error text DEFAULT '' NOT NULL,
+    started_at timestamp with time zone,
+    finished_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone,
+    CONSTRAINT backfills_pubkey_key UNIQUE (pubkey)
+);
+
+
+COMMENT ON COLUMN public.backfills.until IS 'Everything newer is done';
+COMMENT ON COLUMN public.backfills.stop_at IS 'Do not go back further then this';
+
+CREATE INDEX IF NOT EXISTS idx_backfills_status ON public.backfills USING btree (status);
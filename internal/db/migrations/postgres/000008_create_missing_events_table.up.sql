@@ -0,0 +1,15 @@
+-- Root and reply notes we do not have yet and still need to get from the relays
+CREATE TABLE IF NOT EXISTS public.missing_events (
+    id bigserial PRIMARY KEY,
+    event_id character varying(100) NOT NULL,
+    referenced_by character varying(100) NOT NULL,
+    relay_hints text[],
+    attempts integer DEFAULT 0 NOT NULL,
+    next_retry_at bigint DEFAULT 0 NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone,
+    CONSTRAINT missing_events_event_id_key UNIQUE (event_id)
+);
+
+
+CREATE INDEX IF NOT EXISTS idx_missing_events_next_retry_at ON public.missing_events USING btree (next_retry_at);
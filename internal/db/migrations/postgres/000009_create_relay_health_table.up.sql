@@ -0,0 +1,17 @@
+-- Connect latency, errors and backoff per relay
+CREATE TABLE IF NOT EXISTS public.relay_health (
+    id bigserial PRIMARY KEY,
+    url character varying(255) NOT NULL,
+    latency_ms bigint DEFAULT 0 NOT NULL,
+    successes bigint DEFAULT 0 NOT NULL,
+    errors bigint DEFAULT 0 NOT NULL,
+    consecutive_failures integer DEFAULT 0 NOT NULL,
+    last_error text DEFAULT ''::text NOT NULL,
+    last_success_at timestamp with time zone,
+    last_error_at timestamp with time zone,
+    retry_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone,
+    CONSTRAINT relay_health_url_key UNIQUE (url)
+);
+
@@ -0,0 +1 @@
+ALTER TABLE public.relay_health DROP COLUMN IF EXISTS rejected;
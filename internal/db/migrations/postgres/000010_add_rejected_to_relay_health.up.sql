@@ -0,0 +1,2 @@
+-- Events with a bad id or signature per relay
+ALTER TABLE public.relay_health ADD COLUMN IF NOT EXISTS rejected bigint DEFAULT 0 NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.idx_profiles_fetched_at;
+ALTER TABLE public.profiles DROP COLUMN IF EXISTS fetched_at;
+ALTER TABLE public.profiles DROP COLUMN IF EXISTS event_created_at;
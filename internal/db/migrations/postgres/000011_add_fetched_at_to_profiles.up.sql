@@ -0,0 +1,7 @@
+-- created_at of the kind 0 event, so an older event does not replace a newer profile, and when we last got it
+ALTER TABLE public.profiles ADD COLUMN IF NOT EXISTS event_created_at bigint DEFAULT 0 NOT NULL;
+ALTER TABLE public.profiles ADD COLUMN IF NOT EXISTS fetched_at timestamp with time zone;
+
+UPDATE public.profiles SET event_created_at = (raw->>'created_at')::bigint WHERE raw ? 'created_at';
+
+CREATE INDEX IF NOT EXISTS idx_profiles_fetched_at ON public.profiles USING btree (fetched_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.outbox_deliveries;
+DROP TABLE IF EXISTS public.outbox;
@@ -0,0 +1,28 @@
+-- Signed events we created and their delivery to every write relay
+CREATE TABLE IF NOT EXISTS public.outbox (
+    id bigserial PRIMARY KEY,
+    event_id character varying(100) NOT NULL,
+    kind integer NOT NULL,
+    raw jsonb NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone,
+    CONSTRAINT outbox_event_id_key UNIQUE (event_id)
+);
+
+
+CREATE TABLE IF NOT EXISTS public.outbox_deliveries (
+    id bigserial PRIMARY KEY,
+    event_id character varying(100) NOT NULL,
+    relay_url character varying(255) NOT NULL,
+    status character varying(20) DEFAULT 'pending'::character varying NOT NULL,
+    message text DEFAULT ''::text NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    next_retry_at bigint DEFAULT 0 NOT NULL,
+    delivered_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone
+);
+
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_outbox_deliveries_event_relay ON public.outbox_deliveries USING btree (event_id, relay_url);
+CREATE INDEX IF NOT EXISTS idx_outbox_deliveries_status ON public.outbox_deliveries USING btree (status);
@@ -0,0 +1,15 @@
+ALTER TABLE public.notes ADD COLUMN IF NOT EXISTS urls text[];
+ALTER TABLE public.profiles ADD COLUMN IF NOT EXISTS urls text[];
+
+UPDATE public.notes SET urls = er.urls
+    FROM (SELECT event_id, array_agg(relay_url) AS urls FROM public.event_relays GROUP BY event_id) er
+    WHERE er.event_id = notes.event_id;
+
+UPDATE public.profiles SET urls = er.urls
+    FROM (SELECT event_id, array_agg(relay_url) AS urls FROM public.event_relays GROUP BY event_id) er
+    WHERE er.event_id = profiles.raw->>'id';
+
+CREATE INDEX IF NOT EXISTS idx_notes_urls ON public.notes USING gin (urls);
+CREATE INDEX IF NOT EXISTS idx_profile_urls ON public.profiles USING gin (urls);
+
+DROP TABLE IF EXISTS public.event_relays;
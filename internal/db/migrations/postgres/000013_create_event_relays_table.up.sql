@@ -0,0 +1,25 @@
+-- On which relays we saw an event, this replaces the urls arrays of notes and profiles
+CREATE TABLE IF NOT EXISTS public.event_relays (
+    id bigserial PRIMARY KEY,
+    event_id character varying(100) NOT NULL,
+    relay_url character varying(255) NOT NULL,
+    first_seen timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    last_seen timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_event_relays_event_relay ON public.event_relays USING btree (event_id, relay_url);
+CREATE INDEX IF NOT EXISTS idx_event_relays_relay_url ON public.event_relays USING btree (relay_url);
+
+INSERT INTO public.event_relays (event_id, relay_url, first_seen, last_seen)
+    SELECT event_id, unnest(urls), COALESCE(created_at, CURRENT_TIMESTAMP), COALESCE(updated_at, created_at, CURRENT_TIMESTAMP)
+    FROM public.notes WHERE urls IS NOT NULL
+    ON CONFLICT DO NOTHING;
+
+INSERT INTO public.event_relays (event_id, relay_url, first_seen, last_seen)
+    SELECT raw->>'id', unnest(urls), COALESCE(created_at, CURRENT_TIMESTAMP), COALESCE(updated_at, created_at, CURRENT_TIMESTAMP)
+    FROM public.profiles WHERE urls IS NOT NULL AND raw ? 'id'
+    ON CONFLICT DO NOTHING;
+
+ALTER TABLE public.notes DROP COLUMN IF EXISTS urls;
+ALTER TABLE public.profiles DROP COLUMN IF EXISTS urls;
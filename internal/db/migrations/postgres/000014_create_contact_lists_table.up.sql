@@ -0,0 +1,14 @@
+-- The newest kind 3 list of every author we know, the follows are used for the follower count
+CREATE TABLE IF NOT EXISTS public.contact_lists (
+    id bigserial PRIMARY KEY,
+    pubkey character varying(100) NOT NULL,
+    event_id character varying(100) NOT NULL,
+    event_created_at bigint NOT NULL,
+    follows text[],
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone
+);
+
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_contact_lists_pubkey ON public.contact_lists USING btree (pubkey);
+CREATE INDEX IF NOT EXISTS idx_contact_lists_follows ON public.contact_lists USING gin (follows);
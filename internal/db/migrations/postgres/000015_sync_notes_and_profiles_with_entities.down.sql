@@ -0,0 +1,5 @@
+ALTER TABLE public.profiles ADD COLUMN IF NOT EXISTS is_followed boolean;
+ALTER TABLE public.profiles ADD COLUMN IF NOT EXISTS url character varying(255);
+
+DROP INDEX IF EXISTS public.idx_notes_content_hash;
+ALTER TABLE public.notes DROP COLUMN IF EXISTS content_hash;
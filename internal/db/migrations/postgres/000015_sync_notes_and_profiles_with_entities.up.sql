@@ -0,0 +1,8 @@
+-- The hash of the content is used to skip notes we already have with an other id
+ALTER TABLE public.notes ADD COLUMN IF NOT EXISTS content_hash text;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notes_content_hash ON public.notes USING btree (content_hash);
+COMMENT ON COLUMN public.notes.content_hash IS 'content hash';
+
+-- Never used by the code
+ALTER TABLE public.profiles DROP COLUMN IF EXISTS url;
+ALTER TABLE public.profiles DROP COLUMN IF EXISTS is_followed;
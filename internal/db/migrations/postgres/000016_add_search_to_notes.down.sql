@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.idx_notes_search;
+ALTER TABLE public.notes DROP COLUMN IF EXISTS search;
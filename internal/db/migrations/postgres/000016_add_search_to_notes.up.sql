@@ -0,0 +1,3 @@
+-- Full-text search over the content of the notes. The simple configuration does not stem, notes are in every language
+ALTER TABLE public.notes ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, ''))) STORED;
+CREATE INDEX IF NOT EXISTS idx_notes_search ON public.notes USING gin (search);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.content_fingerprints;
+DROP TABLE IF EXISTS public.spam_clusters;
@@ -0,0 +1,40 @@
+-- Groups of near-duplicate notes from different authors
+CREATE TABLE IF NOT EXISTS public.spam_clusters (
+    id bigserial PRIMARY KEY,
+    simhash bigint NOT NULL,
+    authors integer DEFAULT 0 NOT NULL,
+    notes integer DEFAULT 0 NOT NULL,
+    garbage boolean DEFAULT false NOT NULL,
+    sample text DEFAULT '' NOT NULL,
+    first_seen bigint DEFAULT 0 NOT NULL,
+    last_seen bigint DEFAULT 0 NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone
+);
+
+
+CREATE INDEX IF NOT EXISTS idx_spam_clusters_last_seen ON public.spam_clusters USING btree (last_seen);
+
+-- The simhash of the content of a note, split in 4 bands of 16 bits to find the near duplicates
+CREATE TABLE IF NOT EXISTS public.content_fingerprints (
+    id bigserial PRIMARY KEY,
+    note_id bigint NOT NULL,
+    pubkey character varying(100) NOT NULL,
+    simhash bigint NOT NULL,
+    band0 integer NOT NULL,
+    band1 integer NOT NULL,
+    band2 integer NOT NULL,
+    band3 integer NOT NULL,
+    cluster_id bigint,
+    event_created_at bigint NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT content_fingerprints_note_id_key UNIQUE (note_id)
+);
+
+
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_band0 ON public.content_fingerprints USING btree (band0);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_band1 ON public.content_fingerprints USING btree (band1);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_band2 ON public.content_fingerprints USING btree (band2);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_band3 ON public.content_fingerprints USING btree (band3);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_cluster_id ON public.content_fingerprints USING btree (cluster_id);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_event_created_at ON public.content_fingerprints USING btree (event_created_at);
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS public.notification_settings;
+
+DROP INDEX IF EXISTS public.idx_notifications_note_id;
+DROP INDEX IF EXISTS public.idx_notifications_target_event_id;
+DROP INDEX IF EXISTS public.idx_notifications_type_event;
+
+DELETE FROM public.notifications WHERE note_id IS NULL OR type NOT IN ('mention', 'reply');
+ALTER TABLE public.notifications DROP COLUMN IF EXISTS event_created_at;
+ALTER TABLE public.notifications DROP COLUMN IF EXISTS amount;
+ALTER TABLE public.notifications DROP COLUMN IF EXISTS content;
+ALTER TABLE public.notifications DROP COLUMN IF EXISTS pubkey;
+ALTER TABLE public.notifications DROP COLUMN IF EXISTS target_event_id;
+ALTER TABLE public.notifications DROP COLUMN IF EXISTS event_id;
+ALTER TABLE public.notifications DROP COLUMN IF EXISTS type;
+ALTER TABLE public.notifications ALTER COLUMN note_id SET NOT NULL;
@@ -0,0 +1,34 @@
+-- Notifications of every kind: mentions, replies, reactions, reposts, zaps and new followers. Only the mentions and
+-- replies are a note, so note_id is optional now.
+ALTER TABLE public.notifications ALTER COLUMN note_id DROP NOT NULL;
+ALTER TABLE public.notifications ADD COLUMN IF NOT EXISTS type character varying(20) DEFAULT 'mention' NOT NULL;
+ALTER TABLE public.notifications ADD COLUMN IF NOT EXISTS event_id character varying(100) DEFAULT '' NOT NULL;
+ALTER TABLE public.notifications ADD COLUMN IF NOT EXISTS target_event_id character varying(100) DEFAULT '' NOT NULL;
+ALTER TABLE public.notifications ADD COLUMN IF NOT EXISTS pubkey character varying(100) DEFAULT '' NOT NULL;
+ALTER TABLE public.notifications ADD COLUMN IF NOT EXISTS content text DEFAULT '' NOT NULL;
+ALTER TABLE public.notifications ADD COLUMN IF NOT EXISTS amount bigint DEFAULT 0 NOT NULL;
+ALTER TABLE public.notifications ADD COLUMN IF NOT EXISTS event_created_at bigint DEFAULT 0 NOT NULL;
+
+COMMENT ON COLUMN public.notifications.target_event_id IS 'The note the notification is about, they are grouped by it';
+COMMENT ON COLUMN public.notifications.amount IS 'Millisats of a zap';
+
+-- The notifications we have are mentions and replies, their note is the target
+UPDATE public.notifications SET event_id = notes.event_id, target_event_id = notes.event_id, pubkey = notes.pubkey,
+    event_created_at = notes.event_created_at
+    FROM public.notes WHERE notes.id = notifications.note_id;
+DELETE FROM public.notifications WHERE event_id = '';
+DELETE FROM public.notifications a USING public.notifications b WHERE a.id > b.id AND a.event_id = b.event_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_type_event ON public.notifications USING btree (type, event_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_target_event_id ON public.notifications USING btree (target_event_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_note_id ON public.notifications USING btree (note_id);
+
+-- The types of notifications that are muted
+CREATE TABLE IF NOT EXISTS public.notification_settings (
+    id bigserial PRIMARY KEY,
+    type character varying(20) NOT NULL,
+    muted boolean DEFAULT false NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone,
+    CONSTRAINT notification_settings_type_key UNIQUE (type)
+);
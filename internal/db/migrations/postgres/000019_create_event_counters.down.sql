@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.counter_sources;
+DROP TABLE IF EXISTS public.event_counters;
@@ -0,0 +1,48 @@
+-- The reactions, replies, reposts and zaps of a note, counted when they come in
+CREATE TABLE IF NOT EXISTS public.event_counters (
+    id bigserial PRIMARY KEY,
+    event_id character varying(100) NOT NULL,
+    likes bigint DEFAULT 0 NOT NULL,
+    dislikes bigint DEFAULT 0 NOT NULL,
+    emojis bigint DEFAULT 0 NOT NULL,
+    replies bigint DEFAULT 0 NOT NULL,
+    reposts bigint DEFAULT 0 NOT NULL,
+    zaps bigint DEFAULT 0 NOT NULL,
+    zap_amount bigint DEFAULT 0 NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone,
+    CONSTRAINT event_counters_event_id_key UNIQUE (event_id)
+);
+
+-- The events that are counted, so an event we get from more relays is counted once
+CREATE TABLE IF NOT EXISTS public.counter_sources (
+    id bigserial PRIMARY KEY,
+    event_id character varying(100) NOT NULL,
+    source_event_id character varying(100) NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT counter_sources_source_event_id_key UNIQUE (source_event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_counter_sources_event_id ON public.counter_sources USING btree (event_id);
+
+-- Count the reactions and replies we already have
+INSERT INTO public.counter_sources (event_id, source_event_id)
+    SELECT target_event_id, from_event_id FROM public.reactions WHERE target_event_id <> ''
+    ON CONFLICT DO NOTHING;
+INSERT INTO public.counter_sources (event_id, source_event_id)
+    SELECT COALESCE(NULLIF(reply_event_id, ''), root_event_id), event_id FROM public.trees
+    WHERE COALESCE(NULLIF(reply_event_id, ''), root_event_id) <> ''
+    ON CONFLICT DO NOTHING;
+
+-- The counters are what is in the sources, so they are the same as the ones we count when the events come in
+INSERT INTO public.event_counters (event_id, likes, dislikes, emojis, replies)
+    SELECT sources.event_id,
+        SUM(CASE WHEN reactions.content IN ('+', '') THEN 1 ELSE 0 END),
+        SUM(CASE WHEN reactions.content = '-' THEN 1 ELSE 0 END),
+        SUM(CASE WHEN reactions.content NOT IN ('+', '', '-') THEN 1 ELSE 0 END),
+        SUM(CASE WHEN reactions.from_event_id IS NULL THEN 1 ELSE 0 END)
+    FROM public.counter_sources sources
+    LEFT JOIN (SELECT from_event_id, MIN(content) AS content FROM public.reactions GROUP BY from_event_id) reactions
+        ON (reactions.from_event_id = sources.source_event_id)
+    GROUP BY sources.event_id
+    ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+ALTER TABLE public.relay_sync_state DROP COLUMN IF EXISTS gap_until;
+ALTER TABLE public.relay_sync_state DROP COLUMN IF EXISTS gap_since;
@@ -0,0 +1,5 @@
+-- When a sync of a relay is cut off, the events between gap_since and gap_until are still missing
+ALTER TABLE public.relay_sync_state ADD COLUMN IF NOT EXISTS gap_since bigint DEFAULT 0 NOT NULL;
+ALTER TABLE public.relay_sync_state ADD COLUMN IF NOT EXISTS gap_until bigint DEFAULT 0 NOT NULL;
+
+COMMENT ON COLUMN public.relay_sync_state.gap_until IS 'The oldest event we got when the sync was cut off, 0 when there is no gap';
@@ -0,0 +1,21 @@
+DROP VIEW IF EXISTS notes_and_profiles;
+
+DROP TABLE IF EXISTS contact_lists;
+DROP TABLE IF EXISTS event_relays;
+DROP TABLE IF EXISTS outbox_deliveries;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS relay_health;
+DROP TABLE IF EXISTS missing_events;
+DROP TABLE IF EXISTS backfills;
+DROP TABLE IF EXISTS relay_sync_state;
+DROP TABLE IF EXISTS reports;
+DROP TABLE IF EXISTS reactions;
+DROP TABLE IF EXISTS bookmarks;
+DROP TABLE IF EXISTS trees;
+DROP TABLE IF EXISTS seens;
+DROP TABLE IF EXISTS follows;
+DROP TABLE IF EXISTS blocks;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS notes;
+DROP TABLE IF EXISTS profiles;
+DROP TABLE IF EXISTS relays;
@@ -0,0 +1,261 @@
+-- The sqlite backend starts where the postgres migrations are at version 15, with the same tables.
+-- New migrations get the same version for both backends.
+-- Arrays (text[]) are stored as text in the postgres array format, so pq.StringArray can read them.
+-- Timestamps must be declared as timestamp, else the driver does not give them back as time.
+
+CREATE TABLE IF NOT EXISTS relays (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    url varchar(255) NOT NULL UNIQUE,
+    read boolean DEFAULT false,
+    write boolean DEFAULT false,
+    search boolean DEFAULT false,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS profiles (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    uid varchar(36),
+    pubkey varchar(100) NOT NULL UNIQUE,
+    name varchar(255),
+    about text,
+    picture varchar(255),
+    website varchar(255),
+    nip05 varchar(255),
+    lud16 varchar(255),
+    display_name varchar(255),
+    raw text NOT NULL,
+    event_created_at bigint DEFAULT 0 NOT NULL,
+    fetched_at timestamp,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp,
+    followed boolean DEFAULT false NOT NULL,
+    blocked boolean DEFAULT false NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_profiles_fetched_at ON profiles (fetched_at);
+
+CREATE TABLE IF NOT EXISTS notes (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    uid varchar(36),
+    event_id text NOT NULL UNIQUE,
+    pubkey varchar(100) NOT NULL,
+    kind int NOT NULL,
+    event_created_at bigint NOT NULL,
+    content text,
+    tags_full text,
+    content_hash text UNIQUE,
+    ptags text,
+    etags text,
+    sig varchar(200) NOT NULL,
+    garbage boolean DEFAULT false NOT NULL,
+    raw text NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp,
+    root boolean DEFAULT false NOT NULL,
+    profile_id bigint REFERENCES profiles (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notes_pubkey ON notes (pubkey);
+CREATE INDEX IF NOT EXISTS idx_notes_kind ON notes (kind);
+CREATE INDEX IF NOT EXISTS idx_notes_root ON notes (root);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    note_id bigint NOT NULL REFERENCES notes (id),
+    seen boolean DEFAULT false,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS blocks (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    pubkey varchar(100) NOT NULL UNIQUE,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS follows (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    pubkey varchar(100) UNIQUE,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS seens (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL UNIQUE,
+    note_id bigint UNIQUE,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS trees (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL UNIQUE,
+    root_event_id varchar(100) NOT NULL,
+    reply_event_id varchar(100) NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_trees_root_event_id ON trees (root_event_id);
+CREATE INDEX IF NOT EXISTS idx_trees_reply_event_id ON trees (reply_event_id);
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL UNIQUE,
+    note_id bigint UNIQUE,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+-- There is no enum in sqlite, so current_vote is checked instead
+CREATE TABLE IF NOT EXISTS reactions (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    pubkey text NOT NULL UNIQUE,
+    content text NOT NULL,
+    current_vote text NOT NULL CHECK (current_vote IN ('like', 'dislike')),
+    target_event_id text NOT NULL UNIQUE,
+    from_event_id text NOT NULL,
+    note_id bigint REFERENCES notes (id),
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_reactions_from_event_id ON reactions (from_event_id);
+
+CREATE TABLE IF NOT EXISTS reports (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL UNIQUE,
+    pubkey varchar(100) NOT NULL,
+    target_pubkey varchar(100) NOT NULL,
+    target_event_id varchar(100) DEFAULT '' NOT NULL,
+    report_type varchar(50) NOT NULL,
+    content text,
+    event_created_at bigint NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_pubkey ON reports (pubkey);
+CREATE INDEX IF NOT EXISTS idx_reports_target_pubkey ON reports (target_pubkey);
+CREATE INDEX IF NOT EXISTS idx_reports_target_event_id ON reports (target_event_id);
+
+CREATE TABLE IF NOT EXISTS relay_sync_state (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    relay_url varchar(255) NOT NULL,
+    filter_key varchar(100) NOT NULL,
+    since bigint DEFAULT 0 NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_relay_sync_state_relay_filter ON relay_sync_state (relay_url, filter_key);
+
+CREATE TABLE IF NOT EXISTS backfills (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    pubkey varchar(100) NOT NULL UNIQUE,
+    status varchar(20) DEFAULT 'pending' NOT NULL,
+    until bigint DEFAULT 0 NOT NULL,
+    stop_at bigint DEFAULT 0 NOT NULL,
+    max_notes integer DEFAULT 0 NOT NULL,
+    notes_fetched integer DEFAULT 0 NOT NULL,
+    pages integer DEFAULT 0 NOT NULL,
+    last_error text DEFAULT '' NOT NULL,
+    started_at timestamp,
+    finished_at timestamp,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_backfills_status ON backfills (status);
+
+CREATE TABLE IF NOT EXISTS missing_events (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL UNIQUE,
+    referenced_by varchar(100) NOT NULL,
+    relay_hints text,
+    attempts integer DEFAULT 0 NOT NULL,
+    next_retry_at bigint DEFAULT 0 NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_missing_events_next_retry_at ON missing_events (next_retry_at);
+
+CREATE TABLE IF NOT EXISTS relay_health (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    url varchar(255) NOT NULL UNIQUE,
+    latency_ms bigint DEFAULT 0 NOT NULL,
+    successes bigint DEFAULT 0 NOT NULL,
+    errors bigint DEFAULT 0 NOT NULL,
+    rejected bigint DEFAULT 0 NOT NULL,
+    consecutive_failures integer DEFAULT 0 NOT NULL,
+    last_error text DEFAULT '' NOT NULL,
+    last_success_at timestamp,
+    last_error_at timestamp,
+    retry_at timestamp,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL UNIQUE,
+    kind integer NOT NULL,
+    raw text NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS outbox_deliveries (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL,
+    relay_url varchar(255) NOT NULL,
+    status varchar(20) DEFAULT 'pending' NOT NULL,
+    message text DEFAULT '' NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    next_retry_at bigint DEFAULT 0 NOT NULL,
+    delivered_at timestamp,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_outbox_deliveries_event_relay ON outbox_deliveries (event_id, relay_url);
+CREATE INDEX IF NOT EXISTS idx_outbox_deliveries_status ON outbox_deliveries (status);
+
+CREATE TABLE IF NOT EXISTS event_relays (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL,
+    relay_url varchar(255) NOT NULL,
+    first_seen timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    last_seen timestamp DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_event_relays_event_relay ON event_relays (event_id, relay_url);
+CREATE INDEX IF NOT EXISTS idx_event_relays_relay_url ON event_relays (relay_url);
+
+CREATE TABLE IF NOT EXISTS contact_lists (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    pubkey varchar(100) NOT NULL UNIQUE,
+    event_id varchar(100) NOT NULL,
+    event_created_at bigint NOT NULL,
+    follows text,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE VIEW IF NOT EXISTS notes_and_profiles AS
+    SELECT notes.id, notes.uid as note_uuid, notes.event_id, notes.pubkey, notes.kind, notes.event_created_at,
+    notes.content, notes.tags_full, notes.sig, notes.etags, notes.ptags,
+    profiles.uid as profile_uuid, profiles.name, profiles.about, profiles.picture,
+    profiles.website, profiles.nip05, profiles.lud16, profiles.display_name,
+    CASE WHEN length(follows.pubkey) > 0 THEN TRUE ELSE FALSE END followed,
+    CASE WHEN length(bookmarks.event_id) > 0 THEN TRUE ELSE FALSE END bookmarked
+    FROM notes
+    LEFT JOIN profiles ON (profiles.pubkey = notes.pubkey)
+    LEFT JOIN blocks ON (blocks.pubkey = notes.pubkey)
+    LEFT JOIN bookmarks ON (bookmarks.note_id = notes.id)
+    LEFT JOIN follows ON (follows.pubkey = notes.pubkey)
+    WHERE notes.kind = 1 AND notes.garbage = false AND notes.root = true AND blocks.pubkey IS NULL ORDER BY notes.id asc;
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS notes_search_update;
+DROP TRIGGER IF EXISTS notes_search_delete;
+DROP TRIGGER IF EXISTS notes_search_insert;
+DROP TABLE IF EXISTS notes_search;
@@ -0,0 +1,18 @@
+-- Full-text search over the content of the notes, the index only has the content and uses the id of the note as rowid
+CREATE VIRTUAL TABLE IF NOT EXISTS notes_search USING fts5(content, content='notes', content_rowid='id', tokenize='unicode61 remove_diacritics 2');
+
+CREATE TRIGGER IF NOT EXISTS notes_search_insert AFTER INSERT ON notes BEGIN
+    INSERT INTO notes_search (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_delete AFTER DELETE ON notes BEGIN
+    INSERT INTO notes_search (notes_search, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_update AFTER UPDATE OF content ON notes BEGIN
+    INSERT INTO notes_search (notes_search, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO notes_search (rowid, content) VALUES (new.id, new.content);
+END;
+
+-- The notes we already have
+INSERT INTO notes_search (notes_search) VALUES ('rebuild');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS content_fingerprints;
+DROP TABLE IF EXISTS spam_clusters;
@@ -0,0 +1,37 @@
+-- Groups of near-duplicate notes from different authors
+CREATE TABLE IF NOT EXISTS spam_clusters (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    simhash bigint NOT NULL,
+    authors integer DEFAULT 0 NOT NULL,
+    notes integer DEFAULT 0 NOT NULL,
+    garbage boolean DEFAULT false NOT NULL,
+    sample text DEFAULT '' NOT NULL,
+    first_seen bigint DEFAULT 0 NOT NULL,
+    last_seen bigint DEFAULT 0 NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_spam_clusters_last_seen ON spam_clusters (last_seen);
+
+-- The simhash of the content of a note, split in 4 bands of 16 bits to find the near duplicates
+CREATE TABLE IF NOT EXISTS content_fingerprints (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    note_id bigint NOT NULL UNIQUE,
+    pubkey varchar(100) NOT NULL,
+    simhash bigint NOT NULL,
+    band0 integer NOT NULL,
+    band1 integer NOT NULL,
+    band2 integer NOT NULL,
+    band3 integer NOT NULL,
+    cluster_id bigint,
+    event_created_at bigint NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_band0 ON content_fingerprints (band0);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_band1 ON content_fingerprints (band1);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_band2 ON content_fingerprints (band2);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_band3 ON content_fingerprints (band3);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_cluster_id ON content_fingerprints (cluster_id);
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_event_created_at ON content_fingerprints (event_created_at);
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS notification_settings;
+
+CREATE TABLE IF NOT EXISTS notifications_old (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    note_id bigint NOT NULL REFERENCES notes (id),
+    seen boolean DEFAULT false,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+INSERT INTO notifications_old (id, note_id, seen, created_at, updated_at)
+    SELECT id, note_id, seen, created_at, updated_at FROM notifications
+    WHERE note_id IS NOT NULL AND type IN ('mention', 'reply');
+
+DROP TABLE notifications;
+ALTER TABLE notifications_old RENAME TO notifications;
@@ -0,0 +1,39 @@
+-- Notifications of every kind: mentions, replies, reactions, reposts, zaps and new followers. Only the mentions and
+-- replies are a note, so note_id is optional now. Sqlite can not drop a not null, so the table is made again.
+CREATE TABLE IF NOT EXISTS notifications_new (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    note_id bigint REFERENCES notes (id),
+    type varchar(20) DEFAULT 'mention' NOT NULL,
+    event_id varchar(100) DEFAULT '' NOT NULL,
+    target_event_id varchar(100) DEFAULT '' NOT NULL,
+    pubkey varchar(100) DEFAULT '' NOT NULL,
+    content text DEFAULT '' NOT NULL,
+    amount bigint DEFAULT 0 NOT NULL,
+    event_created_at bigint DEFAULT 0 NOT NULL,
+    seen boolean DEFAULT false,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_type_event ON notifications_new (type, event_id);
+
+-- The notifications we have are mentions and replies, their note is the target
+INSERT OR IGNORE INTO notifications_new (id, note_id, event_id, target_event_id, pubkey, event_created_at, seen, created_at, updated_at)
+    SELECT notifications.id, notifications.note_id, notes.event_id, notes.event_id, notes.pubkey, notes.event_created_at,
+    notifications.seen, notifications.created_at, notifications.updated_at
+    FROM notifications JOIN notes ON (notes.id = notifications.note_id);
+
+DROP TABLE notifications;
+ALTER TABLE notifications_new RENAME TO notifications;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_target_event_id ON notifications (target_event_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_note_id ON notifications (note_id);
+
+-- The types of notifications that are muted
+CREATE TABLE IF NOT EXISTS notification_settings (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    type varchar(20) NOT NULL UNIQUE,
+    muted boolean DEFAULT false NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS counter_sources;
+DROP TABLE IF EXISTS event_counters;
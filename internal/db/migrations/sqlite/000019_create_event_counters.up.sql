@@ -0,0 +1,43 @@
+-- The reactions, replies, reposts and zaps of a note, counted when they come in
+CREATE TABLE IF NOT EXISTS event_counters (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL UNIQUE,
+    likes bigint DEFAULT 0 NOT NULL,
+    dislikes bigint DEFAULT 0 NOT NULL,
+    emojis bigint DEFAULT 0 NOT NULL,
+    replies bigint DEFAULT 0 NOT NULL,
+    reposts bigint DEFAULT 0 NOT NULL,
+    zaps bigint DEFAULT 0 NOT NULL,
+    zap_amount bigint DEFAULT 0 NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp
+);
+
+-- The events that are counted, so an event we get from more relays is counted once
+CREATE TABLE IF NOT EXISTS counter_sources (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id varchar(100) NOT NULL,
+    source_event_id varchar(100) NOT NULL UNIQUE,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_counter_sources_event_id ON counter_sources (event_id);
+
+-- Count the reactions and replies we already have
+INSERT OR IGNORE INTO counter_sources (event_id, source_event_id)
+    SELECT target_event_id, from_event_id FROM reactions WHERE target_event_id <> '';
+INSERT OR IGNORE INTO counter_sources (event_id, source_event_id)
+    SELECT COALESCE(NULLIF(reply_event_id, ''), root_event_id), event_id FROM trees
+    WHERE COALESCE(NULLIF(reply_event_id, ''), root_event_id) <> '';
+
+-- The counters are what is in the sources, so they are the same as the ones we count when the events come in
+INSERT OR IGNORE INTO event_counters (event_id, likes, dislikes, emojis, replies)
+    SELECT sources.event_id,
+        SUM(CASE WHEN reactions.content IN ('+', '') THEN 1 ELSE 0 END),
+        SUM(CASE WHEN reactions.content = '-' THEN 1 ELSE 0 END),
+        SUM(CASE WHEN reactions.content NOT IN ('+', '', '-') THEN 1 ELSE 0 END),
+        SUM(CASE WHEN reactions.from_event_id IS NULL THEN 1 ELSE 0 END)
+    FROM counter_sources sources
+    LEFT JOIN (SELECT from_event_id, MIN(content) AS content FROM reactions GROUP BY from_event_id) reactions
+        ON (reactions.from_event_id = sources.source_event_id)
+    GROUP BY sources.event_id;
@@ -0,0 +1,2 @@
+ALTER TABLE relay_sync_state DROP COLUMN gap_until;
+ALTER TABLE relay_sync_state DROP COLUMN gap_since;
@@ -0,0 +1,3 @@
+-- When a sync of a relay is cut off, the events between gap_since and gap_until are still missing
+ALTER TABLE relay_sync_state ADD COLUMN gap_since bigint DEFAULT 0 NOT NULL;
+ALTER TABLE relay_sync_state ADD COLUMN gap_until bigint DEFAULT 0 NOT NULL;
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * Queue a note we do not have yet. The relays in the e tag and the relays where we saw the
+ * referencing note are used as hints where to find it.
+ */
+func (st *Storage) AddMissingEvent(ctx context.Context, eventId string, referencedBy *Event) error {
+	hints := make([]string, 0)
+	for _, t := range referencedBy.Event.Tags {
+		if len(t) > 2 && t[0] == "e" && t[1] == eventId && t[2] != "" {
+			hints = append(hints, nostr.NormalizeURL(t[2]))
+		}
+	}
+	hints = append(hints, referencedBy.Urls...)
+
+	missing := MissingEvent{
+		EventId:      eventId,
+		ReferencedBy: referencedBy.Event.ID,
+		RelayHints:   pq.StringArray(hints),
+	}
+
+	// sqlite has no arrays, so the hints of a note that is already missing are merged here
+	return st.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var known MissingEvent
+		if err := tx.Where("event_id = ?", eventId).Limit(1).Find(&known).Error; err != nil {
+			return err
+		}
+		if known.ID == 0 {
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&missing).Error
+		}
+
+		merged := append(pq.StringArray{}, known.RelayHints...)
+		for _, hint := range hints {
+			if !slices.Contains(merged, hint) {
+				merged = append(merged, hint)
+			}
+		}
+		return tx.Model(&known).Update("relay_hints", merged).Error
+	})
+}
+
+/**
+ * Missing events we should try again, oldest first. Events tried maxAttempts times are given up.
+ */
+func (st *Storage) GetDueMissingEvents(ctx context.Context, limit int, maxAttempts int) ([]MissingEvent, error) {
+	var missing []MissingEvent
+	err := st.GormDB.WithContext(ctx).Model(&MissingEvent{}).
+		Where("next_retry_at <= ? AND attempts < ?", time.Now().Unix(), maxAttempts).
+		Order("next_retry_at ASC, id ASC").
+		Limit(limit).
+		Find(&missing).Error
+
+	return missing, err
+}
+
+// We got them, so they are not missing anymore
+func (st *Storage) ResolveMissingEvents(ctx context.Context, eventIds []string) error {
+	if len(eventIds) == 0 {
+		return nil
+	}
+	return st.GormDB.WithContext(ctx).Where("event_id IN ?", eventIds).Delete(&MissingEvent{}).Error
+}
+
+/**
+ * Not found this time. The time until the next try doubles with every attempt, with a maximum of a day.
+ */
+func (st *Storage) MissingEventsNotFound(ctx context.Context, missing []MissingEvent) error {
+	for _, m := range missing {
+		backoff := min(time.Minute*time.Duration(int64(1)<<min(m.Attempts, 12)), 24*time.Hour)
+		err := st.GormDB.WithContext(ctx).Model(&MissingEvent{}).Where("id = ?", m.ID).Updates(map[string]interface{}{
+			"attempts":      m.Attempts + 1,
+			"next_retry_at": time.Now().Add(backoff).Unix(),
+			"updated_at":    time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"amavis442/nostr-reader/internal/logger"
+	"amavis442/nostr-reader/internal/tag"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * Reported notes and pubkeys with the reasons why they were reported.
+ * Only reports of ourselves and the people we follow are counted.
+ */
+type ModerationEntry struct {
+	TargetPubkey  string         `json:"target_pubkey"`
+	TargetEventId string         `json:"target_event_id"`
+	Reasons       pq.StringArray `gorm:"type:text[]" json:"reasons"`
+	Reporters     int            `json:"reporters"`
+	Hidden        bool           `json:"hidden"`
+}
+
+/**
+ * Store a kind 1984 report. Reports from people we do not follow are ignored, because anyone can report anything.
+ * When own is true the report is ours and is always stored.
+ */
+func (st *Storage) SaveReport(ctx context.Context, ev *nostr.Event, own bool) error {
+	if ev.Kind != KindReporting {
+		return errors.New("not a report")
+	}
+
+	if !own && ev.PubKey != st.Pubkey {
+		var count int64
+		err := st.GormDB.WithContext(ctx).Model(&Follow{}).Where("pubkey = ?", ev.PubKey).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+	}
+
+	targetPubkey, targetEventId, reportType, err := tag.ProcessReportTags(ev)
+	if err != nil {
+		return err
+	}
+
+	report := Report{
+		EventId:        ev.ID,
+		Pubkey:         ev.PubKey,
+		TargetPubkey:   targetPubkey,
+		TargetEventId:  targetEventId,
+		ReportType:     reportType,
+		Content:        ev.Content,
+		EventCreatedAt: ev.CreatedAt.Time().Unix(),
+	}
+
+	err = st.GormDB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&report).Error
+	if err != nil {
+		slog.Error(logger.GetCallerInfo(1), "error", err.Error())
+		return err
+	}
+
+	slog.Info("SaveReport() -> Report stored", "reporter", report.Pubkey, "target", report.TargetPubkey, "type", report.ReportType)
+	return nil
+}
+
+/**
+ * All reported notes and pubkeys with the number of reporters and if they are hidden from the global feed.
+ */
+func (st *Storage) GetModeration(ctx context.Context) ([]ModerationEntry, error) {
+	var entries []ModerationEntry
+	err := st.GormDB.WithContext(ctx).Raw(`SELECT r.target_pubkey, r.target_event_id,
+		`+st.arrayAgg("r.report_type")+` reasons, COUNT(DISTINCT r.pubkey) reporters
+		FROM reports r
+		LEFT JOIN follows f ON (f.pubkey = r.pubkey)
+		WHERE f.pubkey IS NOT NULL OR r.pubkey = @self
+		GROUP BY r.target_pubkey, r.target_event_id
+		ORDER BY reporters DESC`, sql.Named("self", st.Pubkey)).Scan(&entries).Error
+	if err != nil {
+		return []ModerationEntry{}, err
+	}
+
+	for i := range entries {
+		entries[i].Hidden = entries[i].Reporters >= st.DbConfig.ReportThreshold
+	}
+
+	return entries, nil
+}
+
+/**
+ * The global feed should not show notes and pubkeys which are reported by enough of the people we follow.
+ * Reports on a note only hide that note, reports on a pubkey hide everything of that pubkey.
+ */
+func (st *Storage) hideReported(tx *gorm.DB, options Options) *gorm.DB {
+	if options.Follow || options.BookMark {
+		return tx
+	}
+
+	return tx.Where(`event_id NOT IN (SELECT r.target_event_id FROM reports r LEFT JOIN follows f ON (f.pubkey = r.pubkey)
+			WHERE r.target_event_id <> '' AND (f.pubkey IS NOT NULL OR r.pubkey = @self)
+			GROUP BY r.target_event_id HAVING COUNT(DISTINCT r.pubkey) >= @threshold)`,
+		sql.Named("self", st.Pubkey), sql.Named("threshold", st.DbConfig.ReportThreshold)).
+		Where(`pubkey NOT IN (SELECT r.target_pubkey FROM reports r LEFT JOIN follows f ON (f.pubkey = r.pubkey)
+			WHERE r.target_event_id = '' AND (f.pubkey IS NOT NULL OR r.pubkey = @self)
+			GROUP BY r.target_pubkey HAVING COUNT(DISTINCT r.pubkey) >= @threshold)`,
+			sql.Named("self", st.Pubkey), sql.Named("threshold", st.DbConfig.ReportThreshold))
+}
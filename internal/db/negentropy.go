@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// An event we have, as negentropy needs it
+type NegentropyItem struct {
+	EventId        string
+	EventCreatedAt int64
+}
+
+/**
+ * The notes of the authors since a certain time, this is our side of a NIP-77 reconciliation.
+ */
+func (st *Storage) GetNegentropyItems(ctx context.Context, authors []string, kinds []int, since int64) ([]NegentropyItem, error) {
+	var items []NegentropyItem
+	err := st.GormDB.WithContext(ctx).Model(&Note{}).
+		Select("event_id, event_created_at").
+		Where("pubkey IN (?) AND kind IN (?) AND event_created_at >= ?", authors, kinds, since).
+		Scan(&items).Error
+
+	return items, err
+}
+
+// The stored events, so we can give them to a relay that misses them
+func (st *Storage) GetRawNotes(ctx context.Context, ids []string) ([]*nostr.Event, error) {
+	var raws [][]byte
+	err := st.GormDB.WithContext(ctx).Model(&Note{}).Where("event_id IN (?)", ids).Pluck("raw", &raws).Error
+	if err != nil {
+		return nil, err
+	}
+
+	evs := make([]*nostr.Event, 0, len(raws))
+	for _, raw := range raws {
+		var ev nostr.Event
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			continue
+		}
+		evs = append(evs, &ev)
+	}
+	return evs, nil
+}
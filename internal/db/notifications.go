@@ -0,0 +1,457 @@
+package db
+
+import (
+	"amavis442/nostr-reader/internal/tag"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The types of notifications
+const (
+	NotificationMention  = "mention"  // A note with us in a p tag
+	NotificationReply    = "reply"    // A reply on one of our notes
+	NotificationReaction = "reaction" // A reaction on one of our notes
+	NotificationRepost   = "repost"   // A repost of one of our notes
+	NotificationZap      = "zap"      // A zap for us or one of our notes
+	NotificationFollower = "follower" // A contact list with us in it
+)
+
+var NotificationTypes = []string{NotificationMention, NotificationReply, NotificationReaction, NotificationRepost, NotificationZap, NotificationFollower}
+
+// How many notifications of a group are returned with it, the count tells how many there are
+const notificationSample = 5
+
+func IsNotificationType(notificationType string) bool {
+	for _, t := range NotificationTypes {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * A page of grouped notifications. The cursor is the latest and the last id of the last group of the
+ * previous page, both empty for the first page.
+ */
+type NotificationPage struct {
+	Types    []string // Empty is every type that is not muted
+	Unseen   bool     // Only the notifications that are not seen
+	Before   int64
+	BeforeId uint
+	Limit    int
+}
+
+/**
+ * The notifications of one type about the same note, the newest first. Followers have no note, they are
+ * one group.
+ */
+type NotificationGroup struct {
+	Type          string         `json:"type"`
+	TargetEventId string         `json:"target_event_id"`
+	Target        *Event         `json:"target,omitempty"` // Nil when we do not have the note
+	Count         int64          `json:"count"`
+	Unseen        int64          `json:"unseen"`
+	Latest        int64          `json:"latest"` // The created_at of the newest notification
+	LastId        uint           `json:"last_id"`
+	Notifications []Notification `json:"notifications"` // Only the newest ones
+}
+
+// Which notifications are marked as seen: by id, a group, or all of them
+type NotificationsSeen struct {
+	Ids           []uint `json:"ids"`
+	Type          string `json:"type"`
+	TargetEventId string `json:"target_event_id"`
+	All           bool   `json:"all"`
+}
+
+type UnseenNotifications struct {
+	Total int64            `json:"total"`
+	Types map[string]int64 `json:"types"`
+}
+
+/**
+ * Store a notification. Our own events and the events of blocked authors are no notification and an
+ * event gives only one notification of a type.
+ */
+func (st *Storage) notify(ctx context.Context, notification Notification) error {
+	if notification.Pubkey == st.Pubkey || notification.EventId == "" {
+		return nil
+	}
+
+	var blocked int64
+	if err := st.GormDB.WithContext(ctx).Model(&Block{}).Where("pubkey = ?", notification.Pubkey).Count(&blocked).Error; err != nil {
+		return err
+	}
+	if blocked > 0 {
+		return nil
+	}
+
+	return st.GormDB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&notification).Error
+}
+
+// Is the event one of our notes
+func (st *Storage) isOwnNote(ctx context.Context, eventId string) (bool, error) {
+	if eventId == "" {
+		return false, nil
+	}
+	var count int64
+	err := st.GormDB.WithContext(ctx).Model(&Note{}).Where("event_id = ? AND pubkey = ?", eventId, st.Pubkey).Count(&count).Error
+	return count > 0, err
+}
+
+/**
+ * A note that replies to one of our notes is a reply, else it is a mention when we are in its p tags.
+ */
+func (st *Storage) notifyNote(ctx context.Context, note Note, tree tag.EventTree, mentioned bool) error {
+	notification := Notification{
+		NoteID:         &note.ID,
+		Type:           NotificationMention,
+		EventId:        note.EventId,
+		TargetEventId:  note.EventId,
+		Pubkey:         note.Pubkey,
+		EventCreatedAt: note.EventCreatedAt,
+	}
+
+	parent := replyParent(tree)
+	own, err := st.isOwnNote(ctx, parent)
+	if err != nil {
+		return err
+	}
+	if own {
+		notification.Type = NotificationReply
+		notification.TargetEventId = parent
+	} else if !mentioned {
+		return nil
+	}
+
+	return st.notify(ctx, notification)
+}
+
+// A reaction on one of our notes, the content is the reaction
+func (st *Storage) notifyReaction(ctx context.Context, ev *nostr.Event, target Note) error {
+	if target.Pubkey != st.Pubkey {
+		return nil
+	}
+	return st.notify(ctx, Notification{
+		Type:           NotificationReaction,
+		EventId:        ev.ID,
+		TargetEventId:  target.EventId,
+		Pubkey:         ev.PubKey,
+		Content:        ev.Content,
+		EventCreatedAt: ev.CreatedAt.Time().Unix(),
+	})
+}
+
+// A kind 6 repost of one of our notes
+func (st *Storage) notifyRepost(ctx context.Context, ev *nostr.Event) error {
+	t := ev.Tags.GetLast([]string{"e"})
+	if t == nil {
+		return nil
+	}
+	own, err := st.isOwnNote(ctx, t.Value())
+	if err != nil || !own {
+		return err
+	}
+	return st.notify(ctx, Notification{
+		Type:           NotificationRepost,
+		EventId:        ev.ID,
+		TargetEventId:  t.Value(),
+		Pubkey:         ev.PubKey,
+		EventCreatedAt: ev.CreatedAt.Time().Unix(),
+	})
+}
+
+/**
+ * A checked kind 9735 zap receipt for us. The receipt is made by the wallet of the one who is zapped, who zapped
+ * and the message are in the zap request.
+ */
+func (st *Storage) notifyZap(ctx context.Context, ev *nostr.Event, zap zapReceipt) error {
+	if zap.Recipient != st.Pubkey {
+		return nil
+	}
+	return st.notify(ctx, Notification{
+		Type:           NotificationZap,
+		EventId:        ev.ID,
+		TargetEventId:  zap.TargetEventId,
+		Pubkey:         zap.Request.PubKey,
+		Content:        zap.Request.Content,
+		Amount:         zap.Amount,
+		EventCreatedAt: ev.CreatedAt.Time().Unix(),
+	})
+}
+
+/**
+ * The amount of a bolt11 invoice in millisats, 0 when it has none. The amount is in bitcoin between the prefix
+ * and the last 1, with an optional multiplier: lnbc25u is 25 microbitcoin.
+ */
+func bolt11Msats(invoice string) int64 {
+	invoice = strings.ToLower(invoice)
+	separator := strings.LastIndex(invoice, "1")
+	if !strings.HasPrefix(invoice, "ln") || separator < 0 {
+		return 0
+	}
+	hrp := invoice[2:separator]
+	start := strings.IndexAny(hrp, "0123456789")
+	if start < 0 {
+		return 0
+	}
+	amount := hrp[start:]
+
+	multiplier := amount[len(amount)-1]
+	if multiplier >= '0' && multiplier <= '9' {
+		multiplier = 0
+	} else {
+		amount = amount[:len(amount)-1]
+	}
+	value, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	switch multiplier {
+	case 0:
+		return value * 100_000_000_000
+	case 'm':
+		return value * 100_000_000
+	case 'u':
+		return value * 100_000
+	case 'n':
+		return value * 100
+	case 'p':
+		return value / 10
+	}
+	return 0
+}
+
+/**
+ * The types that are asked for without the muted ones, every type that is not muted when none are asked for.
+ */
+func (st *Storage) unmutedTypes(ctx context.Context, types []string) ([]string, error) {
+	if len(types) == 0 {
+		types = NotificationTypes
+	}
+
+	var muted []string
+	if err := st.GormDB.WithContext(ctx).Model(&NotificationSetting{}).Where("muted = ?", true).Pluck("type", &muted).Error; err != nil {
+		return nil, err
+	}
+	isMuted := make(map[string]bool, len(muted))
+	for _, t := range muted {
+		isMuted[t] = true
+	}
+
+	unmuted := make([]string, 0, len(types))
+	for _, t := range types {
+		if !isMuted[t] {
+			unmuted = append(unmuted, t)
+		}
+	}
+	return unmuted, nil
+}
+
+/**
+ * The notifications grouped per type and note, the group with the newest notification first. Every group has
+ * its newest notifications with the profile of who did it and, for mentions and replies, the note.
+ */
+func (st *Storage) GetNotifications(ctx context.Context, page NotificationPage) ([]NotificationGroup, error) {
+	groups := make([]NotificationGroup, 0)
+	types, err := st.unmutedTypes(ctx, page.Types)
+	if err != nil || len(types) == 0 {
+		return groups, err
+	}
+
+	seen := "1 = 1"
+	if page.Unseen {
+		seen = "seen = false"
+	}
+
+	qry := `SELECT type, target_event_id, COUNT(*) count, SUM(CASE WHEN seen THEN 0 ELSE 1 END) unseen,
+		MAX(event_created_at) latest, MAX(id) last_id
+		FROM notifications WHERE type IN (?) AND ` + seen + `
+		GROUP BY type, target_event_id`
+	args := []interface{}{types}
+	if page.BeforeId > 0 {
+		qry = qry + ` HAVING (MAX(event_created_at), MAX(id)) < (?, ?)`
+		args = append(args, page.Before, page.BeforeId)
+	}
+	qry = qry + ` ORDER BY latest DESC, last_id DESC LIMIT ?`
+	args = append(args, page.Limit)
+
+	var rows []struct {
+		Type          string
+		TargetEventId string
+		Count         int64
+		Unseen        int64
+		Latest        int64
+		LastId        uint
+	}
+	if err := st.GormDB.WithContext(ctx).Raw(qry, args...).Scan(&rows).Error; err != nil {
+		return groups, err
+	}
+	if len(rows) == 0 {
+		return groups, nil
+	}
+
+	groupTypes := make([]string, 0, len(rows))
+	targets := make([]string, 0, len(rows))
+	byGroup := make(map[string]int, len(rows))
+	for i, row := range rows {
+		groups = append(groups, NotificationGroup{
+			Type:          row.Type,
+			TargetEventId: row.TargetEventId,
+			Count:         row.Count,
+			Unseen:        row.Unseen,
+			Latest:        row.Latest,
+			LastId:        row.LastId,
+			Notifications: make([]Notification, 0, notificationSample),
+		})
+		groupTypes = append(groupTypes, row.Type)
+		targets = append(targets, row.TargetEventId)
+		byGroup[row.Type+":"+row.TargetEventId] = i
+	}
+
+	// The newest notifications of every group, this can have some of other groups with the same type or target
+	var notifications []Notification
+	err = st.GormDB.WithContext(ctx).Raw(`SELECT * FROM (
+			SELECT notifications.*, ROW_NUMBER() OVER (PARTITION BY type, target_event_id ORDER BY event_created_at DESC, id DESC) AS newest_position
+			FROM notifications WHERE type IN (?) AND target_event_id IN (?) AND `+seen+`
+		) newest WHERE newest_position <= ? ORDER BY event_created_at DESC, id DESC`, groupTypes, targets, notificationSample).
+		Scan(&notifications).Error
+	if err != nil {
+		return groups, err
+	}
+
+	eventIds := make([]string, 0, len(groups)+len(notifications))
+	pubkeys := make([]string, 0, len(notifications))
+	for _, group := range groups {
+		if group.TargetEventId != "" {
+			eventIds = append(eventIds, group.TargetEventId)
+		}
+	}
+	for _, notification := range notifications {
+		if notification.NoteID != nil {
+			eventIds = append(eventIds, notification.EventId)
+		}
+		pubkeys = append(pubkeys, notification.Pubkey)
+	}
+
+	events, err := st.getEvents(ctx, eventIds)
+	if err != nil {
+		return groups, err
+	}
+	var profiles []Profile
+	if err := st.GormDB.WithContext(ctx).Where("pubkey IN (?)", pubkeys).Find(&profiles).Error; err != nil {
+		return groups, err
+	}
+	profileMap := make(map[string]Profile, len(profiles))
+	for _, profile := range profiles {
+		profileMap[profile.Pubkey] = profile
+	}
+
+	for i := range groups {
+		if ev, ok := events[groups[i].TargetEventId]; ok {
+			groups[i].Target = &ev
+		}
+	}
+	for _, notification := range notifications {
+		i, ok := byGroup[notification.Type+":"+notification.TargetEventId]
+		if !ok {
+			continue
+		}
+		profile, ok := profileMap[notification.Pubkey]
+		if !ok {
+			profile = Profile{Pubkey: notification.Pubkey}
+			profile.Name.String = notification.Pubkey
+		}
+		notification.Profile = &profile
+		if ev, ok := events[notification.EventId]; ok && notification.NoteID != nil {
+			notification.Event = &ev
+		}
+		groups[i].Notifications = append(groups[i].Notifications, notification)
+	}
+
+	return groups, nil
+}
+
+/**
+ * Mark notifications as seen, returns how many there were that were not seen yet.
+ */
+func (st *Storage) MarkNotificationsSeen(ctx context.Context, which NotificationsSeen) (int64, error) {
+	tx := st.GormDB.WithContext(ctx).Model(&Notification{}).Where("seen = ?", false)
+	switch {
+	case which.All:
+	case len(which.Ids) > 0:
+		tx = tx.Where("id IN (?)", which.Ids)
+	case which.Type != "":
+		tx = tx.Where("type = ? AND target_event_id = ?", which.Type, which.TargetEventId)
+	default:
+		return 0, fmt.Errorf("no notifications to mark as seen, give ids, a type or all")
+	}
+
+	result := tx.Update("seen", true)
+	return result.RowsAffected, result.Error
+}
+
+// The notifications that are not seen per type, without the muted types
+func (st *Storage) GetUnseenNotifications(ctx context.Context) (UnseenNotifications, error) {
+	unseen := UnseenNotifications{Types: make(map[string]int64)}
+	types, err := st.unmutedTypes(ctx, nil)
+	if err != nil || len(types) == 0 {
+		return unseen, err
+	}
+	for _, t := range types {
+		unseen.Types[t] = 0
+	}
+
+	var rows []struct {
+		Type  string
+		Count int64
+	}
+	err = st.GormDB.WithContext(ctx).Model(&Notification{}).Select("type, COUNT(*) count").
+		Where("seen = ? AND type IN (?)", false, types).Group("type").Scan(&rows).Error
+	if err != nil {
+		return unseen, err
+	}
+	for _, row := range rows {
+		unseen.Types[row.Type] = row.Count
+		unseen.Total += row.Count
+	}
+	return unseen, nil
+}
+
+// Every type of notification and if it is muted
+func (st *Storage) GetNotificationSettings(ctx context.Context) ([]NotificationSetting, error) {
+	var stored []NotificationSetting
+	if err := st.GormDB.WithContext(ctx).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	muted := make(map[string]bool, len(stored))
+	for _, setting := range stored {
+		muted[setting.Type] = setting.Muted
+	}
+
+	settings := make([]NotificationSetting, 0, len(NotificationTypes))
+	for _, t := range NotificationTypes {
+		settings = append(settings, NotificationSetting{Type: t, Muted: muted[t]})
+	}
+	return settings, nil
+}
+
+func (st *Storage) SaveNotificationSetting(ctx context.Context, notificationType string, muted bool) error {
+	if !IsNotificationType(notificationType) {
+		return fmt.Errorf("unknown notification type %s, use one of %s", notificationType, strings.Join(NotificationTypes, ", "))
+	}
+
+	setting := NotificationSetting{Type: notificationType, Muted: muted}
+	return st.GormDB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "type"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"muted": muted, "updated_at": gorm.Expr("CURRENT_TIMESTAMP")}),
+	}).Create(&setting).Error
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// An outbox delivery with the event it is about
+type OutboxEntry struct {
+	OutboxDelivery
+	Kind    int    `json:"kind"`
+	Content string `json:"content"`
+}
+
+/**
+ * Put a signed event in the outbox with a pending delivery for every relay.
+ * Relays that already have a delivery for the event keep it.
+ */
+func (st *Storage) CreateOutbox(ctx context.Context, ev *nostr.Event, relayUrls []string) error {
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	return st.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		outbox := OutboxEvent{EventId: ev.ID, Kind: ev.Kind, Raw: raw}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&outbox).Error; err != nil {
+			return err
+		}
+		if len(relayUrls) == 0 {
+			return nil
+		}
+
+		deliveries := make([]OutboxDelivery, 0, len(relayUrls))
+		for _, relayUrl := range relayUrls {
+			deliveries = append(deliveries, OutboxDelivery{EventId: ev.ID, RelayUrl: relayUrl, Status: DeliveryPending})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+	})
+}
+
+func (st *Storage) GetOutboxEvent(ctx context.Context, eventId string) (*nostr.Event, error) {
+	var outbox OutboxEvent
+	err := st.GormDB.WithContext(ctx).Where("event_id = ?", eventId).First(&outbox).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var ev nostr.Event
+	err = json.Unmarshal(outbox.Raw, &ev)
+	return &ev, err
+}
+
+/**
+ * Pending deliveries of which the retry time has passed, oldest first.
+ */
+func (st *Storage) GetDueDeliveries(ctx context.Context, limit int) ([]OutboxDelivery, error) {
+	var deliveries []OutboxDelivery
+	err := st.GormDB.WithContext(ctx).
+		Where("status = ? AND next_retry_at <= ?", DeliveryPending, time.Now().Unix()).
+		Order("next_retry_at ASC, id ASC").
+		Limit(limit).
+		Find(&deliveries).Error
+
+	return deliveries, err
+}
+
+func (st *Storage) SaveDelivery(ctx context.Context, delivery *OutboxDelivery) error {
+	return st.GormDB.WithContext(ctx).Model(&OutboxDelivery{}).Where("id = ?", delivery.ID).Updates(map[string]interface{}{
+		"status":        delivery.Status,
+		"message":       delivery.Message,
+		"attempts":      delivery.Attempts,
+		"next_retry_at": delivery.NextRetryAt,
+		"delivered_at":  delivery.DeliveredAt,
+		"updated_at":    time.Now(),
+	}).Error
+}
+
+func (st *Storage) GetDeliveries(ctx context.Context, eventId string) ([]OutboxDelivery, error) {
+	var deliveries []OutboxDelivery
+	err := st.GormDB.WithContext(ctx).Where("event_id = ?", eventId).Order("relay_url ASC").Find(&deliveries).Error
+
+	return deliveries, err
+}
+
+/**
+ * The deliveries with one of the statuses, newest first.
+ */
+func (st *Storage) GetOutbox(ctx context.Context, statuses []DeliveryStatus, limit int) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	err := st.GormDB.WithContext(ctx).Table("outbox_deliveries d").
+		Select("d.*, o.kind, "+st.jsonText("o.raw", "content")+" AS content").
+		Joins("JOIN outbox o ON o.event_id = d.event_id").
+		Where("d.status IN ?", statuses).
+		Order("d.created_at DESC, d.id DESC").
+		Limit(limit).
+		Scan(&entries).Error
+
+	return entries, err
+}
+
+/**
+ * Send the event again to the relays that did not take it, and to write relays that were added later.
+ */
+func (st *Storage) ResetDeliveries(ctx context.Context, eventId string, relayUrls []string) error {
+	return st.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&OutboxDelivery{}).
+			Where("event_id = ? AND status <> ?", eventId, DeliveryDelivered).
+			Updates(map[string]interface{}{"status": DeliveryPending, "attempts": 0, "next_retry_at": 0, "updated_at": time.Now()}).Error
+		if err != nil || len(relayUrls) == 0 {
+			return err
+		}
+
+		deliveries := make([]OutboxDelivery, 0, len(relayUrls))
+		for _, relayUrl := range relayUrls {
+			deliveries = append(deliveries, OutboxDelivery{EventId: eventId, RelayUrl: relayUrl, Status: DeliveryPending})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+	})
+}
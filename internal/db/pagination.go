@@ -7,6 +7,7 @@ type Pagination struct {
 	PreviousCursor uint64 `json:"previous_cursor"`
 	PerPage        uint   `json:"per_page,omitempty" query:"per_page"`
 	Since          uint   `json:"since"`
+	Unread         bool   `json:"unread" query:"unread"` // Only the notes that are not read
 }
 
 func (p *Pagination) SetCursor(cursor uint64) {
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+/**
+ * Authors of notes since seenSince without a profile or with a profile we got before fetchedBefore.
+ * The authors with the newest notes come first.
+ */
+func (st *Storage) GetStaleProfiles(ctx context.Context, fetchedBefore time.Time, seenSince int64, limit int) ([]string, error) {
+	var pubkeys []string
+	err := st.GormDB.WithContext(ctx).Raw(`SELECT n.pubkey FROM notes n
+		LEFT JOIN profiles p ON p.pubkey = n.pubkey
+		WHERE n.event_created_at > ?
+		AND (p.id IS NULL OR p.fetched_at IS NULL OR p.fetched_at < ?)
+		AND n.pubkey NOT IN (SELECT pubkey FROM blocks)
+		GROUP BY n.pubkey
+		ORDER BY MAX(n.event_created_at) DESC
+		LIMIT ?`, seenSince, fetchedBefore, limit).Scan(&pubkeys).Error
+
+	return pubkeys, err
+}
+
+/**
+ * We asked the relays for these profiles, even when they did not have a newer one.
+ */
+func (st *Storage) MarkProfilesFetched(ctx context.Context, pubkeys []string) error {
+	if len(pubkeys) == 0 {
+		return nil
+	}
+	return st.GormDB.WithContext(ctx).Model(&Profile{}).Where("pubkey IN ?", pubkeys).Update("fetched_at", time.Now()).Error
+}
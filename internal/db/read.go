@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The feeds, with the names the frontend uses as context
+const (
+	FeedFollow        = "follow"
+	FeedGlobal        = "global"
+	FeedBookmarks     = "bookmark"
+	FeedNotifications = "notifications"
+	FeedInbox         = "inbox"
+)
+
+/**
+ * The notes that are not read per feed. New is the number of notes after the cursor in the feed that was asked for.
+ */
+type UnreadCounts struct {
+	New           int   `json:"new"`
+	Follow        int64 `json:"follow"`
+	Global        int64 `json:"global"`
+	Bookmarks     int64 `json:"bookmarks"`
+	Notifications int64 `json:"notifications"`
+	Inbox         int64 `json:"inbox"`
+}
+
+// The roots of the threads we replied to
+const inboxRoots = `SELECT trees.root_event_id FROM trees JOIN notes ON (notes.event_id = trees.event_id) WHERE notes.pubkey = ?`
+
+// A note is read when it has a row in seens
+const unreadCondition = `NOT EXISTS (SELECT 1 FROM seens WHERE seens.note_id = notes_and_profiles.id)`
+
+/**
+ * The notes of a feed from notes_and_profiles. The notifications are not in there, they have their own table.
+ */
+func (st *Storage) feedNotes(ctx context.Context, feed string) (*gorm.DB, error) {
+	tx := st.GormDB.WithContext(ctx).Model(&NotesAndProfiles{})
+	switch feed {
+	case FeedFollow:
+		return tx.Where("followed = ? and bookmarked = ?", true, false), nil
+	case FeedGlobal:
+		return st.hideReported(tx.Where("followed = ? and bookmarked = ?", false, false), Options{}), nil
+	case FeedBookmarks:
+		return tx.Where("followed = ? and bookmarked = ?", false, true), nil
+	case FeedInbox:
+		return tx.Where("event_id IN ("+inboxRoots+")", st.Pubkey), nil
+	}
+	return nil, fmt.Errorf("unknown feed %s", feed)
+}
+
+/**
+ * The notes after the cursor in the feed of the options and the unread notes of every feed.
+ */
+func (st *Storage) GetNewNotesCount(ctx context.Context, cursor uint64, options Options) (UnreadCounts, error) {
+	var counts UnreadCounts
+	tx := st.GormDB.WithContext(ctx).Model(&NotesAndProfiles{}).
+		Select(`COUNT(id)`).
+		Where("id > ?", cursor).
+		Where("followed = ? and bookmarked = ?", options.Follow, options.BookMark)
+	if err := st.hideReported(tx, options).Find(&counts.New).Error; err != nil {
+		return counts, err
+	}
+
+	feeds := map[string]*int64{
+		FeedFollow:    &counts.Follow,
+		FeedGlobal:    &counts.Global,
+		FeedBookmarks: &counts.Bookmarks,
+		FeedInbox:     &counts.Inbox,
+	}
+	for feed, count := range feeds {
+		tx, err := st.feedNotes(ctx, feed)
+		if err != nil {
+			return counts, err
+		}
+		if err := tx.Where(unreadCondition).Count(count).Error; err != nil {
+			return counts, err
+		}
+	}
+
+	unseen, err := st.GetUnseenNotifications(ctx)
+	counts.Notifications = unseen.Total
+	return counts, err
+}
+
+/**
+ * Mark the notes as read, also their notifications.
+ */
+func (st *Storage) MarkRead(ctx context.Context, eventIds []string) error {
+	var seens []Seen
+	err := st.GormDB.WithContext(ctx).Model(&Note{}).Select("id note_id, event_id").Where("event_id IN (?)", eventIds).Scan(&seens).Error
+	if err != nil {
+		return err
+	}
+	return st.markRead(ctx, seens)
+}
+
+func (st *Storage) markRead(ctx context.Context, seens []Seen) error {
+	if len(seens) == 0 {
+		return nil
+	}
+	noteIds := make([]uint, 0, len(seens))
+	for _, seen := range seens {
+		noteIds = append(noteIds, seen.NoteID)
+	}
+
+	return st.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&seens, retentionChunk).Error; err != nil {
+			return err
+		}
+		for start := 0; start < len(noteIds); start += retentionChunk {
+			end := min(start+retentionChunk, len(noteIds))
+			err := tx.Model(&Notification{}).Where("note_id IN (?) AND seen = ?", noteIds[start:end], false).Update("seen", true).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/**
+ * Mark the notes as not read, their notifications become new again.
+ */
+func (st *Storage) MarkUnread(ctx context.Context, eventIds []string) error {
+	return st.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("event_id IN (?)", eventIds).Delete(&Seen{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Notification{}).Where("note_id IN (SELECT id FROM notes WHERE event_id IN (?))", eventIds).Update("seen", false).Error
+	})
+}
+
+/**
+ * Mark every unread note of the feed with an id up to and including upTo as read. The id is the one of the
+ * cursors of the feed. Returns the number of notes that were marked.
+ */
+func (st *Storage) MarkReadUpTo(ctx context.Context, feed string, upTo uint64) (int64, error) {
+	var seens []Seen
+	if feed == FeedNotifications {
+		err := st.GormDB.WithContext(ctx).Model(&Notification{}).Select("notes.id note_id, notes.event_id").
+			Joins("JOIN notes ON (notes.id = notifications.note_id)").
+			Where("notifications.seen = ? AND notes.id <= ?", false, upTo).Scan(&seens).Error
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		tx, err := st.feedNotes(ctx, feed)
+		if err != nil {
+			return 0, err
+		}
+		if err := tx.Select("id note_id, event_id").Where("id <= ?", upTo).Where(unreadCondition).Scan(&seens).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(seens)), st.markRead(ctx, seens)
+}
+
+/**
+ * Mark the root of the thread of the note and every reply under it as read.
+ */
+func (st *Storage) MarkThreadRead(ctx context.Context, eventId string) error {
+	root := st.GetThreadRoot(ctx, eventId)
+
+	var rows []replyRow
+	roots := []string{root}
+	if err := st.GormDB.WithContext(ctx).Raw(replyTreeQuery, roots, roots, roots).Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	eventIds := []string{root}
+	for _, row := range rows {
+		eventIds = append(eventIds, row.EventId)
+	}
+	return st.MarkRead(ctx, eventIds)
+}
+
+// Tell which of the notes are read
+func (st *Storage) addReadState(ctx context.Context, eventMap map[string]Event) error {
+	if len(eventMap) == 0 {
+		return nil
+	}
+	eventIds := make([]string, 0, len(eventMap))
+	for eventId := range eventMap {
+		eventIds = append(eventIds, eventId)
+	}
+
+	var read []string
+	if err := st.GormDB.WithContext(ctx).Model(&Seen{}).Where("event_id IN (?)", eventIds).Pluck("event_id", &read).Error; err != nil {
+		return err
+	}
+	for _, eventId := range read {
+		ev := eventMap[eventId]
+		ev.Read = true
+		eventMap[eventId] = ev
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+func (st *Storage) GetRelayHealth(ctx context.Context) []RelayHealth {
+	var health []RelayHealth
+	st.GormDB.WithContext(ctx).Model(&RelayHealth{}).Find(&health)
+
+	return health
+}
+
+/**
+ * Store the health of the relays, so the backoff survives a restart.
+ */
+func (st *Storage) SaveRelayHealth(ctx context.Context, health []RelayHealth) error {
+	if len(health) == 0 {
+		return nil
+	}
+
+	for i := range health {
+		health[i].ID = 0
+		health[i].UpdatedAt = time.Now()
+	}
+
+	return st.GormDB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "url"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"latency_ms", "successes", "errors", "rejected", "consecutive_failures", "last_error",
+			"last_success_at", "last_error_at", "retry_at", "updated_at",
+		}),
+	}).Create(&health).Error
+}
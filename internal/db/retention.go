@@ -0,0 +1,331 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Who wrote the note, for the retention rules
+const (
+	AuthorSelf     = "self"
+	AuthorFollowed = "followed"
+	AuthorOther    = "other"
+)
+
+// How many ids go in one IN (...)
+const retentionChunk = 500
+
+/**
+ * How long notes are kept. A note gets the first rule that matches it, a rule without a condition matches every
+ * note. Notes that no rule matches are kept.
+ */
+type RetentionRule struct {
+	Kinds      []int  `json:"kinds"`      // Empty is every kind
+	Author     string `json:"author"`     // self, followed or other, empty is everyone
+	Bookmarked *bool  `json:"bookmarked"` // Only bookmarked or only not bookmarked notes, empty is both
+	Days       int    `json:"days"`       // Keep the notes this many days, 0 keeps them forever
+}
+
+type RetentionRuleReport struct {
+	Rule  RetentionRule `json:"rule"`
+	Notes int           `json:"notes"`
+}
+
+/**
+ * What a cleanup deleted, or would delete in a dry run. Protected are expired notes that are kept because a note
+ * we keep replies to them. Orphans are rows of notes that were already gone.
+ */
+type RetentionReport struct {
+	DryRun    bool                  `json:"dry_run"`
+	Notes     int                   `json:"notes"`
+	Protected int                   `json:"protected"`
+	Orphans   int64                 `json:"orphans"`
+	Rules     []RetentionRuleReport `json:"rules"`
+	StartedAt time.Time             `json:"started_at"`
+	Duration  string                `json:"duration"`
+}
+
+// The rows that belong to a note and go with it, by the id or the event id of the note
+var noteIdTables = []string{"reactions", "notifications", "seens", "bookmarks", "content_fingerprints"}
+var noteEventIdTables = []string{"trees", "event_relays", "event_counters", "counter_sources"}
+
+/**
+ * The rules of the config. Without them we keep our own, bookmarked and followed notes and the notes of
+ * everyone else for Retention days, which is forever when it is 0.
+ */
+func (st *Storage) RetentionRules() []RetentionRule {
+	if len(st.DbConfig.RetentionRules) > 0 {
+		return st.DbConfig.RetentionRules
+	}
+	bookmarked := true
+	return []RetentionRule{
+		{Author: AuthorSelf},
+		{Bookmarked: &bookmarked},
+		{Author: AuthorFollowed},
+		{Days: st.DbConfig.Retention},
+	}
+}
+
+// The where clause for the notes of a rule
+func (st *Storage) retentionCondition(rule RetentionRule) (string, []interface{}, error) {
+	conditions := []string{"1 = 1"}
+	args := make([]interface{}, 0)
+
+	if len(rule.Kinds) > 0 {
+		conditions = append(conditions, "notes.kind IN (?)")
+		args = append(args, rule.Kinds)
+	}
+
+	followed := "EXISTS (SELECT 1 FROM follows WHERE follows.pubkey = notes.pubkey)"
+	switch rule.Author {
+	case "":
+	case AuthorSelf:
+		conditions = append(conditions, "notes.pubkey = ?")
+		args = append(args, st.Pubkey)
+	case AuthorFollowed:
+		conditions = append(conditions, "notes.pubkey <> ? AND "+followed)
+		args = append(args, st.Pubkey)
+	case AuthorOther:
+		conditions = append(conditions, "notes.pubkey <> ? AND NOT "+followed)
+		args = append(args, st.Pubkey)
+	default:
+		return "", nil, fmt.Errorf("unknown author %s in retention rule, use self, followed or other", rule.Author)
+	}
+
+	if rule.Bookmarked != nil {
+		bookmarked := "EXISTS (SELECT 1 FROM bookmarks WHERE bookmarks.note_id = notes.id)"
+		if !*rule.Bookmarked {
+			bookmarked = "NOT " + bookmarked
+		}
+		conditions = append(conditions, bookmarked)
+	}
+
+	if rule.Days < 0 {
+		return "", nil, fmt.Errorf("days of a retention rule can not be negative")
+	}
+
+	return "(" + strings.Join(conditions, " AND ") + ")", args, nil
+}
+
+/**
+ * The expired notes per rule, by event id with the id of the note. A note only counts for the first
+ * rule it matches.
+ */
+func (st *Storage) expiredNotes(ctx context.Context, rules []RetentionRule) (map[string]uint, map[string]int, error) {
+	expired := make(map[string]uint)
+	ruleOf := make(map[string]int)
+
+	earlier := make([]string, 0, len(rules))
+	earlierArgs := make([]interface{}, 0)
+	for i, rule := range rules {
+		condition, args, err := st.retentionCondition(rule)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if rule.Days > 0 {
+			where := condition
+			whereArgs := append([]interface{}{}, args...)
+			for _, e := range earlier {
+				where = where + " AND NOT " + e
+			}
+			whereArgs = append(whereArgs, earlierArgs...)
+			where = where + " AND notes.event_created_at < ?"
+			whereArgs = append(whereArgs, time.Now().AddDate(0, 0, -rule.Days).Unix())
+
+			type row struct {
+				ID      uint
+				EventId string
+			}
+			var rows []row
+			if err := st.GormDB.WithContext(ctx).Table("notes").Select("notes.id, notes.event_id").Where(where, whereArgs...).Scan(&rows).Error; err != nil {
+				return nil, nil, err
+			}
+			for _, r := range rows {
+				expired[r.EventId] = r.ID
+				ruleOf[r.EventId] = i
+			}
+		}
+
+		earlier = append(earlier, condition)
+		earlierArgs = append(earlierArgs, args...)
+	}
+
+	return expired, ruleOf, nil
+}
+
+/**
+ * Expired notes that a note we keep replies to, also through other replies, stay so we do not
+ * make orphans of the notes we keep.
+ */
+func (st *Storage) protectedNotes(ctx context.Context, expired map[string]uint) (map[string]bool, error) {
+	ids := make([]string, 0, len(expired))
+	for id := range expired {
+		ids = append(ids, id)
+	}
+
+	// Every reply on an expired note, with the notes it replies to
+	parents := make(map[string][]string)
+	for start := 0; start < len(ids); start += retentionChunk {
+		chunk := ids[start:min(start+retentionChunk, len(ids))]
+		var trees []Tree
+		err := st.GormDB.WithContext(ctx).Model(&Tree{}).
+			Joins("JOIN notes ON (notes.event_id = trees.event_id)").
+			Where("trees.root_event_id IN (?) OR trees.reply_event_id IN (?)", chunk, chunk).
+			Find(&trees).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, tree := range trees {
+			parents[tree.EventId] = []string{tree.RootEventId, tree.ReplyEventId}
+		}
+	}
+
+	protected := make(map[string]bool)
+	var keep func(eventId string)
+	keep = func(eventId string) {
+		for _, parent := range parents[eventId] {
+			if _, ok := expired[parent]; ok && !protected[parent] {
+				protected[parent] = true
+				keep(parent)
+			}
+		}
+	}
+	for eventId := range parents {
+		if _, ok := expired[eventId]; !ok {
+			keep(eventId)
+		}
+	}
+	return protected, nil
+}
+
+// The rows of notes that are gone, per table the where clause
+func orphanConditions() map[string]string {
+	conditions := make(map[string]string)
+	for _, table := range noteIdTables {
+		conditions[table] = fmt.Sprintf("%s.note_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM notes WHERE notes.id = %s.note_id)", table, table)
+	}
+	conditions["trees"] = "NOT EXISTS (SELECT 1 FROM notes WHERE notes.event_id = trees.event_id)"
+	return conditions
+}
+
+/**
+ * Delete the expired notes with the rows that belong to them and the rows of notes that were already gone.
+ * With dryRun nothing is deleted, the report tells what would be. After deleting the database is vacuumed.
+ */
+func (st *Storage) ApplyRetention(ctx context.Context, dryRun bool) (RetentionReport, error) {
+	if !st.retentionMu.TryLock() {
+		return RetentionReport{DryRun: dryRun}, fmt.Errorf("a cleanup is already running")
+	}
+	defer st.retentionMu.Unlock()
+
+	rules := st.RetentionRules()
+	report := RetentionReport{DryRun: dryRun, StartedAt: time.Now(), Rules: make([]RetentionRuleReport, 0, len(rules))}
+	for _, rule := range rules {
+		report.Rules = append(report.Rules, RetentionRuleReport{Rule: rule})
+	}
+
+	expired, ruleOf, err := st.expiredNotes(ctx, rules)
+	if err != nil {
+		return report, err
+	}
+	protected, err := st.protectedNotes(ctx, expired)
+	if err != nil {
+		return report, err
+	}
+
+	noteIds := make([]uint, 0, len(expired))
+	eventIds := make([]string, 0, len(expired))
+	for eventId, id := range expired {
+		if protected[eventId] {
+			continue
+		}
+		noteIds = append(noteIds, id)
+		eventIds = append(eventIds, eventId)
+		report.Rules[ruleOf[eventId]].Notes++
+	}
+	report.Notes = len(noteIds)
+	report.Protected = len(protected)
+
+	if dryRun {
+		for table, condition := range orphanConditions() {
+			var count int64
+			if err := st.GormDB.WithContext(ctx).Table(table).Where(condition).Count(&count).Error; err != nil {
+				return report, err
+			}
+			report.Orphans += count
+		}
+		report.Duration = time.Since(report.StartedAt).String()
+		return report, nil
+	}
+
+	err = st.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(noteIds); start += retentionChunk {
+			end := min(start+retentionChunk, len(noteIds))
+			for _, table := range noteIdTables {
+				if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE note_id IN (?)", table), noteIds[start:end]).Error; err != nil {
+					return err
+				}
+			}
+			for _, table := range noteEventIdTables {
+				if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE event_id IN (?)", table), eventIds[start:end]).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Exec("DELETE FROM notes WHERE id IN (?)", noteIds[start:end]).Error; err != nil {
+				return err
+			}
+		}
+
+		for table, condition := range orphanConditions() {
+			result := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", table, condition))
+			if result.Error != nil {
+				return result.Error
+			}
+			report.Orphans += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if report.Notes > 0 || report.Orphans > 0 {
+		if err := st.vacuum(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt).String()
+	slog.Info("Retention applied", "notes", report.Notes, "protected", report.Protected, "orphans", report.Orphans, "duration", report.Duration)
+
+	return report, nil
+}
+
+/**
+ * Give the space of the deleted rows back and update the statistics of the planner. This can not be
+ * a prepared statement, so it goes past gorm.
+ */
+func (st *Storage) vacuum(ctx context.Context) error {
+	sqlDB, err := st.GormDB.DB()
+	if err != nil {
+		return err
+	}
+
+	if st.isSqlite() {
+		if _, err := sqlDB.ExecContext(ctx, "VACUUM"); err != nil {
+			return err
+		}
+		_, err = sqlDB.ExecContext(ctx, "ANALYZE")
+		return err
+	}
+
+	tables := append([]string{"notes"}, append(noteIdTables, noteEventIdTables...)...)
+	_, err = sqlDB.ExecContext(ctx, "VACUUM (ANALYZE) "+strings.Join(tables, ", "))
+	return err
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+/**
+ * A search over the content of the notes. Words must all be in the note, "a phrase" must be in it in that order
+ * and word* matches the words that start with it. The other fields are optional filters.
+ */
+type NoteSearch struct {
+	Query    string
+	Authors  []string
+	Hashtags []string
+	Since    int64
+	Until    int64
+	ByRank   bool // Best match first, else newest first
+}
+
+type SearchResult struct {
+	Event
+	Rank    int64  `json:"rank"`    // Higher is a better match
+	Snippet string `json:"snippet"` // The part of the content with the matches, which are in <mark></mark>
+}
+
+// A word or phrase of the query, the last word of it can be a prefix
+type searchTerm struct {
+	words  []string
+	prefix bool
+}
+
+/**
+ * Split the query in words and phrases. Only letters and numbers are kept, which is also what the
+ * full-text index has, so the terms can be put in the query syntax of both backends without escaping.
+ */
+func parseSearchQuery(query string) []searchTerm {
+	terms := make([]searchTerm, 0)
+	for i, part := range strings.Split(query, `"`) {
+		phrase := i%2 == 1 // Between quotes
+		if phrase {
+			if term, ok := newSearchTerm(part); ok {
+				terms = append(terms, term)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			if term, ok := newSearchTerm(word); ok {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+func newSearchTerm(text string) (searchTerm, bool) {
+	text = strings.TrimSpace(text)
+	prefix := strings.HasSuffix(text, "*")
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return searchTerm{words: words, prefix: prefix}, len(words) > 0
+}
+
+// The terms as a postgres tsquery, 'a' <-> 'b' is a phrase and 'a':* a prefix
+func tsQuery(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		lexemes := make([]string, 0, len(term.words))
+		for _, word := range term.words {
+			lexemes = append(lexemes, "'"+word+"'")
+		}
+		part := strings.Join(lexemes, " <-> ")
+		if term.prefix {
+			part = part + ":*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " & ")
+}
+
+// The terms as a sqlite fts5 query, "a b" is a phrase and "a"* a prefix
+func ftsQuery(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		part := `"` + strings.Join(term.words, " ") + `"`
+		if term.prefix {
+			part = part + "*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// Hashtags are in the tags as ["t","tag"], the json of the tags is stored in tags_full
+func hashtagLike(hashtag string) string {
+	hashtag = strings.ToLower(strings.TrimPrefix(hashtag, "#"))
+	hashtag = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(hashtag)
+	return `%["t","` + hashtag + `"%`
+}
+
+/**
+ * Search the content of the notes we have. The rank is multiplied by a million, so it is a number we can
+ * compare. Blocked authors and garbage are left out, like in the feeds. The pagination works like the one
+ * of the feeds: the cursor is the id of the last note of the previous page and NextCursor is set when
+ * there can be more.
+ */
+func (st *Storage) SearchNotes(ctx context.Context, search NoteSearch, p *Pagination) ([]SearchResult, error) {
+	terms := parseSearchQuery(search.Query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("nothing to search for in %q", search.Query)
+	}
+
+	// The rank and the snippet come before the match in the query, so every one of them needs the query
+	var rank, snippet, match, from string
+	var rankArgs, args []interface{}
+	var q string
+	if st.isSqlite() {
+		// bm25 is lower for a better match
+		q = ftsQuery(terms)
+		rank = "CAST(-bm25(notes_search) * 1000000 AS INTEGER)"
+		snippet = "snippet(notes_search, 0, '<mark>', '</mark>', '...', 32)"
+		from = "FROM notes JOIN notes_search ON (notes_search.rowid = notes.id)"
+		match = "notes_search MATCH ?"
+		args = []interface{}{q}
+	} else {
+		q = tsQuery(terms)
+		rank = "CAST(ts_rank(notes.search, to_tsquery('simple', ?)) * 1000000 AS bigint)"
+		snippet = "ts_headline('simple', notes.content, to_tsquery('simple', ?), 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, FragmentDelimiter=...')"
+		from = "FROM notes"
+		match = "notes.search @@ to_tsquery('simple', ?)"
+		rankArgs = []interface{}{q}
+		args = []interface{}{q, q, q}
+	}
+
+	qry := noteRowsColumns + fmt.Sprintf(",\n\t%s AS search_rank, %s AS snippet\n\t%s", rank, snippet, from) + noteRowsJoins +
+		" AND " + match
+
+	if len(search.Authors) > 0 {
+		qry = qry + " AND notes.pubkey IN (?)"
+		args = append(args, search.Authors)
+	}
+	for _, hashtag := range search.Hashtags {
+		qry = qry + ` AND lower(notes.tags_full) LIKE ? ESCAPE '\'`
+		args = append(args, hashtagLike(hashtag))
+	}
+	if search.Since > 0 {
+		qry = qry + " AND notes.event_created_at >= ?"
+		args = append(args, search.Since)
+	}
+	if search.Until > 0 {
+		qry = qry + " AND notes.event_created_at <= ?"
+		args = append(args, search.Until)
+	}
+
+	// Newest or best match first, the id of the note is the tie breaker and the cursor
+	order := "notes.event_created_at"
+	if search.ByRank {
+		order = "search_rank"
+	}
+	if p.Cursor > 0 {
+		cursorQry := "SELECT notes.event_created_at FROM notes WHERE notes.id = ?"
+		cursorArgs := []interface{}{p.Cursor}
+		if search.ByRank {
+			cursorQry = fmt.Sprintf("SELECT %s %s WHERE %s AND notes.id = ?", rank, from, match)
+			cursorArgs = append(append(append([]interface{}{}, rankArgs...), q), p.Cursor)
+		}
+		after, err := st.searchCursor(ctx, cursorQry, cursorArgs, p.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if search.ByRank {
+			qry = qry + fmt.Sprintf(" AND (%s, notes.id) < (?, ?)", rank)
+			args = append(args, rankArgs...)
+		} else {
+			qry = qry + " AND (notes.event_created_at, notes.id) < (?, ?)"
+		}
+		args = append(args, after, p.Cursor)
+	}
+	qry = qry + fmt.Sprintf(" ORDER BY %s DESC, notes.id DESC LIMIT ?", order)
+	args = append(args, int(p.GetPerPage()))
+
+	type searchRow struct {
+		NotesAndProfiles
+		Rank    int64 `gorm:"column:search_rank"`
+		Snippet string
+	}
+	var rows []searchRow
+	if err := st.GormDB.WithContext(ctx).Raw(qry, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	p.NextCursor = 0
+	if len(rows) == int(p.GetPerPage()) {
+		p.NextCursor = rows[len(rows)-1].ID
+	}
+
+	noteRows := make([]NotesAndProfiles, 0, len(rows))
+	for _, row := range rows {
+		noteRows = append(noteRows, row.NotesAndProfiles)
+	}
+	eventMap, _, _, err := st.procesEventRows(&noteRows)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.addCounters(ctx, eventMap); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, SearchResult{
+			Event:   eventMap[row.EventId],
+			Rank:    row.Rank,
+			Snippet: highlight(row.Snippet),
+		})
+	}
+	return results, nil
+}
+
+// The created_at or the rank of the note of the cursor, the next page starts after it
+func (st *Storage) searchCursor(ctx context.Context, qry string, args []interface{}, cursor uint64) (int64, error) {
+	var after []int64
+	if err := st.GormDB.WithContext(ctx).Raw(qry, args...).Scan(&after).Error; err != nil {
+		return 0, err
+	}
+	if len(after) == 0 {
+		return 0, fmt.Errorf("invalid cursor: %d", cursor)
+	}
+	return after[0], nil
+}
+
+// The content is escaped, only the marks around the matches are html
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	return strings.NewReplacer("&lt;mark&gt;", "<mark>", "&lt;/mark&gt;", "</mark>").Replace(snippet)
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+const (
+	spamDistance  = 3   // Simhashes that differ in at most this many bits are near duplicates
+	spamShingle   = 4   // Runes per shingle of the content
+	spamMinLength = 32  // Shorter content, like gm, is posted by everyone and is not fingerprinted
+	spamSample    = 280 // Runes of the content kept as sample of a cluster
+)
+
+// Links and mentions are different in every copy of a spam note
+var spamNoise = regexp.MustCompile(`(https?://|nostr:|npub1|nprofile1|note1|nevent1)\S*`)
+
+/**
+ * The content in lower case without links, mentions and punctuation, so the small changes spammers make
+ * to every copy do not count.
+ */
+func normalizeContent(content string) string {
+	content = spamNoise.ReplaceAllString(strings.ToLower(content), " ")
+	words := strings.FieldsFunc(content, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(words, " ")
+}
+
+/**
+ * The simhash of the shingles of the normalized content. Content that is almost the same gets a simhash that
+ * differs in only a few bits. False when the content is too short to say anything about it.
+ */
+func simhash(content string) (uint64, bool) {
+	runes := []rune(normalizeContent(content))
+	if len(runes) < spamMinLength {
+		return 0, false
+	}
+
+	var weights [64]int
+	for i := 0; i+spamShingle <= len(runes); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(runes[i : i+spamShingle])))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var hash uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash, true
+}
+
+/**
+ * The 4 parts of 16 bits of a simhash. Two simhashes that differ in at most 3 bits have at least one
+ * part the same, so only the fingerprints with a same band have to be compared.
+ */
+func simhashBands(hash uint64) [4]int {
+	return [4]int{int(hash & 0xffff), int(hash >> 16 & 0xffff), int(hash >> 32 & 0xffff), int(hash >> 48 & 0xffff)}
+}
+
+func hammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func truncateRunes(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "..."
+}
+
+/**
+ * Fingerprint a new text note and put it in a cluster with its near duplicates of the spam window. When a
+ * cluster has notes of SpamThreshold authors within the window, all its notes are marked as garbage. Our own
+ * notes and the notes of the people we follow are never marked.
+ */
+func (st *Storage) detectSpam(ctx context.Context, note Note) error {
+	if note.Kind != 1 {
+		return nil
+	}
+	hash, ok := simhash(note.Content)
+	if !ok {
+		return nil
+	}
+	bands := simhashBands(hash)
+	window := int64(st.DbConfig.SpamWindow) * 60 * 60
+
+	// Two notes of the same spam wave should not both start a cluster
+	st.spamMu.Lock()
+	defer st.spamMu.Unlock()
+
+	return st.GormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var candidates []ContentFingerprint
+		err := tx.Where("event_created_at BETWEEN ? AND ?", note.EventCreatedAt-window, note.EventCreatedAt+window).
+			Where("band0 = ? OR band1 = ? OR band2 = ? OR band3 = ?", bands[0], bands[1], bands[2], bands[3]).
+			Find(&candidates).Error
+		if err != nil {
+			return err
+		}
+
+		fingerprint := ContentFingerprint{
+			NoteID:         note.ID,
+			Pubkey:         note.Pubkey,
+			Simhash:        int64(hash),
+			Band0:          bands[0],
+			Band1:          bands[1],
+			Band2:          bands[2],
+			Band3:          bands[3],
+			EventCreatedAt: note.EventCreatedAt,
+		}
+
+		matches := make([]ContentFingerprint, 0)
+		for _, candidate := range candidates {
+			if hammingDistance(uint64(candidate.Simhash), hash) <= spamDistance {
+				matches = append(matches, candidate)
+				if fingerprint.ClusterID == nil && candidate.ClusterID != nil {
+					fingerprint.ClusterID = candidate.ClusterID
+				}
+			}
+		}
+		if len(matches) == 0 {
+			return tx.Create(&fingerprint).Error
+		}
+
+		if fingerprint.ClusterID == nil {
+			cluster := SpamCluster{Simhash: int64(hash), Sample: truncateRunes(note.Content, spamSample)}
+			if err := tx.Create(&cluster).Error; err != nil {
+				return err
+			}
+			fingerprint.ClusterID = &cluster.ID
+		}
+		clusterId := *fingerprint.ClusterID
+
+		unclustered := make([]uint, 0, len(matches))
+		for _, match := range matches {
+			if match.ClusterID == nil {
+				unclustered = append(unclustered, match.ID)
+			}
+		}
+		if len(unclustered) > 0 {
+			if err := tx.Model(&ContentFingerprint{}).Where("id IN (?)", unclustered).Update("cluster_id", clusterId).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&fingerprint).Error; err != nil {
+			return err
+		}
+
+		return st.updateSpamCluster(tx, clusterId, note.EventCreatedAt, window)
+	})
+}
+
+// Count the notes and authors of a cluster, it is garbage when enough authors posted it within the window around at
+func (st *Storage) updateSpamCluster(tx *gorm.DB, clusterId uint, at int64, window int64) error {
+	var totals struct {
+		Authors   int
+		Notes     int
+		FirstSeen int64
+		LastSeen  int64
+	}
+	err := tx.Model(&ContentFingerprint{}).
+		Select("COUNT(DISTINCT pubkey) authors, COUNT(*) notes, MIN(event_created_at) first_seen, MAX(event_created_at) last_seen").
+		Where("cluster_id = ?", clusterId).Scan(&totals).Error
+	if err != nil {
+		return err
+	}
+
+	var authors int64
+	err = tx.Model(&ContentFingerprint{}).Distinct("pubkey").
+		Where("cluster_id = ? AND event_created_at BETWEEN ? AND ?", clusterId, at-window, at+window).Count(&authors).Error
+	if err != nil {
+		return err
+	}
+
+	var cluster SpamCluster
+	if err := tx.First(&cluster, clusterId).Error; err != nil {
+		return err
+	}
+	cluster.Authors = totals.Authors
+	cluster.Notes = totals.Notes
+	cluster.FirstSeen = totals.FirstSeen
+	cluster.LastSeen = totals.LastSeen
+	cluster.Garbage = cluster.Garbage || int(authors) >= st.DbConfig.SpamThreshold
+	if err := tx.Save(&cluster).Error; err != nil {
+		return err
+	}
+
+	if !cluster.Garbage {
+		return nil
+	}
+	return tx.Exec(`UPDATE notes SET garbage = true
+		WHERE id IN (SELECT note_id FROM content_fingerprints WHERE cluster_id = ?)
+		AND garbage = false AND pubkey <> ? AND pubkey NOT IN (SELECT pubkey FROM follows)`, clusterId, st.Pubkey).Error
+}
+
+/**
+ * The clusters of near duplicates, the last seen first. With garbageOnly only the ones that are marked as garbage.
+ */
+func (st *Storage) GetSpamClusters(ctx context.Context, garbageOnly bool, limit int) ([]SpamCluster, error) {
+	tx := st.GormDB.WithContext(ctx).Model(&SpamCluster{})
+	if garbageOnly {
+		tx = tx.Where("garbage = ?", true)
+	}
+
+	var clusters []SpamCluster
+	if err := tx.Order("last_seen DESC, id DESC").Limit(limit).Find(&clusters).Error; err != nil {
+		return []SpamCluster{}, err
+	}
+	for i := range clusters {
+		clusters[i].Fingerprint = fmt.Sprintf("%016x", uint64(clusters[i].Simhash))
+	}
+	return clusters, nil
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"errors"
+
+	// Pure go sqlite, so we do not need cgo
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+/**
+ * Open the sqlite file, the tables are made by the migrations.
+ * Readers do not wait for the writer with WAL, and a writer waits at most 5 seconds for an other writer.
+ */
+func (st *Storage) openSqlite(path string, gormConfig *gorm.Config) error {
+	if path == "" {
+		return errors.New("no path for the sqlite database")
+	}
+
+	dsn := path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate"
+
+	var err error
+	st.GormDB, err = gorm.Open(sqlite.Open(dsn), gormConfig)
+
+	return err
+}
@@ -16,6 +16,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	// Sqlite driver based on CGO
@@ -31,12 +32,21 @@ import (
 )
 
 type DbConfig struct {
-	User      string
-	Password  string
-	Dbname    string
-	Port      int
-	Host      string
-	Retention int
+	Driver            string // postgres (default) or sqlite
+	Path              string // The database file when the driver is sqlite
+	User              string
+	Password          string
+	Dbname            string
+	Port              int
+	Host              string
+	Retention         int             // Days we keep the notes of people we do not follow, when there are no RetentionRules
+	RetentionRules    []RetentionRule // The first rule that matches a note tells how long it is kept
+	RetentionInterval int             // Hours between the cleanups
+	ReportThreshold   int             // How many of the people we follow must report a note or pubkey before it is hidden
+	BackfillDays      int             // How far back in time we get the history of a followed author
+	BackfillNotes     int             // And the maximum number of notes of that history
+	SpamThreshold     int             // How many authors must post near duplicates within the window before they are garbage
+	SpamWindow        int             // Hours before and after a note in which its near duplicates are counted
 }
 
 /**
@@ -51,10 +61,11 @@ type Storage struct {
 	Pubkey        string
 	Notifications []string
 	DbConfig      *DbConfig
+	Zappers       ZapperLookup // Who may make the zap receipts of a lightning address
+	retentionMu   sync.Mutex   // One cleanup at a time
+	spamMu        sync.Mutex   // One note at a time in the spam clusters
 }
 
-var Missing_event_ids []string
-
 func (st *Storage) SetEnvironment(env string) {
 	st.Env = env
 }
@@ -75,7 +86,7 @@ func (st *Storage) CheckError(err error) {
 }
 
 /**
- * Connect to postgresql database
+ * Connect to the postgresql database or open the sqlite file, depending on the driver in the config
  */
 func (st *Storage) Connect(ctx context.Context, cfg *DbConfig) error {
 	var err error
@@ -83,54 +94,55 @@ func (st *Storage) Connect(ctx context.Context, cfg *DbConfig) error {
 	st.Notifications = make([]string, 0)
 
 	st.DbConfig = cfg
+	if st.DbConfig.ReportThreshold < 1 {
+		st.DbConfig.ReportThreshold = 2
+	}
+	if st.DbConfig.BackfillDays < 1 {
+		st.DbConfig.BackfillDays = 30
+	}
+	if st.DbConfig.BackfillNotes < 1 {
+		st.DbConfig.BackfillNotes = 500
+	}
+	if st.DbConfig.RetentionInterval < 1 {
+		st.DbConfig.RetentionInterval = 24
+	}
+	if st.DbConfig.SpamThreshold < 1 {
+		st.DbConfig.SpamThreshold = 5
+	}
+	if st.DbConfig.SpamWindow < 1 {
+		st.DbConfig.SpamWindow = 24
+	}
 
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=Europe/Amsterdam",
-		cfg.Host,
-		cfg.User,
-		cfg.Password,
-		cfg.Dbname,
-		cfg.Port)
-
-	st.GormDB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
+	gormConfig := &gorm.Config{
 		Logger:      gormLogger.Default.LogMode(gormLogger.Silent),
 		PrepareStmt: true,
-	})
+	}
 
-	Missing_event_ids = make([]string, 0)
+	switch cfg.Driver {
+	case "", Postgres:
+		cfg.Driver = Postgres
+		st.GormDB, err = gorm.Open(postgres.Open(postgresDsn(cfg)), gormConfig)
+	case Sqlite:
+		if err = st.openSqlite(cfg.Path, gormConfig); err != nil {
+			return err
+		}
+		log.Println("Connect() -> Opened database:", cfg.Path)
+		return nil
+	default:
+		return fmt.Errorf("unknown database driver %s", cfg.Driver)
+	}
 
 	log.Println("Connect() -> Connected to database:", cfg.Dbname)
 	return err
 }
 
-func (st *Storage) Clean(ctx context.Context) error {
-	then := time.Now().AddDate(0, 0, -1*st.DbConfig.Retention)
-	past := fmt.Sprintf("%d-%d-%d 00:00:00\n",
-		then.Year(),
-		then.Month(),
-		then.Day())
-
-	var err error
-	st.GormDB.Transaction(func(tx *gorm.DB) error {
-		err = tx.Exec(`DELETE FROM reactions WHERE note_id in (SELECT id FROM notes WHERE created_at <= $1);`, past).Error
-		if err != nil {
-			log.Println(err.Error())
-			return err
-		}
-		err = tx.Exec(`DELETE FROM notifications WHERE note_id in (SELECT id FROM notes WHERE created_at <= $1);`, past).Error
-		if err != nil {
-			log.Println(err.Error())
-			return err
-		}
-		err = tx.Exec(`DELETE FROM notes WHERE created_at <= $1;`, past).Error
-		if err != nil {
-			log.Println(err.Error())
-			return err
-		}
-
-		return nil
-	})
-
-	return nil
+func postgresDsn(cfg *DbConfig) string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=Europe/Amsterdam",
+		cfg.Host,
+		cfg.User,
+		cfg.Password,
+		cfg.Dbname,
+		cfg.Port)
 }
 
 func (st *Storage) SaveProfile(ctx context.Context, ev *Event) error {
@@ -154,19 +166,20 @@ func (st *Storage) SaveProfile(ctx context.Context, ev *Event) error {
 	jsonBufBytes := jsonbuf.Bytes()
 	newUUID, _ := uuid.NewV7()
 	profile := &Profile{
-		Pubkey:      ev.Event.PubKey,
-		UID:         newUUID,
-		Name:        data.Name,
-		About:       data.About,
-		Picture:     data.Picture,
-		Website:     data.Website,
-		Nip05:       data.Nip05,
-		Lud16:       data.Lud16,
-		DisplayName: data.DisplayName,
-		Raw:         jsonBufBytes,
-		Urls:        ev.Urls,
+		Pubkey:         ev.Event.PubKey,
+		UID:            newUUID,
+		Name:           data.Name,
+		About:          data.About,
+		Picture:        data.Picture,
+		Website:        data.Website,
+		Nip05:          data.Nip05,
+		Lud16:          data.Lud16,
+		DisplayName:    data.DisplayName,
+		Raw:            jsonBufBytes,
+		EventCreatedAt: ev.Event.CreatedAt.Time().Unix(),
 	}
 	profile.UpdatedAt.Time = time.Now()
+	profile.FetchedAt = sql.NullTime{Time: time.Now(), Valid: true}
 
 	slog.Info(fmt.Sprintf("SaveProfile() -> Adding or updating profile: pubkey = %s", profile.Pubkey))
 
@@ -183,8 +196,13 @@ func (st *Storage) SaveProfile(ctx context.Context, ev *Event) error {
 		return err
 	}
 
+	// Profiles are replaceable, an older or the same event must not overwrite the newest one
+	if searchProfile.ID != 0 && searchProfile.EventCreatedAt >= profile.EventCreatedAt {
+		return st.GormDB.WithContext(ctx).Model(&Profile{}).Where("id = ?", searchProfile.ID).Update("fetched_at", time.Now()).Error
+	}
+
 	result := st.GormDB.Where(Profile{Pubkey: ev.Event.PubKey}).
-		Assign(profile).
+		Assign(*profile).
 		FirstOrCreate(&profile)
 	if result.Error != nil {
 		return result.Error
@@ -223,13 +241,19 @@ func (st *Storage) SaveProfiles(ctx context.Context, evs []*Event) error {
 /**
  * Save the events, mostly notes. Ignore duplicate events based on unique event id
  * This will normalize the content tag of the events with all the unwanted markup (Myaby put this in a helper function)
+ * Returns the ids of the events that were saved, also when it stops with an error.
  */
 func (st *Storage) SaveEvents(ctx context.Context, evs []*Event) ([]string, error) {
-	var pubkeys = make([]string, 0)
+	var saved = make([]string, 0)
+
+	st.Notifications = make([]string, 0) // reset if already set
 
-	st.Notifications = make([]string, 0)  // reset if already set
-	Missing_event_ids = make([]string, 0) //reset
+	// Also for events we already have, so we know all the relays that carry them
+	if err := st.SaveEventRelays(ctx, evs); err != nil {
+		slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+	}
 
+	var zapCtx context.Context // The deadline of the zapper lookups starts at the first zap
 	for _, ev := range evs {
 		if ev.Event.CreatedAt.Time().Unix() > time.Now().Unix() { // Ignore events with timestamp in the future.
 			continue
@@ -240,34 +264,98 @@ func (st *Storage) SaveEvents(ctx context.Context, evs []*Event) ([]string, erro
 		if ev.Event.Kind == 0 {
 			err := st.SaveProfile(ctx, ev)
 			if err != nil {
-				return []string{}, err
+				return saved, err
 			}
+			saved = append(saved, ev.Event.ID)
 		}
 
 		if ev.Event.Kind == 1 {
 			note, err := st.SaveNote(ctx, ev)
 			if err != nil {
-				return []string{}, err
+				return saved, err
+			}
+			if note.ID > 0 { // Not a note we already had
+				saved = append(saved, ev.Event.ID)
 			}
-			pubkeys = append(pubkeys, note.Pubkey)
 		}
 
-		// votes
+		if ev.Event.Kind == nostr.KindContactList {
+			if err := st.SaveContactList(ctx, ev.Event); err != nil {
+				slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+			} else {
+				saved = append(saved, ev.Event.ID)
+			}
+		}
+
+		if ev.Event.Kind == KindReporting {
+			err := st.SaveReport(ctx, ev.Event, false)
+			if err != nil {
+				slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+			} else {
+				saved = append(saved, ev.Event.ID)
+			}
+		}
+
+		// votes, the last e tag is the note that is reacted on (NIP-25)
 		if ev.Event.Kind == 7 && len(etags) > 0 {
-			t := ev.Event.Tags.GetFirst([]string{"e"})
+			t := ev.Event.Tags.GetLast([]string{"e"})
 			if t != nil {
 				targetEventId := t.Value()
+				if err := st.count(ctx, targetEventId, ev.Event.ID, reactionCounters(ev.Event.Content)); err != nil {
+					slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+				} else {
+					saved = append(saved, ev.Event.ID)
+				}
 
 				var result Note
 				st.GormDB.WithContext(ctx).Where("event_id = ?", targetEventId).Find(&result) // only add votes for existing
 
 				if result.ID > 0 {
 					st.SaveReaction(ctx, ev.Event, targetEventId, result.ID)
+					if err := st.notifyReaction(ctx, ev.Event, result); err != nil {
+						slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+					}
 				}
 			}
 		}
+
+		if ev.Event.Kind == nostr.KindRepost {
+			if t := ev.Event.Tags.GetLast([]string{"e"}); t != nil {
+				if err := st.count(ctx, t.Value(), ev.Event.ID, Counters{Reposts: 1}); err != nil {
+					slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+				} else {
+					saved = append(saved, ev.Event.ID)
+				}
+			}
+			if err := st.notifyRepost(ctx, ev.Event); err != nil {
+				slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+			}
+		}
+
+		if ev.Event.Kind == nostr.KindZap {
+			if zapCtx == nil {
+				var cancel context.CancelFunc
+				zapCtx, cancel = context.WithTimeout(ctx, zapLookupTimeout)
+				defer cancel()
+			}
+			zap, err := st.checkZap(zapCtx, ev.Event)
+			if err != nil {
+				slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+				continue
+			}
+			if zap.TargetEventId != "" {
+				if err := st.count(ctx, zap.TargetEventId, ev.Event.ID, Counters{Zaps: 1, ZapAmount: zap.Amount}); err != nil {
+					slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+				} else {
+					saved = append(saved, ev.Event.ID)
+				}
+			}
+			if err := st.notifyZap(ctx, ev.Event, zap); err != nil {
+				slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+			}
+		}
 	}
-	return pubkeys, nil
+	return saved, nil
 }
 
 func (st *Storage) SaveNote(ctx context.Context, event *Event) (Note, error) {
@@ -349,7 +437,6 @@ func (st *Storage) SaveNote(ctx context.Context, event *Event) (Note, error) {
 	note.Garbage = Garbage
 	note.Raw = jsonbuf.Bytes()
 	note.Root = isRoot
-	note.Urls = event.Urls
 	note.UpdatedAt.Time = time.Now()
 
 	var searchProfile Profile
@@ -381,6 +468,21 @@ func (st *Storage) SaveNote(ctx context.Context, event *Event) (Note, error) {
 		return Note{}, err
 	}
 
+	if note.ID > 0 {
+		if err := st.ResolveMissingEvents(ctx, []string{note.EventId}); err != nil {
+			slog.Error(logger.GetCallerInfo(1), "error", err.Error())
+		}
+		if err := st.detectSpam(ctx, note); err != nil {
+			slog.Error(logger.GetCallerInfo(1), "error", err.Error())
+		}
+		if err := st.notifyNote(ctx, note, tree, hasNotification); err != nil {
+			slog.Error(logger.GetCallerInfo(1), "error", err.Error())
+		}
+		if err := st.count(ctx, replyParent(tree), note.EventId, Counters{Replies: 1}); err != nil {
+			slog.Error(logger.GetCallerInfo(1), "error", err.Error())
+		}
+	}
+
 	if note.ID > 0 && len(tree.RootTag) > 0 {
 		treeData := Tree{EventId: ev.ID, RootEventId: tree.RootTag, ReplyEventId: tree.ReplyTag}
 		err = st.GormDB.Model(&Tree{}).WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&treeData).Error
@@ -388,14 +490,6 @@ func (st *Storage) SaveNote(ctx context.Context, event *Event) (Note, error) {
 			slog.Error(logger.GetCallerInfo(1), "error", err.Error())
 		}
 
-		if hasNotification {
-			notification := Notification{NoteID: note.ID}
-			err = st.GormDB.Model(&Notification{}).WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&notification).Error
-			if err != nil {
-				slog.Error(logger.GetCallerInfo(1), "error", err.Error())
-			}
-		}
-
 		// Check if we already have the root Note
 		var searchNoteRootNote Note
 		err = st.GormDB.Model(&Note{}).Where(&Note{EventId: tree.RootTag}).Find(&searchNoteRootNote).Error
@@ -404,7 +498,9 @@ func (st *Storage) SaveNote(ctx context.Context, event *Event) (Note, error) {
 			return Note{}, err
 		}
 		if searchNoteRootNote.ID == 0 {
-			Missing_event_ids = append(Missing_event_ids, tree.RootTag)
+			if err := st.AddMissingEvent(ctx, tree.RootTag, event); err != nil {
+				slog.Error(logger.GetCallerInfo(1), "error", err.Error())
+			}
 		}
 
 		// Same goes for reply which is replied to
@@ -416,7 +512,9 @@ func (st *Storage) SaveNote(ctx context.Context, event *Event) (Note, error) {
 				return Note{}, err
 			}
 			if searchNoteReplyNote.ID == 0 {
-				Missing_event_ids = append(Missing_event_ids, tree.ReplyTag)
+				if err := st.AddMissingEvent(ctx, tree.ReplyTag, event); err != nil {
+					slog.Error(logger.GetCallerInfo(1), "error", err.Error())
+				}
 			}
 		}
 	}
@@ -449,20 +547,6 @@ type Options struct {
 	Renew    bool
 }
 
-func (st *Storage) GetNewNotesCount(ctx context.Context, cursor uint64, options Options) (int, error) {
-	var count int
-	tx := st.GormDB.Model(&NotesAndProfiles{}).
-		Select(`COUNT(id)`).
-		Where("id > ?", cursor).
-		Where("followed = ? and bookmarked = ?", options.Follow, options.BookMark).
-		Find(&count)
-
-	if tx.Error != nil {
-		return 0, tx.Error
-	}
-	return count, nil
-}
-
 func (st *Storage) GetLastSeenID(ctx context.Context) (int, error) {
 	var maxId int
 	tx := st.GormDB.Model(&Seen{}).
@@ -479,14 +563,17 @@ func (st *Storage) initPaging(p *Pagination, options Options) {
 		var notesAndProfiles []NotesAndProfiles
 		fmt.Println("Empty cursor")
 		if !options.BookMark {
-			st.GormDB.Debug().Model(&NotesAndProfiles{}).
+			tx := st.GormDB.Debug().Model(&NotesAndProfiles{}).
 				Where(`id < (SELECT MAX(id) FROM "notes_and_profiles" WHERE followed = @follow and bookmarked = @bookmark)`, sql.Named("follow", options.Follow), sql.Named("bookmark", options.BookMark)).
-				Where("followed = @follow and bookmarked = @bookmark", sql.Named("follow", options.Follow), sql.Named("bookmark", options.BookMark)).
+				Where("followed = @follow and bookmarked = @bookmark", sql.Named("follow", options.Follow), sql.Named("bookmark", options.BookMark))
+			st.hideReported(tx, options).
 				Order("id DESC").
 				Limit(30).
 				Find(&notesAndProfiles)
 
-			p.Cursor = notesAndProfiles[len(notesAndProfiles)-1].ID
+			if len(notesAndProfiles) > 0 { // An empty feed starts at the beginning
+				p.Cursor = notesAndProfiles[len(notesAndProfiles)-1].ID
+			}
 		}
 	}
 }
@@ -554,6 +641,10 @@ func (st *Storage) GetNotes(ctx context.Context, context string, p *Pagination,
 	slog.Info("State is: ", "state", state)
 
 	tx := st.GormDB.Debug().Where("followed = ? and bookmarked = ?", options.Follow, options.BookMark)
+	tx = st.hideReported(tx, options)
+	if p.Unread {
+		tx = tx.Where(unreadCondition)
+	}
 
 	if state == stateName[StateInit] || state == stateName[StateRefresh] {
 		tx.Where("id > ?", p.Cursor).
@@ -607,117 +698,21 @@ func (st *Storage) GetNotes(ctx context.Context, context string, p *Pagination,
 		return rows[i].EventCreatedAt.Time().Unix() > rows[j].EventCreatedAt.Time().Unix()
 	})
 
-	eventMap, keys, seenMap, err := st.procesEventRows(&rows)
+	eventMap, keys, _, err := st.procesEventRows(&rows)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
-	tx = st.GormDB.WithContext(ctx).Model(&Seen{})
-	seens := []*Seen{}
-	for noteid, eventid := range seenMap {
-		seens = append(seens, &Seen{NoteID: uint(noteid), EventId: eventid})
+	if err := st.addReplies(ctx, eventMap); err != nil {
+		return nil, err
 	}
-
-	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seens)
-	if result.Error != nil {
-		return &[]Event{}, result.Error
+	if err := st.addReadState(ctx, eventMap); err != nil {
+		return nil, err
 	}
-
-	st.getChildren(ctx, eventMap)
-
-	events := make([]Event, 0)
-	// Make sure the order stays the same
-	for _, k := range keys {
-		events = append(events, eventMap[k])
+	if err := st.addCounters(ctx, eventMap); err != nil {
+		return nil, err
 	}
 
-	return &events, nil
-}
-
-func (st *Storage) GetNotifications(ctx context.Context, p *Pagination) (*[]Event, error) {
-	var state string
-	if p.Cursor == 0 {
-		state = stateName[StateInit]
-	}
-	if p.Cursor > 0 {
-		state = stateName[StateRefresh]
-	}
-	if p.NextCursor != 0 {
-		state = stateName[StateNext]
-	}
-	if p.PreviousCursor != 0 {
-		state = stateName[StatePrev]
-	}
-	slog.Info("State is: ", "state", state)
-
-	tx := st.GormDB.Debug().Model(&Note{}).
-		Joins("JOIN notifications ON (notifications.note_id = notes.id)").
-		Limit(int(p.GetPerPage())) // Last one is not shown and only used for the next cursor
-
-	var notes []Note
-	tx.Find(&notes)
-	if len(notes) == 0 {
-		return &[]Event{}, nil
-	}
-
-	var root_tags []string
-	for _, row := range notes {
-		//var etags []string
-		var ev *nostr.Event
-		json.Unmarshal(row.Raw, &ev)
-		_, _, _, _, tree, _ := tag.ProcessTags(ev, st.Pubkey)
-
-		fmt.Println(tree.RootTag)
-		root_tags = append(root_tags, tree.RootTag)
-	}
-	var rows []NotesAndProfiles
-	err := st.GormDB.Debug().Model(&NotesAndProfiles{}).Where("event_id IN (?)", root_tags).Find(&rows).Error
-	if err != nil {
-		slog.Error(logger.GetCallerInfo(1), "error", err.Error())
-		return nil, nil
-	}
-
-	if len(notes) == 0 {
-		return &[]Event{}, nil
-	}
-
-	p.NextCursor = 0
-	p.PreviousCursor = 0
-
-	sort.Slice(rows, func(i, j int) bool {
-		return rows[i].ID > rows[j].ID
-	})
-
-	if (state == stateName[StateInit] || state == stateName[StateNext] || state == stateName[StateRefresh]) && !(len(rows) < int(p.PerPage)) {
-		next_cursor := rows[0]
-		p.NextCursor = next_cursor.ID
-	}
-
-	if (state == stateName[StatePrev] || state == stateName[StateRefresh]) && !(len(rows) < int(p.PerPage)) {
-		next_cursor := rows[0]
-		p.NextCursor = next_cursor.ID
-	}
-
-	if state == stateName[StateInit] || state == stateName[StateNext] || state == stateName[StateRefresh] {
-		prev_cursor := rows[len(rows)-1]
-		p.PreviousCursor = prev_cursor.ID
-	}
-	if (state == stateName[StatePrev] || state == stateName[StateRefresh]) && !(len(rows) < int(p.PerPage)) {
-		prev_cursor := rows[len(rows)-1]
-		p.PreviousCursor = prev_cursor.ID
-	}
-
-	sort.Slice(rows, func(i, j int) bool {
-		return rows[i].EventCreatedAt.Time().Unix() > rows[j].EventCreatedAt.Time().Unix()
-	})
-
-	eventMap, keys, _, err := st.procesEventRows(&rows)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-
-	st.getChildren(ctx, eventMap)
-
 	events := make([]Event, 0)
 	// Make sure the order stays the same
 	for _, k := range keys {
@@ -785,7 +780,7 @@ func (st *Storage) procesEventRows(rows *[]NotesAndProfiles) (map[string]Event,
 		note.Content = st.parseReferences(&note)
 
 		seenMap[item.ID] = note.Event.ID
-		note.Children = make(map[string]*Event, 0)
+		note.Children = make([]*Event, 0)
 		eventMap[note.Event.ID] = note
 		keys = append(keys, note.Event.ID) // Make sure the order stays the same @see https://go.dev/blog/maps
 	}
@@ -841,165 +836,6 @@ func (st *Storage) parseReferences(note *Event) string {
 	return content
 }
 
-func (st *Storage) getChildren(ctx context.Context, eventMap map[string]Event) error {
-	var err error
-
-	/**
-	 * Get all child notes
-	 */
-
-	tx := st.GormDB.WithContext(ctx).Table("notes").
-		Select(`trees.root_event_id, trees.reply_event_id, notes.id, notes.event_id, notes.pubkey, notes.kind, notes.event_created_at, 
-		notes.content, notes.tags_full::json, notes.sig, notes.etags, notes.ptags,
-		profiles.name, profiles.about , profiles.picture,
-		profiles.website, profiles.nip05, profiles.lud16, profiles.display_name, 
-		CASE WHEN length(follows.pubkey) > 0 THEN TRUE ELSE FALSE END followed, 
-		CASE WHEN length(bookmarks.event_id) > 0 THEN TRUE ELSE FALSE END bookmarked`).
-		Joins("JOIN trees ON (trees.event_id = notes.event_id)").
-		Joins("LEFT JOIN profiles ON (profiles.pubkey = notes.pubkey)").
-		Joins("LEFT JOIN blocks ON (blocks.pubkey = notes.pubkey)").
-		//Joins("LEFT JOIN seens on (seens.event_id = notes.event_id)").
-		Joins("LEFT JOIN follows ON (follows.pubkey = notes.pubkey)").
-		Joins("LEFT JOIN bookmarks ON (bookmarks.event_id = notes.event_id)").
-		Where("notes.kind = 1").
-		Where("blocks.pubkey IS NULL").
-		//Where("seens.event_id IS NULL").
-		Where("notes.garbage = false")
-
-	var eventIds string
-	var numIds int = 0
-	for k := range eventMap {
-		eventIds = eventIds + `'` + k + `',`
-		numIds++
-	}
-
-	if numIds < 1 {
-		return nil
-	}
-
-	eventIds = eventIds[:len(eventIds)-1]
-
-	tx.Where("trees.root_event_id IN (" + eventIds + ")")
-
-	treeRows, err := tx.Rows()
-	if err != nil {
-		log.Println(err)
-	}
-	for treeRows.Next() {
-		var childEvent Event
-		var id int
-		var root_event_id string
-		var reply_event_id sql.NullString
-		var name NullString
-		var about NullString
-		var picture NullString
-
-		var website NullString
-		var nip05 NullString
-		var lud16 NullString
-		var displayname NullString
-		var followed bool
-		var bookmarked bool
-		childEvent.Event = &nostr.Event{}
-
-		if err := treeRows.Scan(&root_event_id, &reply_event_id, &id,
-			&childEvent.Event.ID, &childEvent.Event.PubKey, &childEvent.Event.Kind, &childEvent.Event.CreatedAt, &childEvent.Event.Content, &childEvent.Event.Tags, &childEvent.Event.Sig,
-			(pq.Array)(&childEvent.Etags), (pq.Array)(&childEvent.Ptags), &name, &about, &picture,
-			&website, &nip05, &lud16, &displayname, &followed, &bookmarked); err != nil {
-			log.Println(err.Error())
-			panic(err)
-		}
-
-		childEvent.Tree = 2
-		childEvent.RootId = root_event_id
-		childEvent.Profile = Profile{}
-		childEvent.Profile.Pubkey = childEvent.Event.PubKey
-		if name.Valid {
-			childEvent.Profile.Name = name
-		} else {
-			childEvent.Profile.Name.String = childEvent.Event.PubKey
-		}
-		if about.Valid {
-			childEvent.Profile.About = about
-		}
-		if picture.Valid {
-			childEvent.Profile.Picture = picture
-		}
-
-		if website.Valid {
-			childEvent.Profile.Website = website
-		}
-		if nip05.Valid {
-			childEvent.Profile.Nip05 = nip05
-		}
-		if lud16.Valid {
-			childEvent.Profile.Lud16 = lud16
-		}
-		if displayname.Valid {
-			childEvent.Profile.DisplayName = displayname
-		}
-
-		childEvent.Profile.Followed = followed
-
-		childEvent.Bookmark = bookmarked
-
-		/*
-			if childEvent.Event.PubKey == "39fa74ddf269649ce45d40b9de4ae8a8f94e7713d74d18901f1f24906c97e3e4" {
-				log.Fatal("Pubkey: ", childEvent.Event.PubKey, childEvent.Profile.Name)
-			}
-		*/
-		childEvent.Content = st.parseReferences(&childEvent)
-
-		if item, ok := eventMap[root_event_id]; ok {
-
-			if reply_event_id.Valid {
-				if reply_event_id.String == "" {
-					childEvent.Tree = 1
-					childEvent.Children = make(map[string]*Event, 0)
-					item.Children[childEvent.Event.ID] = &childEvent
-					//eventMap[root_event_id] = item
-				}
-				if reply_event_id.String != "" {
-					//fmt.Println("Child [", childEvent.Event.ID, "] :: replied to ["+reply_event_id.String+"] "+childEvent.Event.Content)
-					walk(&item, childEvent, reply_event_id.String, 1)
-				}
-			}
-
-		}
-	}
-	if err := treeRows.Err(); err != nil {
-		log.Println(err)
-	}
-	treeRows.Close()
-
-	return nil
-}
-
-func walk(parent *Event, payload Event, reply_event_id string, level int64) bool {
-	if level > 8 {
-		return false
-	}
-
-	if parent.Event.ID == reply_event_id {
-		//fmt.Println("Found parent:: [", parent.Event.ID, "] :: ", parent.Event.Content)
-		payload.Tree = level
-		if parent.Children == nil {
-			parent.Children = make(map[string]*Event, 0)
-		}
-		parent.Children[payload.Event.ID] = &payload
-		return true
-	}
-
-	if len(parent.Children) > 0 {
-		for _, Node := range parent.Children {
-			if ok := walk(Node, payload, reply_event_id, level+1); ok {
-				return true
-			}
-		}
-	}
-	return false
-}
-
 func (st *Storage) GetInbox(ctx context.Context, context string, p *Pagination, pubkey string) (*[]Event, error) {
 	qry := `
 	SELECT
@@ -1014,12 +850,17 @@ func (st *Storage) GetInbox(ctx context.Context, context string, p *Pagination,
         	notes e1
         	JOIN (
 		        SELECT t.root_event_id, t.event_id, t.reply_event_id FROM trees t, notes e2
-       		 	WHERE e2.pubkey = $1
+       		 	WHERE e2.pubkey = ?
         		AND e2.event_id = t.event_id
 			) t0 ON e1.event_id = t0.root_event_id
         ) tbl
         ON
-        (tbl.event_id = np.event_id)
+        (tbl.event_id = np.event_id)`
+	if p.Unread {
+		qry = qry + `
+        WHERE NOT EXISTS (SELECT 1 FROM seens WHERE seens.note_id = np.id)`
+	}
+	qry = qry + `
         ORDER BY np.event_created_at DESC`
 
 	var rows []NotesAndProfiles
@@ -1031,7 +872,15 @@ func (st *Storage) GetInbox(ctx context.Context, context string, p *Pagination,
 
 	eventMap, keys, _, _ := st.procesEventRows(&rows)
 
-	st.getChildren(ctx, eventMap)
+	if err := st.addReplies(ctx, eventMap); err != nil {
+		return nil, err
+	}
+	if err := st.addReadState(ctx, eventMap); err != nil {
+		return nil, err
+	}
+	if err := st.addCounters(ctx, eventMap); err != nil {
+		return nil, err
+	}
 	events := make([]Event, 0)
 	// Make sure the order stays the same
 	for _, k := range keys {
@@ -1045,11 +894,11 @@ func (st *Storage) GetInbox(ctx context.Context, context string, p *Pagination,
  * Find an event based on an unique event id
  */
 func (st *Storage) FindEvent(ctx context.Context, id string) (Event, error) {
-	var qry = `SELECT e.event_id, e.pubkey, e.kind, e.event_created_at, e.content, e.tags_full::json, e.sig, e.etags, e.ptags, 
+	var qry = `SELECT e.event_id, e.pubkey, e.kind, e.event_created_at, e.content, e.tags_full, e.sig, e.etags, e.ptags, 
 	u.name, u.about , u.picture, u.website, u.nip05, u.lud16, u.display_name
 	FROM notes e LEFT JOIN profiles u ON (u.pubkey = e.pubkey ) 
 	LEFT JOIN blocks b on (b.pubkey = e.pubkey) 
-	WHERE e.event_id = $1`
+	WHERE e.event_id = ?`
 	//qry = qry + "'" + id + "'"
 	log.Println("Query:: ", qry)
 
@@ -1075,10 +924,10 @@ func (st *Storage) FindEvent(ctx context.Context, id string) (Event, error) {
 	}
 	event.Tree = 1
 	event.RootId = event.Event.ID
-	event.Children = make(map[string]*Event, 0)
+	event.Children = make([]*Event, 0)
 
 	treeQry := `SELECT t.root_event_id, t.reply_event_id, 
-	e.id, e.event_id, e.pubkey, e.kind, e.event_created_at, e.content,e.tags_full::json,e.sig, 
+	e.id, e.event_id, e.pubkey, e.kind, e.event_created_at, e.content,e.tags_full,e.sig, 
 	e.etags, e.ptags , u.name, u.about , u.picture,
 	u.website, u.nip05, u.lud16, u.display_name, 
 	CASE WHEN length(f.pubkey) > 0 THEN TRUE ELSE FALSE end followed
@@ -1126,7 +975,7 @@ func (st *Storage) FindEvent(ctx context.Context, id string) (Event, error) {
 
 		childEvent.RootId = event.Event.ID
 		childEvent.Tree = 2
-		childEvent.Children = make(map[string]*Event, 0)
+		childEvent.Children = make([]*Event, 0)
 
 		if name.Valid {
 			childEvent.Profile.Name.String = name.String
@@ -1155,16 +1004,16 @@ func (st *Storage) FindEvent(ctx context.Context, id string) (Event, error) {
 
 		childEvent.Profile.Followed = followed
 		childEvent.Bookmark = bookmarked
-		event.Children[childEvent.Event.ID] = &childEvent
+		event.Children = append(event.Children, &childEvent)
 	}
 
 	return event, err
 }
 
 func (st *Storage) FindRawEvent(ctx context.Context, id string) (*Event, error) {
-	var qry = `SELECT e.event_id, e.pubkey, e.kind, e.event_created_at, e.content, e.sig, e.tags_full::json
+	var qry = `SELECT e.event_id, e.pubkey, e.kind, e.event_created_at, e.content, e.sig, e.tags_full
 	FROM notes e 
-	WHERE e.event_id = $1`
+	WHERE e.event_id = ?`
 
 	event := Event{}
 	event.Event = &nostr.Event{}
@@ -1177,38 +1026,6 @@ func (st *Storage) FindRawEvent(ctx context.Context, id string) (*Event, error)
 	return &event, err
 }
 
-func (st *Storage) CheckProfiles(ctx context.Context, pubkeys []string, epochtime int64) ([]string, error) {
-	qry := `SELECT pubkey FROM profiles WHERE EXTRACT(EPOCH FROM created_at) > $1 AND pubkey in (`
-
-	for _, pubkey := range pubkeys {
-		qry = qry + `'` + pubkey + `',`
-	}
-	qry = qry[:len(qry)-1] + `)`
-
-	rows, err := st.GormDB.WithContext(ctx).Raw(qry, epochtime).Rows()
-	if err != nil {
-		log.Println("CheckProfile() -> Query:: ", err)
-		return []string{}, err
-	}
-
-	pubkeysMap := make(map[string]string)
-	for _, pk := range pubkeys { // Put all pubkeys in a map
-		pubkeysMap[pk] = pk
-	}
-	for rows.Next() {
-		var pubkey string
-		_ = rows.Scan(&pubkey)
-		delete(pubkeysMap, pubkey) // Ignore all pubkeys from the result
-	}
-
-	pubkeysFinal := make([]string, 0) // Create empty []string, i think you can also use []string{}
-	for _, pk := range pubkeysMap {
-		pubkeysFinal = append(pubkeysFinal, pk) // Transform it back to a []string
-	}
-
-	return pubkeysFinal, nil
-}
-
 /**
  * Some users just posting garbage, so we try to block those by putting them on the naugthy list
  */
@@ -1234,6 +1051,10 @@ func (st *Storage) CreateFollow(ctx context.Context, pubkey string) error {
 		log.Println("CreateFollow() -> Create follow: ", tx.Error.Error())
 	}
 	st.GormDB.Model(&Profile{}).Where("pubkey = ?", pubkey).Update("followed", true)
+
+	if err := st.CreateBackfill(ctx, pubkey, false); err != nil {
+		log.Println("CreateFollow() -> Create backfill: ", err.Error())
+	}
 	return nil
 }
 
@@ -1257,6 +1078,28 @@ func (st *Storage) GetFollowedProfiles(ctx context.Context) []Profile {
 	return profiles
 }
 
+// Pubkeys of everyone we follow, also the ones we do not have a profile of yet
+func (st *Storage) GetFollows(ctx context.Context) []string {
+	var pubkeys []string
+	st.GormDB.WithContext(ctx).Model(&Follow{}).Order("id ASC").Pluck("pubkey", &pubkeys)
+
+	return pubkeys
+}
+
+/**
+ * Event ids of our own notes since a certain time, so we can ask the relays for replies and reactions on them.
+ */
+func (st *Storage) GetOwnNoteIds(ctx context.Context, since int64, limit int) []string {
+	var ids []string
+	st.GormDB.WithContext(ctx).Model(&Note{}).
+		Where("pubkey = ? AND kind = 1 AND event_created_at > ?", st.Pubkey, since).
+		Order("event_created_at DESC").
+		Limit(limit).
+		Pluck("event_id", &ids)
+
+	return ids
+}
+
 func (st *Storage) CreateBookMark(ctx context.Context, eventID string) error {
 	var note Note
 	st.GormDB.WithContext(ctx).Where("event_id = ?", eventID).Find(&note)
@@ -1282,6 +1125,7 @@ func (st *Storage) RemoveBookMark(ctx context.Context, eventID string) error {
 }
 
 func (st *Storage) CreateRelay(ctx context.Context, relay *Relay) error {
+	relay.Url = nostr.NormalizeURL(relay.Url)
 	tx := st.GormDB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&relay)
 
 	log.Println(relay)
@@ -1293,8 +1137,19 @@ func (st *Storage) CreateRelay(ctx context.Context, relay *Relay) error {
 	return nil
 }
 
+// Relays stored before the urls were normalized are removed too
 func (st *Storage) RemoveRelay(ctx context.Context, url string) error {
-	err := st.GormDB.WithContext(ctx).Where("url = ?", url).Delete(&Relay{}).Error
+	url = nostr.NormalizeURL(url)
+	var urls []string
+	st.GormDB.WithContext(ctx).Model(&Relay{}).Pluck("url", &urls)
+	remove := []string{url}
+	for _, u := range urls {
+		if u != url && nostr.NormalizeURL(u) == url {
+			remove = append(remove, u)
+		}
+	}
+
+	err := st.GormDB.WithContext(ctx).Where("url IN (?)", remove).Delete(&Relay{}).Error
 	if err != nil {
 		log.Println("RemoveRelay() -> Query:: ", err)
 		return err
@@ -1306,17 +1161,13 @@ func (st *Storage) RemoveRelay(ctx context.Context, url string) error {
 func (st *Storage) GetRelays(ctx context.Context) []Relay {
 	var relays []Relay
 	st.GormDB.WithContext(ctx).Model(&Relay{}).Scan(&relays)
+	for i := range relays {
+		relays[i].Url = nostr.NormalizeURL(relays[i].Url)
+	}
 
 	return relays
 }
 
-func (st *Storage) GetLastTimeStamp(ctx context.Context) int64 {
-	var createdAt int64
-	st.GormDB.WithContext(ctx).Raw("SELECT MAX(event_created_at) as MaxCreated FROM notes").Scan(&createdAt)
-
-	return createdAt
-}
-
 func (st *Storage) FindProfile(ctx context.Context, pubkey string) (Profile, error) {
 	var profile Profile
 	err := st.GormDB.Debug().Model(&Profile{}).Where("pubkey = ?", pubkey).Find(&profile).Error
@@ -1333,7 +1184,7 @@ func (st *Storage) FindProfile(ctx context.Context, pubkey string) (Profile, err
 
 func (st *Storage) SearchProfiles(ctx context.Context, searchStr string) (*[]Profile, error) {
 	var profile []Profile
-	err := st.GormDB.Model(&Profile{}).Where("pubkey = $1 OR LOWER(name) LIKE LOWER($2)", searchStr, "%"+searchStr+"%").Find(&profile).Error
+	err := st.GormDB.Model(&Profile{}).Where("pubkey = ? OR LOWER(name) LIKE LOWER(?)", searchStr, "%"+searchStr+"%").Find(&profile).Error
 
 	switch {
 	case err == sql.ErrNoRows:
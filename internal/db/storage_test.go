@@ -0,0 +1,1266 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+/**
+ * Every test runs against sqlite, and against postgres when PGHOST is set.
+ * Both get all the migrations, the tests only add rows with new keys.
+ */
+func testStores(t *testing.T) map[string]*Storage {
+	ctx := context.Background()
+	stores := make(map[string]*Storage)
+
+	lite := &Storage{Pubkey: newPubkey()}
+	if err := lite.Connect(ctx, &DbConfig{Driver: Sqlite, Path: filepath.Join(t.TempDir(), "nostr-reader.db")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lite.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := lite.GormDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	stores[Sqlite] = lite
+
+	if os.Getenv("PGHOST") != "" {
+		port, err := strconv.Atoi(os.Getenv("PGPORT"))
+		if err != nil {
+			port = 5432
+		}
+		pg := &Storage{Pubkey: lite.Pubkey}
+		err = pg.Connect(ctx, &DbConfig{
+			Driver:   Postgres,
+			Host:     os.Getenv("PGHOST"),
+			User:     os.Getenv("PGUSER"),
+			Password: os.Getenv("PGPASSWORD"),
+			Dbname:   os.Getenv("PGDATABASE"),
+			Port:     port,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pg.MigrateUp(); err != nil {
+			t.Fatal(err)
+		}
+		stores[Postgres] = pg
+	}
+
+	return stores
+}
+
+func newPubkey() string {
+	pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	return pubkey
+}
+
+func signed(t *testing.T, sk string, kind int, content string, tags nostr.Tags) *Event {
+	ev := nostr.Event{Kind: kind, Content: content, Tags: tags, CreatedAt: nostr.Now()}
+	if err := ev.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return &Event{Event: &ev}
+}
+
+// A bolt11 invoice for the amount of the hrp with the hash of the description, the signature is empty
+func testInvoice(t *testing.T, hrp string, description string) string {
+	hash := sha256.Sum256([]byte(description))
+	groups, err := bech32.ConvertBits(hash[:], 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(make([]byte, 7), 23, 1, 20) // The timestamp and the h field of 52 groups
+	data = append(append(data, groups...), make([]byte, 104)...)
+	invoice, err := bech32.Encode(hrp, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return invoice
+}
+
+// A zap request of the zapper for the note and the receipt of it signed by the wallet
+func testZap(t *testing.T, wallet string, zapper string, recipient string, noteId string, hrp string, amount string) *Event {
+	request := nostr.Event{Kind: nostr.KindZapRequest, Content: "great", CreatedAt: nostr.Now(), Tags: nostr.Tags{
+		{"p", recipient}, {"e", noteId}, {"amount", amount},
+	}}
+	if err := request.Sign(zapper); err != nil {
+		t.Fatal(err)
+	}
+	description := request.String()
+	return signed(t, wallet, nostr.KindZap, "", nostr.Tags{
+		{"p", recipient}, {"e", noteId}, {"bolt11", testInvoice(t, hrp, description)}, {"description", description},
+	})
+}
+
+// Give the pubkey of the secret key a lightning address, the returned key is the one of its wallet
+func testWallet(t *testing.T, st *Storage, sk string) string {
+	pubkey, _ := nostr.GetPublicKey(sk)
+	lud16 := pubkey[:12] + "@wallet.test"
+	if _, err := st.SaveEvents(context.Background(), []*Event{signed(t, sk, nostr.KindProfileMetadata, `{"lud16":"`+lud16+`"}`, nostr.Tags{})}); err != nil {
+		t.Fatal(err)
+	}
+
+	wallet := nostr.GeneratePrivateKey()
+	walletPubkey, _ := nostr.GetPublicKey(wallet)
+	st.Zappers = func(ctx context.Context, address string) (string, error) {
+		if address != lud16 {
+			return "", errors.New("unknown lightning address")
+		}
+		return walletPubkey, nil
+	}
+	return wallet
+}
+
+func TestSaveNotesAndThread(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sk := nostr.GeneratePrivateKey()
+
+			root := signed(t, sk, nostr.KindTextNote, "root note "+name+" "+sk, nostr.Tags{})
+			reply := signed(t, sk, nostr.KindTextNote, "reply note "+name+" "+sk, nostr.Tags{
+				{"e", root.Event.ID, "", "root"},
+				{"p", root.Event.PubKey},
+			})
+			future := nostr.Event{Kind: nostr.KindTextNote, Content: "from the future", CreatedAt: nostr.Now() + 3600, Tags: nostr.Tags{}}
+			if err := future.Sign(sk); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := st.SaveEvents(ctx, []*Event{root, reply, {Event: &future}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 2 || slices.Contains(saved, future.ID) {
+				t.Logf("only the root and reply should be saved, got %v", saved)
+				t.Fail()
+			}
+			if saved, err := st.SaveEvents(ctx, []*Event{root}); err != nil || len(saved) != 0 {
+				t.Logf("a note we already have is not saved again, got %v %v", saved, err)
+				t.Fail()
+			}
+
+			if !st.HasNote(ctx, reply.Event.ID) {
+				t.Log("the reply should be stored")
+				t.Fail()
+			}
+			raw, err := st.FindRawEvent(ctx, reply.Event.ID)
+			if err != nil || raw.Event.Content != reply.Event.Content || len(raw.Event.Tags) != 2 {
+				t.Log("the raw reply should have the content and the tags we stored", err)
+				t.Fail()
+			}
+			if st.GetThreadRoot(ctx, reply.Event.ID) != root.Event.ID {
+				t.Log("the root of the reply should be the root note")
+				t.Fail()
+			}
+
+			tree, err := st.GetThread(ctx, root.Event.ID, ReplyPage{})
+			if err != nil || tree.Root == nil || len(tree.Root.Children) != 1 || tree.Root.Children[0].Event.ID != reply.Event.ID {
+				t.Log("the thread should have the root and the reply", err)
+				t.Fail()
+			}
+
+			notes, err := st.GetAuthorNotes(ctx, root.Event.PubKey, 0, "", 1)
+			if err != nil || len(notes) != 1 {
+				t.Fatal("the first page of the author should have one note", err)
+			}
+			last := notes[0].Event
+			notes, err = st.GetAuthorNotes(ctx, root.Event.PubKey, int64(last.CreatedAt), last.ID, 10)
+			if err != nil || len(notes) != 1 || notes[0].Event.ID == last.ID {
+				t.Log("the second page of the author should have the other note", err)
+				t.Fail()
+			}
+
+			// The first page starts below the newest note
+			newest := signed(t, sk, nostr.KindTextNote, "newest note "+name+" "+sk, nostr.Tags{})
+			if _, err := st.SaveEvents(ctx, []*Event{newest}); err != nil {
+				t.Fatal(err)
+			}
+			p := Pagination{PerPage: 10}
+			feed, err := st.GetNotes(ctx, "", &p, Options{})
+			if err != nil || len(*feed) == 0 {
+				t.Log("the global feed should have the root note", err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestThreadTree(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sk := nostr.GeneratePrivateKey()
+			at := nostr.Now() - 1000
+
+			note := func(content string, tags nostr.Tags) *Event {
+				at++
+				ev := nostr.Event{Kind: nostr.KindTextNote, Content: content + " " + name + " " + sk, Tags: tags, CreatedAt: at}
+				if err := ev.Sign(sk); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := st.SaveEvents(ctx, []*Event{{Event: &ev}}); err != nil {
+					t.Fatal(err)
+				}
+				return &Event{Event: &ev}
+			}
+
+			root := note("root", nostr.Tags{})
+			rootId := root.Event.ID
+
+			// Deeper than the 8 levels we used to have
+			parent := rootId
+			for i := 0; i < 12; i++ {
+				parent = note("level "+strconv.Itoa(i), nostr.Tags{{"e", rootId, "", "root"}, {"e", parent, "", "reply"}}).Event.ID
+			}
+			second := note("second", nostr.Tags{{"e", rootId, "", "root"}})
+			third := note("third", nostr.Tags{{"e", rootId, "", "root"}})
+			orphan := note("orphan", nostr.Tags{{"e", rootId, "", "root"}, {"e", newPubkey(), "", "reply"}})
+
+			tree, err := st.GetThread(ctx, rootId, ReplyPage{})
+			if err != nil || tree.Root == nil {
+				t.Fatal("the thread should have the root", err)
+			}
+			if tree.Root.Replies != 3 || tree.Root.AllReplies != 14 {
+				t.Logf("the root should have 3 replies and 14 under it, got %d and %d", tree.Root.Replies, tree.Root.AllReplies)
+				t.Fail()
+			}
+			depth := int64(0)
+			for ev := tree.Root; ev != nil; {
+				depth = ev.Tree
+				if len(ev.Children) == 0 {
+					break
+				}
+				ev = ev.Children[0]
+			}
+			if depth != 13 {
+				t.Logf("the first reply should go 13 levels deep, got %d", depth)
+				t.Fail()
+			}
+			if len(tree.Orphans) != 1 || tree.Orphans[0].Event.ID != orphan.Event.ID || !tree.Orphans[0].Orphan || len(tree.MissingIds) != 1 {
+				t.Log("the reply to a note we do not have should be kept as orphan")
+				t.Fail()
+			}
+
+			// Oldest first, two per level
+			tree, err = st.GetThread(ctx, rootId, ReplyPage{PerLevel: 2})
+			if err != nil || len(tree.Root.Children) != 2 || tree.Root.Children[1].Event.ID != second.Event.ID {
+				t.Fatal("the first page should have the two oldest replies", err)
+			}
+			last := tree.Root.Children[1].Event
+			tree, err = st.GetThread(ctx, rootId, ReplyPage{PerLevel: 2, After: int64(last.CreatedAt), AfterId: last.ID})
+			if err != nil || len(tree.Root.Children) != 1 || tree.Root.Children[0].Event.ID != third.Event.ID || len(tree.Orphans) != 0 {
+				t.Log("the second page should only have the last reply", err)
+				t.Fail()
+			}
+
+			// We do not have the note the orphan replies to
+			tree, err = st.GetThread(ctx, orphan.Event.Tags[1][1], ReplyPage{})
+			if err != nil || tree.Root != nil || len(tree.Orphans) != 1 || tree.Orphans[0].Event.ID != orphan.Event.ID {
+				t.Log("the replies of a note we do not have should be orphans", err)
+				t.Fail()
+			}
+
+			// The replies of a reply
+			tree, err = st.GetThread(ctx, parent, ReplyPage{})
+			if err != nil || tree.Root == nil || tree.Root.Event.ID != parent || len(tree.Root.Children) != 0 {
+				t.Log("the deepest reply should have no replies", err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestAuthorFollowers(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			target := newPubkey()
+			other := newPubkey()
+
+			for i := 0; i < 2; i++ {
+				list := signed(t, nostr.GeneratePrivateKey(), nostr.KindContactList, "", nostr.Tags{{"p", target}, {"p", other}})
+				if err := st.SaveContactList(ctx, list.Event); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			author, err := st.GetAuthor(ctx, target)
+			if err != nil || author.Followers != 2 {
+				t.Logf("the author should have 2 followers, got %d (%v)", author.Followers, err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestProfileRefresh(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sk := nostr.GeneratePrivateKey()
+			pubkey, _ := nostr.GetPublicKey(sk)
+			weekAgo := time.Now().AddDate(0, 0, -7).Unix()
+
+			note := signed(t, sk, nostr.KindTextNote, "author without profile "+name, nostr.Tags{})
+			if _, err := st.SaveEvents(ctx, []*Event{note}); err != nil {
+				t.Fatal(err)
+			}
+			stale, err := st.GetStaleProfiles(ctx, time.Now().Add(-time.Hour), weekAgo, 1000)
+			if err != nil || !slices.Contains(stale, pubkey) {
+				t.Log("an author in the feed without a profile is stale")
+				t.Fail()
+			}
+
+			profile := func(createdAt nostr.Timestamp, name string) *Event {
+				ev := nostr.Event{Kind: nostr.KindProfileMetadata, Content: `{"name":"` + name + `"}`, CreatedAt: createdAt, Tags: nostr.Tags{}}
+				if err := ev.Sign(sk); err != nil {
+					t.Fatal(err)
+				}
+				return &Event{Event: &ev}
+			}
+			now := nostr.Now()
+			for _, ev := range []*Event{profile(now-100, "current"), profile(now-200, "older")} {
+				if err := st.SaveProfile(ctx, ev); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if found, _ := st.FindProfile(ctx, pubkey); found.Name.String != "current" {
+				t.Logf("an older kind 0 should not replace the profile, got %s", found.Name.String)
+				t.Fail()
+			}
+			if err := st.SaveProfile(ctx, profile(now, "newest")); err != nil {
+				t.Fatal(err)
+			}
+			if found, _ := st.FindProfile(ctx, pubkey); found.Name.String != "newest" {
+				t.Logf("a newer kind 0 should replace the profile, got %s", found.Name.String)
+				t.Fail()
+			}
+
+			if stale, _ := st.GetStaleProfiles(ctx, time.Now().Add(-time.Hour), weekAgo, 1000); slices.Contains(stale, pubkey) {
+				t.Log("a profile we just got is not stale")
+				t.Fail()
+			}
+			if stale, _ := st.GetStaleProfiles(ctx, time.Now().Add(time.Hour), weekAgo, 1000); !slices.Contains(stale, pubkey) {
+				t.Log("a profile we got before fetchedBefore is stale")
+				t.Fail()
+			}
+
+			// Asking again without getting a newer profile still counts
+			st.GormDB.Model(&Profile{}).Where("pubkey = ?", pubkey).Update("fetched_at", time.Now().Add(-2*time.Hour))
+			if err := st.MarkProfilesFetched(ctx, []string{pubkey}); err != nil {
+				t.Fatal(err)
+			}
+			if stale, _ := st.GetStaleProfiles(ctx, time.Now().Add(-time.Hour), weekAgo, 1000); slices.Contains(stale, pubkey) {
+				t.Log("a profile marked as fetched is not stale")
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestMissingEventHints(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sk := nostr.GeneratePrivateKey()
+			eventId := newPubkey() // any 32 byte hex will do
+
+			first := signed(t, sk, nostr.KindTextNote, "first", nostr.Tags{{"e", eventId, "wss://a.example.com", "root"}})
+			first.Urls = []string{"wss://b.example.com"}
+			second := signed(t, sk, nostr.KindTextNote, "second", nostr.Tags{{"e", eventId, "", "root"}})
+			second.Urls = []string{"wss://b.example.com", "wss://c.example.com"}
+
+			for _, ev := range []*Event{first, second} {
+				if err := st.AddMissingEvent(ctx, eventId, ev); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var missing MissingEvent
+			st.GormDB.Where("event_id = ?", eventId).Find(&missing)
+			if len(missing.RelayHints) != 3 {
+				t.Logf("the hints should be merged without duplicates, got %v", missing.RelayHints)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestEventRelays(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sk := nostr.GeneratePrivateKey()
+			pubkey, _ := nostr.GetPublicKey(sk)
+			ownPubkey := st.Pubkey
+			st.Pubkey = pubkey
+			defer func() { st.Pubkey = ownPubkey }()
+
+			note := signed(t, sk, nostr.KindTextNote, "seen on relays "+name, nostr.Tags{})
+			note.Urls = []string{"wss://A.example.com/", "wss://b.example.com"}
+			if _, err := st.SaveEvents(ctx, []*Event{note}); err != nil {
+				t.Fatal(err)
+			}
+			// The same note again from another relay, and from one we already knew written differently
+			note.Urls = []string{"wss://a.example.com", "wss://c.example.com"}
+			if _, err := st.SaveEvents(ctx, []*Event{note}); err != nil {
+				t.Fatal(err)
+			}
+
+			relays, err := st.GetEventRelays(ctx, note.Event.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			urls := make(map[string]bool)
+			for _, relay := range relays {
+				urls[relay.RelayUrl] = true
+			}
+			if len(relays) != 3 || !urls["wss://a.example.com"] || !urls["wss://b.example.com"] || !urls["wss://c.example.com"] {
+				t.Logf("every relay should be stored once with a normalized url, got %v", urls)
+				t.Fail()
+			}
+			if hints := st.GetRelayHints(ctx, note.Event.ID, 1); len(hints) != 1 {
+				t.Logf("the hints should be limited, got %v", hints)
+				t.Fail()
+			}
+
+			missing, err := st.GetOwnNotesMissingFromRelay(ctx, "wss://D.example.com/", 10)
+			if err != nil || len(missing) != 1 || missing[0].EventId != note.Event.ID {
+				t.Log("the note was never seen on relay d, so it is missing there")
+				t.Fail()
+			}
+			if missing, _ := st.GetOwnNotesMissingFromRelay(ctx, "wss://A.example.com/", 10); len(missing) != 0 {
+				t.Log("the note was seen on relay a")
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestModerationReasons(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sk := nostr.GeneratePrivateKey()
+			target := newPubkey()
+
+			// Only the reports of the people we follow count
+			reporter, _ := nostr.GetPublicKey(sk)
+			if err := st.CreateFollow(ctx, reporter); err != nil {
+				t.Fatal(err)
+			}
+			for _, reason := range []string{"spam", "impersonation", "spam"} {
+				report := signed(t, sk, KindReporting, "", nostr.Tags{{"p", target, reason}})
+				if err := st.SaveReport(ctx, report.Event, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entries, err := st.GetModeration(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, entry := range entries {
+				if entry.TargetPubkey == target {
+					found = true
+					if len(entry.Reasons) != 2 || entry.Reporters != 1 {
+						t.Logf("the reasons should be distinct, got %v", entry.Reasons)
+						t.Fail()
+					}
+				}
+			}
+			if !found {
+				t.Log("the reported pubkey should be in the moderation list")
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestOutboxContent(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			ev := signed(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, "hello "+name, nostr.Tags{})
+
+			if err := st.CreateOutbox(ctx, ev.Event, []string{"wss://a.example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := st.GetOutbox(ctx, []DeliveryStatus{DeliveryPending}, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, entry := range entries {
+				if entry.EventId == ev.Event.ID {
+					found = entry.Content == ev.Event.Content
+				}
+			}
+			if !found {
+				t.Log("the outbox should have the delivery with the content of the event")
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := st.CheckSchema(context.Background()); err != nil {
+				t.Log("the migrations should make the columns of the entities", err)
+				t.Fail()
+			}
+
+			status, err := st.GetMigrationStatus()
+			if err != nil || status.Dirty || status.Version != status.Latest {
+				t.Logf("all the migrations should be applied, got %+v (%v)", status, err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	st := testStores(t)[Sqlite]
+	ctx := context.Background()
+
+	// Every down migration, one at a time
+	for {
+		status, err := st.GetMigrationStatus()
+		if err != nil || status.Dirty {
+			t.Fatalf("the migrations should be undone cleanly, got %+v (%v)", status, err)
+		}
+		if status.Version == 0 {
+			break
+		}
+		if err := st.MigrateDown(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.CheckSchema(ctx); err == nil {
+		t.Log("the schema check should fail without tables")
+		t.Fail()
+	}
+
+	if err := st.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CheckSchema(ctx); err != nil {
+		t.Log("the schema should be back after migrating up again", err)
+		t.Fail()
+	}
+}
+
+func TestSearchNotes(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sk := nostr.GeneratePrivateKey()
+			word := "zq" + newPubkey()[:12] // Only in the notes of this test
+
+			first := signed(t, sk, nostr.KindTextNote, "the quick brown fox "+word, nostr.Tags{{"t", "Foxes"}})
+			second := signed(t, sk, nostr.KindTextNote, "brown quick dogs "+word+" and "+word, nostr.Tags{})
+			if _, err := st.SaveEvents(ctx, []*Event{first, second}); err != nil {
+				t.Fatal(err)
+			}
+
+			count := func(search NoteSearch) int {
+				results, err := st.SearchNotes(ctx, search, &Pagination{PerPage: 10})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return len(results)
+			}
+
+			if n := count(NoteSearch{Query: word + " quick"}); n != 2 {
+				t.Logf("both notes have the words, got %d", n)
+				t.Fail()
+			}
+			if n := count(NoteSearch{Query: `"quick brown" ` + word}); n != 1 {
+				t.Logf("only the first note has the phrase, got %d", n)
+				t.Fail()
+			}
+			if n := count(NoteSearch{Query: word[:8] + "*"}); n != 2 {
+				t.Logf("the prefix should match both notes, got %d", n)
+				t.Fail()
+			}
+			if n := count(NoteSearch{Query: word, Hashtags: []string{"#foxes"}}); n != 1 {
+				t.Logf("only the first note has the hashtag, got %d", n)
+				t.Fail()
+			}
+			if n := count(NoteSearch{Query: word, Authors: []string{newPubkey()}}); n != 0 {
+				t.Logf("an other author has no notes, got %d", n)
+				t.Fail()
+			}
+
+			for _, byRank := range []bool{true, false} {
+				p := &Pagination{PerPage: 1}
+				results, err := st.SearchNotes(ctx, NoteSearch{Query: word, ByRank: byRank}, p)
+				if err != nil || len(results) != 1 || p.NextCursor == 0 {
+					t.Fatal("the first page should have one note and a next cursor", err)
+				}
+				if !strings.Contains(results[0].Snippet, "<mark>") {
+					t.Logf("the snippet should have the match marked, got %s", results[0].Snippet)
+					t.Fail()
+				}
+				last := results[0]
+				p = &Pagination{Cursor: p.NextCursor, PerPage: 10}
+				results, err = st.SearchNotes(ctx, NoteSearch{Query: word, ByRank: byRank}, p)
+				if err != nil || len(results) != 1 || results[0].Event.Event.ID == last.Event.Event.ID {
+					t.Log("the second page should have the other note", byRank, err)
+					t.Fail()
+				} else if byRank && results[0].Rank > last.Rank || !byRank && results[0].Event.Event.CreatedAt > last.Event.Event.CreatedAt {
+					t.Log("the second page should come after the first one", byRank)
+					t.Fail()
+				}
+				if p.NextCursor != 0 {
+					t.Log("there is no page after the last one")
+					t.Fail()
+				}
+			}
+
+			if _, err := st.SearchNotes(ctx, NoteSearch{Query: word}, &Pagination{Cursor: 1 << 40, PerPage: 10}); err == nil {
+				t.Log("a cursor of a note we do not have should be an error")
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	terms := parseSearchQuery(`Nostr "quick  brown" fox* 'drop;--`)
+
+	if q := tsQuery(terms); q != `'nostr' & 'quick' <-> 'brown' & 'fox':* & 'drop'` {
+		t.Logf("unexpected tsquery %s", q)
+		t.Fail()
+	}
+	if q := ftsQuery(terms); q != `"nostr" AND "quick brown" AND "fox"* AND "drop"` {
+		t.Logf("unexpected fts query %s", q)
+		t.Fail()
+	}
+}
+
+func TestRetention(t *testing.T) {
+	st := testStores(t)[Sqlite]
+	ctx := context.Background()
+	st.DbConfig.Retention = 7
+	old := nostr.Now() - 30*24*60*60
+
+	save := func(sk string, content string, tags nostr.Tags, createdAt nostr.Timestamp) string {
+		ev := nostr.Event{Kind: nostr.KindTextNote, Content: content + " " + sk, Tags: tags, CreatedAt: createdAt}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.SaveEvents(ctx, []*Event{{Event: &ev}}); err != nil {
+			t.Fatal(err)
+		}
+		return ev.ID
+	}
+
+	self := nostr.GeneratePrivateKey()
+	st.Pubkey, _ = nostr.GetPublicKey(self)
+	followed := nostr.GeneratePrivateKey()
+	followedPubkey, _ := nostr.GetPublicKey(followed)
+	if err := st.CreateFollow(ctx, followedPubkey); err != nil {
+		t.Fatal(err)
+	}
+	other := nostr.GeneratePrivateKey()
+
+	own := save(self, "own", nostr.Tags{}, old)
+	fromFollowed := save(followed, "followed", nostr.Tags{}, old)
+	expired := save(other, "expired", nostr.Tags{}, old)
+	expiredReply := save(other, "expired reply", nostr.Tags{{"e", expired, "", "root"}}, old)
+	recent := save(other, "recent", nostr.Tags{}, nostr.Now())
+	bookmarked := save(other, "bookmarked", nostr.Tags{}, old)
+	if err := st.CreateBookMark(ctx, bookmarked); err != nil {
+		t.Fatal(err)
+	}
+	// We keep our own reply, so the note it replies to stays
+	repliedTo := save(other, "replied to", nostr.Tags{}, old)
+	save(self, "own reply", nostr.Tags{{"e", repliedTo, "", "root"}}, nostr.Now())
+
+	report, err := st.ApplyRetention(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Notes != 2 || report.Protected != 1 || report.Rules[3].Notes != 2 {
+		t.Logf("the dry run should find 2 expired notes and 1 protected, got %+v", report)
+		t.Fail()
+	}
+	if !st.HasNote(ctx, expired) {
+		t.Fatal("a dry run should not delete")
+	}
+
+	report, err = st.ApplyRetention(ctx, false)
+	if err != nil || report.Notes != 2 {
+		t.Fatalf("the cleanup should delete 2 notes, got %+v (%v)", report, err)
+	}
+	for _, id := range []string{expired, expiredReply} {
+		if st.HasNote(ctx, id) {
+			t.Log("the expired notes of others should be deleted")
+			t.Fail()
+		}
+	}
+	for _, id := range []string{own, fromFollowed, recent, bookmarked, repliedTo} {
+		if !st.HasNote(ctx, id) {
+			t.Log("our own, followed, recent, bookmarked and replied to notes should be kept")
+			t.Fail()
+		}
+	}
+	var trees int64
+	st.GormDB.Model(&Tree{}).Where("event_id = ?", expiredReply).Count(&trees)
+	if trees != 0 {
+		t.Log("the tree of a deleted reply should be deleted too")
+		t.Fail()
+	}
+
+	st.DbConfig.RetentionRules = []RetentionRule{{Author: "nobody", Days: 1}}
+	if _, err := st.ApplyRetention(ctx, true); err == nil {
+		t.Log("an unknown author in a rule should be an error")
+		t.Fail()
+	}
+}
+
+func TestSpamClusters(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			word := "zq" + newPubkey()[:12] // Only in the notes of this test
+			spam := []string{
+				"Claim your FREE bitcoin airdrop now, only today! " + word + " https://spam.example/a",
+				"claim your free bitcoin airdrop now only today " + word + " https://spam.example/b nostr:npub1xyz",
+				"Claim your free bitcoin airdrop now... only today!! " + word,
+				"CLAIM YOUR FREE BITCOIN AIRDROP NOW, ONLY TODAY " + word + " https://spam.example/c",
+			}
+
+			ids := make([]string, 0)
+			for _, content := range spam {
+				ev := signed(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, content, nostr.Tags{})
+				if _, err := st.SaveEvents(ctx, []*Event{ev}); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, ev.Event.ID)
+			}
+
+			cluster := func() *SpamCluster {
+				clusters, err := st.GetSpamClusters(ctx, false, 500)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, c := range clusters {
+					if strings.Contains(c.Sample, word) {
+						return &c
+					}
+				}
+				return nil
+			}
+			if c := cluster(); c == nil || c.Authors != 4 || c.Garbage {
+				t.Fatalf("4 authors should make a cluster that is not garbage yet, got %+v", c)
+			}
+
+			// The fifth author is one we follow, their note stays
+			followed := nostr.GeneratePrivateKey()
+			followedPubkey, _ := nostr.GetPublicKey(followed)
+			if err := st.CreateFollow(ctx, followedPubkey); err != nil {
+				t.Fatal(err)
+			}
+			ev := signed(t, followed, nostr.KindTextNote, "Claim your free bitcoin airdrop now, only today "+word, nostr.Tags{})
+			if _, err := st.SaveEvents(ctx, []*Event{ev}); err != nil {
+				t.Fatal(err)
+			}
+
+			if c := cluster(); c == nil || c.Authors != 5 || c.Notes != 5 || !c.Garbage || len(c.Fingerprint) != 16 {
+				t.Fatalf("5 authors should make the cluster garbage, got %+v", c)
+			}
+			var garbage int64
+			st.GormDB.Model(&Note{}).Where("event_id IN (?) AND garbage = ?", ids, true).Count(&garbage)
+			if garbage != 4 {
+				t.Logf("the notes of the 4 authors should be garbage, got %d", garbage)
+				t.Fail()
+			}
+			var note Note
+			st.GormDB.Where("event_id = ?", ev.Event.ID).First(&note)
+			if note.Garbage {
+				t.Log("the note of someone we follow should not be garbage")
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestSimhash(t *testing.T) {
+	a, _ := simhash("Claim your free bitcoin airdrop now, only today! Do not miss out on this")
+	b, _ := simhash("claim your free bitcoin airdrop now only today, do not miss out on this!! https://spam.example")
+	c, _ := simhash("I went for a walk in the forest this morning and saw three deer")
+	if hammingDistance(a, b) > spamDistance {
+		t.Logf("only links, case and punctuation differ, distance %d", hammingDistance(a, b))
+		t.Fail()
+	}
+	if hammingDistance(a, c) <= spamDistance {
+		t.Logf("other content should not be a near duplicate, distance %d", hammingDistance(a, c))
+		t.Fail()
+	}
+	if _, ok := simhash("gm nostr"); ok {
+		t.Log("short content should not get a fingerprint")
+		t.Fail()
+	}
+}
+
+func TestReadState(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			counts := func() UnreadCounts {
+				c, err := st.GetNewNotesCount(ctx, 0, Options{Follow: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return c
+			}
+			before := counts()
+
+			followed := nostr.GeneratePrivateKey()
+			followedPubkey, _ := nostr.GetPublicKey(followed)
+			if err := st.CreateFollow(ctx, followedPubkey); err != nil {
+				t.Fatal(err)
+			}
+			filler := signed(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, "filler "+name, nostr.Tags{})
+			first := signed(t, followed, nostr.KindTextNote, "first "+name, nostr.Tags{})
+			second := signed(t, followed, nostr.KindTextNote, "second "+name, nostr.Tags{})
+			if _, err := st.SaveEvents(ctx, []*Event{filler, first, second}); err != nil {
+				t.Fatal(err)
+			}
+			reply := signed(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, "reply "+name, nostr.Tags{
+				{"e", first.Event.ID, "", "root"},
+				{"p", st.Pubkey},
+			})
+			if _, err := st.SaveEvents(ctx, []*Event{reply}); err != nil {
+				t.Fatal(err)
+			}
+
+			after := counts()
+			if after.Follow != before.Follow+2 || after.Notifications != before.Notifications+1 {
+				t.Fatalf("2 followed notes and 1 notification should be unread, before %+v after %+v", before, after)
+			}
+
+			var firstNote Note
+			st.GormDB.Where("event_id = ?", first.Event.ID).First(&firstNote)
+			feed := func(unread bool) []Event {
+				p := Pagination{PerPage: 10, Cursor: uint64(firstNote.ID) - 1, Unread: unread}
+				events, err := st.GetNotes(ctx, "", &p, Options{Follow: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return *events
+			}
+			feed(false)
+			if c := counts(); c.Follow != after.Follow {
+				t.Log("loading a page should not mark the notes as read")
+				t.Fail()
+			}
+
+			if err := st.MarkRead(ctx, []string{first.Event.ID}); err != nil {
+				t.Fatal(err)
+			}
+			if c := counts(); c.Follow != after.Follow-1 {
+				t.Log("the marked note should be read")
+				t.Fail()
+			}
+			for _, ev := range feed(false) {
+				if ev.Read != (ev.Event.ID == first.Event.ID) {
+					t.Log("only the marked note should be read in the feed")
+					t.Fail()
+				}
+			}
+			if events := feed(true); len(events) != 1 || events[0].Event.ID != second.Event.ID {
+				t.Log("the unread feed should only have the second note")
+				t.Fail()
+			}
+
+			if err := st.MarkThreadRead(ctx, reply.Event.ID); err != nil {
+				t.Fatal(err)
+			}
+			if c := counts(); c.Notifications != before.Notifications {
+				t.Log("the notification of the reply should be seen with its thread")
+				t.Fail()
+			}
+
+			if err := st.MarkUnread(ctx, []string{first.Event.ID, reply.Event.ID}); err != nil {
+				t.Fatal(err)
+			}
+			if c := counts(); c.Follow != after.Follow || c.Notifications != after.Notifications {
+				t.Log("the notes and the notification should be unread again")
+				t.Fail()
+			}
+
+			var secondNote Note
+			st.GormDB.Where("event_id = ?", second.Event.ID).First(&secondNote)
+			if _, err := st.MarkReadUpTo(ctx, FeedFollow, uint64(secondNote.ID)); err != nil {
+				t.Fatal(err)
+			}
+			if c := counts(); c.Follow != 0 {
+				t.Logf("the whole followed feed should be read, got %d", c.Follow)
+				t.Fail()
+			}
+			if _, err := st.MarkReadUpTo(ctx, "nothing", 1); err == nil {
+				t.Log("an unknown feed should be an error")
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			own := nostr.GeneratePrivateKey()
+			st.Pubkey, _ = nostr.GetPublicKey(own)
+			alice, bob, carol, dave, erin := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+
+			note := signed(t, own, nostr.KindTextNote, "my note "+name, nostr.Tags{})
+			if _, err := st.SaveEvents(ctx, []*Event{note}); err != nil {
+				t.Fatal(err)
+			}
+
+			wallet := testWallet(t, st, own)
+			follows := nostr.Tags{{"p", st.Pubkey}}
+			events := []*Event{
+				signed(t, alice, nostr.KindTextNote, "a reply "+name, nostr.Tags{{"e", note.Event.ID, "", "root"}}),
+				signed(t, bob, nostr.KindTextNote, "a mention "+name, nostr.Tags{{"p", st.Pubkey}}),
+				signed(t, alice, nostr.KindReaction, "+", nostr.Tags{{"e", note.Event.ID}, {"p", st.Pubkey}}),
+				signed(t, bob, nostr.KindReaction, "🤙", nostr.Tags{{"e", note.Event.ID}, {"p", st.Pubkey}}),
+				signed(t, carol, nostr.KindRepost, "", nostr.Tags{{"e", note.Event.ID}, {"p", st.Pubkey}}),
+				testZap(t, wallet, dave, st.Pubkey, note.Event.ID, "lnbc210n", "21000"),
+				signed(t, erin, nostr.KindContactList, "", follows),
+				signed(t, own, nostr.KindReaction, "+", nostr.Tags{{"e", note.Event.ID}}),
+			}
+			if _, err := st.SaveEvents(ctx, events); err != nil {
+				t.Fatal(err)
+			}
+			newer := nostr.Event{Kind: nostr.KindContactList, CreatedAt: nostr.Now() + 1, Tags: append(follows, nostr.Tag{"p", newPubkey()})}
+			if err := newer.Sign(erin); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := st.SaveEvents(ctx, []*Event{{Event: &newer}}); err != nil {
+				t.Fatal(err)
+			}
+
+			groups, err := st.GetNotifications(ctx, NotificationPage{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			byType := make(map[string]NotificationGroup)
+			for _, group := range groups {
+				byType[group.Type] = group
+			}
+			if len(groups) != len(NotificationTypes) || len(byType) != len(NotificationTypes) {
+				t.Fatalf("every type should be one group, got %+v", groups)
+			}
+			if group := byType[NotificationReaction]; group.Count != 2 || group.TargetEventId != note.Event.ID || group.Target == nil {
+				t.Log("the two reactions of others should be grouped on the note")
+				t.Fail()
+			}
+			if group := byType[NotificationReply]; group.Target == nil || group.Notifications[0].Event == nil || group.Notifications[0].Profile == nil {
+				t.Log("a reply should have the note, the reply and who replied")
+				t.Fail()
+			}
+			if group := byType[NotificationMention]; group.TargetEventId != events[1].Event.ID {
+				t.Log("a mention should be about the mentioning note")
+				t.Fail()
+			}
+			zap := byType[NotificationZap].Notifications[0]
+			if davePubkey, _ := nostr.GetPublicKey(dave); zap.Amount != 21000 || zap.Content != "great" || zap.Pubkey != davePubkey {
+				t.Logf("the zap should be 21 sats from the zapper with the message, got %+v", zap)
+				t.Fail()
+			}
+			if group := byType[NotificationFollower]; group.Count != 1 || group.Notifications[0].Pubkey != events[6].Event.PubKey {
+				t.Log("a newer contact list should not notify again")
+				t.Fail()
+			}
+
+			first, _ := st.GetNotifications(ctx, NotificationPage{Limit: 4})
+			last := first[len(first)-1]
+			second, _ := st.GetNotifications(ctx, NotificationPage{Limit: 4, Before: last.Latest, BeforeId: last.LastId})
+			if len(first) != 4 || len(second) != 2 || second[0].Type == last.Type {
+				t.Log("the second page should start after the last group of the first")
+				t.Fail()
+			}
+
+			unseen, err := st.GetUnseenNotifications(ctx)
+			if err != nil || unseen.Total != 7 {
+				t.Fatalf("7 notifications should not be seen, got %+v %v", unseen, err)
+			}
+			marked, err := st.MarkNotificationsSeen(ctx, NotificationsSeen{Type: NotificationReaction, TargetEventId: note.Event.ID})
+			if err != nil || marked != 2 {
+				t.Fatalf("the reaction group should be marked as seen, got %d %v", marked, err)
+			}
+			groups, _ = st.GetNotifications(ctx, NotificationPage{Limit: 10, Unseen: true})
+			if len(groups) != len(NotificationTypes)-1 {
+				t.Log("the seen reactions should not be in the unseen notifications")
+				t.Fail()
+			}
+
+			if err := st.SaveNotificationSetting(ctx, NotificationZap, true); err != nil {
+				t.Fatal(err)
+			}
+			defer st.SaveNotificationSetting(ctx, NotificationZap, false)
+			unseen, _ = st.GetUnseenNotifications(ctx)
+			groups, _ = st.GetNotifications(ctx, NotificationPage{Limit: 10})
+			if unseen.Total != 4 || len(groups) != len(NotificationTypes)-1 {
+				t.Log("muted zaps should not be shown or counted")
+				t.Fail()
+			}
+			if err := st.SaveNotificationSetting(ctx, "nothing", true); err == nil {
+				t.Log("an unknown type should be an error")
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestBolt11Msats(t *testing.T) {
+	amounts := map[string]int64{"lnbc1pjtest": 0, "lnbc2500u1pjtest": 250_000_000, "lnbc20m1pjtest": 2_000_000_000, "lntb15n1pjtest": 1500, "lnbc1": 0, "lnbc9678785340p1pjtest": 967878534}
+	for invoice, msats := range amounts {
+		if got := bolt11Msats(invoice); got != msats {
+			t.Logf("%s should be %d msats, got %d", invoice, msats, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestCounters(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			author := nostr.GeneratePrivateKey()
+			note := signed(t, author, nostr.KindTextNote, "counted "+name, nostr.Tags{})
+			reply := signed(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, "a reply "+name, nostr.Tags{{"e", note.Event.ID, "", "root"}})
+			nested := signed(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, "a nested reply "+name, nostr.Tags{
+				{"e", note.Event.ID, "", "root"}, {"e", reply.Event.ID, "", "reply"},
+			})
+			like := signed(t, nostr.GeneratePrivateKey(), nostr.KindReaction, "+", nostr.Tags{{"e", note.Event.ID}})
+			authorPubkey, _ := nostr.GetPublicKey(author)
+			wallet := testWallet(t, st, author)
+			events := []*Event{
+				// The reaction comes before the note
+				like,
+				note, reply, nested,
+				signed(t, nostr.GeneratePrivateKey(), nostr.KindReaction, "-", nostr.Tags{{"e", note.Event.ID}}),
+				signed(t, nostr.GeneratePrivateKey(), nostr.KindReaction, "🤙", nostr.Tags{{"e", note.Event.ID}}),
+				// The last e tag is the note that is reacted on
+				signed(t, nostr.GeneratePrivateKey(), nostr.KindReaction, "+", nostr.Tags{{"e", note.Event.ID}, {"e", reply.Event.ID}}),
+				signed(t, nostr.GeneratePrivateKey(), nostr.KindRepost, "", nostr.Tags{{"e", note.Event.ID}}),
+				testZap(t, wallet, nostr.GeneratePrivateKey(), authorPubkey, note.Event.ID, "lnbc210n", "21000"),
+			}
+			if _, err := st.SaveEvents(ctx, events); err != nil {
+				t.Fatal(err)
+			}
+			// The same events from another relay
+			if _, err := st.SaveEvents(ctx, []*Event{like, reply}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := Counters{Likes: 1, Dislikes: 1, Emojis: 1, Replies: 1, Reposts: 1, Zaps: 1, ZapAmount: 21000}
+			tree, err := st.GetThread(ctx, note.Event.ID, ReplyPage{})
+			if err != nil || tree.Root == nil {
+				t.Fatalf("the thread should have the note, got %v", err)
+			}
+			if tree.Root.Counters != want {
+				t.Logf("the note should have %+v, got %+v", want, tree.Root.Counters)
+				t.Fail()
+			}
+			if len(tree.Root.Children) != 1 || tree.Root.Children[0].Counters != (Counters{Likes: 1, Replies: 1}) {
+				t.Log("the reply should have its own counters")
+				t.Fail()
+			}
+
+			// The replies we had before the counters are counted by the migration, the reactions table only has
+			// one reaction of a note
+			if name == Sqlite {
+				status, err := st.GetMigrationStatus()
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Back to before 000019, which made the counters
+				if err := st.MigrateDown(int(status.Version) - 18); err != nil {
+					t.Fatal(err)
+				}
+				if err := st.MigrateUp(); err != nil {
+					t.Fatal(err)
+				}
+				events, err := st.getEvents(ctx, []string{note.Event.ID, reply.Event.ID})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := events[note.Event.ID].Counters; got.Replies != 1 || got.Likes+got.Dislikes+got.Emojis != 1 {
+					t.Logf("the migration should count the reply and the reaction, got %+v", got)
+					t.Fail()
+				}
+				if got := events[reply.Event.ID].Counters; got != (Counters{Likes: 1, Replies: 1}) {
+					t.Logf("the migration should count the reply like the events that came in, got %+v", got)
+					t.Fail()
+				}
+			}
+		})
+	}
+}
+
+func TestZapReceipts(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			author := nostr.GeneratePrivateKey()
+			authorPubkey, _ := nostr.GetPublicKey(author)
+			note := signed(t, author, nostr.KindTextNote, "zapped "+name, nostr.Tags{})
+			if _, err := st.SaveEvents(ctx, []*Event{note}); err != nil {
+				t.Fatal(err)
+			}
+			wallet := testWallet(t, st, author)
+			zapper := nostr.GeneratePrivateKey()
+
+			// An invoice for another zap request than the one in the receipt
+			other := testZap(t, wallet, nostr.GeneratePrivateKey(), authorPubkey, note.Event.ID, "lnbc1u", "100000")
+			swapped := testZap(t, wallet, zapper, authorPubkey, note.Event.ID, "lnbc1u", "100000")
+			swapped.Event.Tags = nostr.Tags{{"p", authorPubkey}, {"e", note.Event.ID}, other.Event.Tags[2], swapped.Event.Tags[3]}
+			if err := swapped.Event.Sign(wallet); err != nil {
+				t.Fatal(err)
+			}
+
+			invalid := map[string]*Event{
+				"not the wallet":          testZap(t, nostr.GeneratePrivateKey(), zapper, authorPubkey, note.Event.ID, "lnbc1u", "100000"),
+				"another amount":          testZap(t, wallet, zapper, authorPubkey, note.Event.ID, "lnbc1u", "5000"),
+				"another zap request":     swapped,
+				"no lightning address":    testZap(t, wallet, zapper, newPubkey(), note.Event.ID, "lnbc1u", "100000"),
+				"a receipt without a zap": signed(t, wallet, nostr.KindZap, "", nostr.Tags{{"p", authorPubkey}, {"e", note.Event.ID}, {"bolt11", "lnbc210n1pjtest"}}),
+			}
+			for reason, zap := range invalid {
+				saved, err := st.SaveEvents(ctx, []*Event{zap})
+				if err != nil || len(saved) != 0 {
+					t.Logf("a zap with %s should not be counted, got %v %v", reason, saved, err)
+					t.Fail()
+				}
+			}
+
+			if saved, err := st.SaveEvents(ctx, []*Event{testZap(t, wallet, zapper, authorPubkey, note.Event.ID, "lnbc1u", "100000")}); err != nil || len(saved) != 1 {
+				t.Fatalf("a valid zap should be counted, got %v %v", saved, err)
+			}
+			events, err := st.getEvents(ctx, []string{note.Event.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := events[note.Event.ID].Counters; got != (Counters{Zaps: 1, ZapAmount: 100000}) {
+				t.Logf("only the valid zap should be counted, got %+v", got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestZapLookupsShareOneDeadline(t *testing.T) {
+	defer func(timeout time.Duration) { zapLookupTimeout = timeout }(zapLookupTimeout)
+	zapLookupTimeout = 200 * time.Millisecond
+
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			author := nostr.GeneratePrivateKey()
+			authorPubkey, _ := nostr.GetPublicKey(author)
+			note := signed(t, author, nostr.KindTextNote, "slow wallet "+name, nostr.Tags{})
+			if _, err := st.SaveEvents(ctx, []*Event{note}); err != nil {
+				t.Fatal(err)
+			}
+			wallet := testWallet(t, st, author)
+
+			// A wallet that never answers
+			st.Zappers = func(ctx context.Context, address string) (string, error) {
+				<-ctx.Done()
+				return "", ctx.Err()
+			}
+			var zaps []*Event
+			for i := 0; i < 5; i++ {
+				zaps = append(zaps, testZap(t, wallet, nostr.GeneratePrivateKey(), authorPubkey, note.Event.ID, "lnbc1u", "100000"))
+			}
+
+			start := time.Now()
+			saved, err := st.SaveEvents(ctx, zaps)
+			if err != nil || len(saved) != 0 {
+				t.Logf("zaps that could not be checked should not be counted, got %v %v", saved, err)
+				t.Fail()
+			}
+			if took := time.Since(start); took > 4*zapLookupTimeout {
+				t.Logf("the lookups should share one deadline, the batch took %s", took)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestGetNotesOnAnEmptyFeed(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			p := Pagination{PerPage: 10}
+			feed, err := st.GetNotes(context.Background(), "", &p, Options{Follow: true})
+			if err != nil || len(*feed) != 0 || p.Cursor != 0 {
+				t.Logf("a feed without notes should be empty and start at 0, got %v cursor %d", err, p.Cursor)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestBolt11DescriptionHash(t *testing.T) {
+	// The example with a description hash of BOLT 11
+	invoice := "lnbc20m1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqhp58yjmdan79s6qqdhdzgynm4zwqd5d7xmw5fk98klysy043l2ahrqscc6gd6ql3jrc5yzme8v4ntcewwz5cnw92tz0pc8qcuufvq7khhr8wpald05e92xw006sq94mg8v2ndf4sefvf9sygkshp5zfem29trqq2yxxz7"
+	if hash, err := bolt11DescriptionHash(invoice); err != nil || hash != "3925b6f67e2c340036ed12093dd44e0368df1b6ea26c53dbe4811f58fd5db8c1" {
+		t.Logf("unexpected description hash %s %v", hash, err)
+		t.Fail()
+	}
+	if _, err := bolt11DescriptionHash("lnbc210n1pjtest"); err == nil {
+		t.Log("an invalid invoice should be an error")
+		t.Fail()
+	}
+}
+
+func TestSyncCursors(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			key := "test-" + newPubkey()[:8]
+			relayUrl := "wss://relay.example.com"
+
+			if err := st.SaveSyncCursors(ctx, key, map[string]SyncCursor{relayUrl: {Since: 300, GapSince: 100, GapUntil: 200}}); err != nil {
+				t.Fatal(err)
+			}
+			if got := st.GetSyncCursors(ctx, key)[relayUrl]; got != (SyncCursor{Since: 300, GapSince: 100, GapUntil: 200}) {
+				t.Logf("the cursor should have its gap, got %+v", got)
+				t.Fail()
+			}
+
+			if err := st.SaveSyncCursors(ctx, key, map[string]SyncCursor{relayUrl: {Since: 400}}); err != nil {
+				t.Fatal(err)
+			}
+			if got := st.GetSyncCursors(ctx, key)[relayUrl]; got != (SyncCursor{Since: 400}) {
+				t.Logf("a closed gap should be gone, got %+v", got)
+				t.Fail()
+			}
+		})
+	}
+}
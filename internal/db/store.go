@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+/**
+ * Everything the api, the syncer and the background tasks need from the database.
+ * Storage implements it for postgres and sqlite, so they do not have to know which one is used.
+ */
+type Store interface {
+	// Notes and the feeds
+	SaveEvents(ctx context.Context, evs []*Event) ([]string, error)
+	SaveNote(ctx context.Context, event *Event) (Note, error)
+	GetNotes(ctx context.Context, context string, p *Pagination, options Options) (*[]Event, error)
+	GetInbox(ctx context.Context, context string, p *Pagination, pubkey string) (*[]Event, error)
+	GetNotifications(ctx context.Context, page NotificationPage) ([]NotificationGroup, error)
+	MarkNotificationsSeen(ctx context.Context, which NotificationsSeen) (int64, error)
+	GetUnseenNotifications(ctx context.Context) (UnseenNotifications, error)
+	GetNotificationSettings(ctx context.Context) ([]NotificationSetting, error)
+	SaveNotificationSetting(ctx context.Context, notificationType string, muted bool) error
+	GetNewNotesCount(ctx context.Context, cursor uint64, options Options) (UnreadCounts, error)
+	MarkRead(ctx context.Context, eventIds []string) error
+	MarkUnread(ctx context.Context, eventIds []string) error
+	MarkReadUpTo(ctx context.Context, feed string, upTo uint64) (int64, error)
+	MarkThreadRead(ctx context.Context, eventId string) error
+	GetLastSeenID(ctx context.Context) (int, error)
+	FindEvent(ctx context.Context, id string) (Event, error)
+	FindRawEvent(ctx context.Context, id string) (*Event, error)
+	GetRawNotes(ctx context.Context, ids []string) ([]*nostr.Event, error)
+	GetOwnNoteIds(ctx context.Context, since int64, limit int) []string
+	GetNegentropyItems(ctx context.Context, authors []string, kinds []int, since int64) ([]NegentropyItem, error)
+	SearchNotes(ctx context.Context, search NoteSearch, p *Pagination) ([]SearchResult, error)
+
+	// Threads
+	GetThreadRoot(ctx context.Context, eventId string) string
+	GetThread(ctx context.Context, eventId string, page ReplyPage) (ReplyTree, error)
+	HasNote(ctx context.Context, eventId string) bool
+
+	// Profiles and authors
+	SaveProfile(ctx context.Context, ev *Event) error
+	SaveProfiles(ctx context.Context, evs []*Event) error
+	FindProfile(ctx context.Context, pubkey string) (Profile, error)
+	SearchProfiles(ctx context.Context, searchStr string) (*[]Profile, error)
+	GetMissingProfiles(ctx context.Context, pubkeys []string) []string
+	GetStaleProfiles(ctx context.Context, fetchedBefore time.Time, seenSince int64, limit int) ([]string, error)
+	MarkProfilesFetched(ctx context.Context, pubkeys []string) error
+	GetAuthor(ctx context.Context, pubkey string) (Author, error)
+	GetAuthorNotes(ctx context.Context, pubkey string, until int64, untilId string, limit int) ([]Event, error)
+	HasAuthor(ctx context.Context, pubkey string) bool
+
+	// Follows, blocks and bookmarks
+	CreateFollow(ctx context.Context, pubkey string) error
+	RemoveFollow(ctx context.Context, pubkey string) error
+	GetFollows(ctx context.Context) []string
+	GetFollowedProfiles(ctx context.Context) []Profile
+	CreateBlock(ctx context.Context, pubkey string) error
+	CreateBookMark(ctx context.Context, eventID string) error
+	RemoveBookMark(ctx context.Context, eventID string) error
+
+	// Moderation
+	SaveReport(ctx context.Context, ev *nostr.Event, own bool) error
+	GetSpamClusters(ctx context.Context, garbageOnly bool, limit int) ([]SpamCluster, error)
+	GetModeration(ctx context.Context) ([]ModerationEntry, error)
+
+	// Relays
+	GetRelays(ctx context.Context) []Relay
+	CreateRelay(ctx context.Context, relay *Relay) error
+	RemoveRelay(ctx context.Context, url string) error
+	GetRelayHealth(ctx context.Context) []RelayHealth
+	SaveRelayHealth(ctx context.Context, health []RelayHealth) error
+	SaveEventRelays(ctx context.Context, evs []*Event) error
+	GetEventRelays(ctx context.Context, eventId string) ([]EventRelay, error)
+	GetRelayHints(ctx context.Context, eventId string, limit int) []string
+	GetOwnNotesMissingFromRelay(ctx context.Context, relayUrl string, limit int) ([]Note, error)
+
+	// Syncing
+	GetSyncCursors(ctx context.Context, filterKey string) map[string]SyncCursor
+	SaveSyncCursors(ctx context.Context, filterKey string, cursors map[string]SyncCursor) error
+	CreateBackfill(ctx context.Context, pubkey string, restart bool) error
+	NextBackfill(ctx context.Context) (*Backfill, error)
+	SaveBackfill(ctx context.Context, backfill *Backfill) error
+	ResumeBackfills(ctx context.Context) error
+	GetBackfills(ctx context.Context) ([]Backfill, error)
+	AddMissingEvent(ctx context.Context, eventId string, referencedBy *Event) error
+	GetDueMissingEvents(ctx context.Context, limit int, maxAttempts int) ([]MissingEvent, error)
+	ResolveMissingEvents(ctx context.Context, eventIds []string) error
+	MissingEventsNotFound(ctx context.Context, missing []MissingEvent) error
+
+	// Retention
+	RetentionRules() []RetentionRule
+	ApplyRetention(ctx context.Context, dryRun bool) (RetentionReport, error)
+
+	// Outbox
+	CreateOutbox(ctx context.Context, ev *nostr.Event, relayUrls []string) error
+	GetOutbox(ctx context.Context, statuses []DeliveryStatus, limit int) ([]OutboxEntry, error)
+	GetOutboxEvent(ctx context.Context, eventId string) (*nostr.Event, error)
+	GetDeliveries(ctx context.Context, eventId string) ([]OutboxDelivery, error)
+	GetDueDeliveries(ctx context.Context, limit int) ([]OutboxDelivery, error)
+	SaveDelivery(ctx context.Context, delivery *OutboxDelivery) error
+	ResetDeliveries(ctx context.Context, eventId string, relayUrls []string) error
+}
+
+var _ Store = (*Storage)(nil)
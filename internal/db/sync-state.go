@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+/**
+ * Until when a relay is synced for a filter. When a sync is cut off we keep the newest events and the cursor
+ * moves on, the older ones between GapSince and GapUntil are fetched by the next syncs until the gap is closed.
+ */
+type SyncCursor struct {
+	Since    int64
+	GapSince int64
+	GapUntil int64 // 0 when there is no gap
+}
+
+/**
+ * Get the sync cursor of every relay for a filter. Relays we never synced with are not in the map.
+ */
+func (st *Storage) GetSyncCursors(ctx context.Context, filterKey string) map[string]SyncCursor {
+	var states []RelaySyncState
+	st.GormDB.WithContext(ctx).Model(&RelaySyncState{}).Where("filter_key = ?", filterKey).Find(&states)
+
+	cursors := make(map[string]SyncCursor, len(states))
+	for _, state := range states {
+		cursors[state.RelayUrl] = SyncCursor{Since: state.Since, GapSince: state.GapSince, GapUntil: state.GapUntil}
+	}
+	return cursors
+}
+
+/**
+ * Store the new sync cursors. Only call this after the events are saved, otherwise we skip them next time.
+ */
+func (st *Storage) SaveSyncCursors(ctx context.Context, filterKey string, cursors map[string]SyncCursor) error {
+	if len(cursors) == 0 {
+		return nil
+	}
+
+	states := make([]RelaySyncState, 0, len(cursors))
+	for relayUrl, cursor := range cursors {
+		states = append(states, RelaySyncState{
+			RelayUrl:  relayUrl,
+			FilterKey: filterKey,
+			Since:     cursor.Since,
+			GapSince:  cursor.GapSince,
+			GapUntil:  cursor.GapUntil,
+			UpdatedAt: time.Now(),
+		})
+	}
+
+	return st.GormDB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "relay_url"}, {Name: "filter_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"since", "gap_since", "gap_until", "updated_at"}),
+	}).Create(&states).Error
+}
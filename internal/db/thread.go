@@ -0,0 +1,344 @@
+package db
+
+import (
+	"context"
+)
+
+// Like the notes_and_profiles view, but also with the replies
+const noteRowsQuery = noteRowsColumns + `
+	FROM notes` + noteRowsJoins
+
+const noteRowsColumns = `SELECT notes.id, notes.uid as note_uuid, notes.event_id, notes.pubkey, notes.kind, notes.event_created_at,
+	notes.content, notes.tags_full, notes.sig, notes.etags, notes.ptags,
+	profiles.uid as profile_uuid, profiles.name, profiles.about , profiles.picture,
+	profiles.website, profiles.nip05, profiles.lud16, profiles.display_name,
+	CASE WHEN length(follows.pubkey) > 0 THEN TRUE ELSE FALSE END followed,
+	CASE WHEN length(bookmarks.event_id) > 0 THEN TRUE ELSE FALSE END bookmarked`
+
+const noteRowsJoins = `
+	LEFT JOIN profiles ON (profiles.pubkey = notes.pubkey)
+	LEFT JOIN blocks ON (blocks.pubkey = notes.pubkey)
+	LEFT JOIN bookmarks ON (bookmarks.note_id = notes.id)
+	LEFT JOIN follows ON (follows.pubkey = notes.pubkey)
+	WHERE notes.kind = 1 AND notes.garbage = false AND blocks.pubkey IS NULL`
+
+/**
+ * The root of the thread the note is in. A note we know nothing about is its own root.
+ */
+func (st *Storage) GetThreadRoot(ctx context.Context, eventId string) string {
+	var roots []string
+	st.GormDB.WithContext(ctx).Model(&Tree{}).Where("event_id = ?", eventId).Limit(1).Pluck("root_event_id", &roots)
+	if len(roots) == 0 || roots[0] == "" {
+		return eventId
+	}
+	return roots[0]
+}
+
+func (st *Storage) HasNote(ctx context.Context, eventId string) bool {
+	var count int64
+	st.GormDB.WithContext(ctx).Model(&Note{}).Where("event_id = ?", eventId).Count(&count)
+
+	return count > 0
+}
+
+/**
+ * Every note under the notes, at any depth. A reply is found by its root tag and by the note it replies to,
+ * so also replies without a root tag are found. node_id is the note it is under.
+ */
+const replyTreeQuery = `WITH RECURSIVE thread (event_id, parent_id, node_id) AS (
+	SELECT CAST(trees.event_id AS text),
+		CAST(CASE WHEN trees.reply_event_id = '' THEN trees.root_event_id ELSE trees.reply_event_id END AS text),
+		CAST(CASE WHEN trees.reply_event_id IN (?) THEN trees.reply_event_id ELSE trees.root_event_id END AS text)
+	FROM trees
+	WHERE trees.root_event_id IN (?) OR trees.reply_event_id IN (?)
+	UNION
+	SELECT CAST(trees.event_id AS text), CAST(trees.reply_event_id AS text), thread.node_id
+	FROM trees
+	JOIN thread ON (trees.reply_event_id = thread.event_id)
+	)
+	SELECT thread.event_id, thread.parent_id, thread.node_id, notes.event_created_at
+	FROM thread
+	JOIN notes ON (notes.event_id = thread.event_id)
+	LEFT JOIN blocks ON (blocks.pubkey = notes.pubkey)
+	WHERE notes.kind = 1 AND notes.garbage = false AND blocks.pubkey IS NULL
+	ORDER BY notes.event_created_at ASC, thread.event_id ASC`
+
+/**
+ * Which replies to show of a note. PerLevel limits the replies under every note, 0 shows them all.
+ * The cursor is the created_at and event id of the last reply shown under the note itself.
+ */
+type ReplyPage struct {
+	PerLevel int
+	After    int64
+	AfterId  string
+}
+
+/**
+ * A note with the replies under it. Orphans are replies of which we do not have the note they reply to,
+ * MissingIds are those notes.
+ */
+type ReplyTree struct {
+	Root       *Event   `json:"root"`
+	Orphans    []*Event `json:"orphans"`
+	MissingIds []string `json:"missing_ids"`
+}
+
+type replyRow struct {
+	EventId        string
+	ParentId       string
+	NodeId         string
+	EventCreatedAt int64
+}
+
+// The replies of a note that are shown and the counts of all of them
+type replyShape struct {
+	children map[string][]string
+	replies  map[string]int64
+	total    map[string]int64
+	orphans  []string
+	missing  []string
+}
+
+/**
+ * Hang every reply under the one it replies to, oldest first. Replies of which the parent is not under the
+ * note are orphans. The counts are of all replies, also when a page does not show them all.
+ */
+func shapeReplies(nodeId string, rows []replyRow, page ReplyPage) replyShape {
+	shape := replyShape{
+		children: make(map[string][]string),
+		replies:  make(map[string]int64),
+		total:    make(map[string]int64),
+		orphans:  make([]string, 0),
+		missing:  make([]string, 0),
+	}
+
+	visible := make(map[string]bool, len(rows))
+	createdAt := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		if row.EventId != nodeId {
+			visible[row.EventId] = true
+			createdAt[row.EventId] = row.EventCreatedAt
+		}
+	}
+
+	all := make(map[string][]string)
+	placed := make(map[string]bool, len(rows))
+	missing := make(map[string]bool)
+	for _, row := range rows {
+		if row.EventId == nodeId || placed[row.EventId] {
+			continue
+		}
+		placed[row.EventId] = true
+		if row.ParentId == nodeId || (visible[row.ParentId] && row.ParentId != row.EventId) {
+			all[row.ParentId] = append(all[row.ParentId], row.EventId)
+			continue
+		}
+		shape.orphans = append(shape.orphans, row.EventId)
+		if !visible[row.ParentId] && !missing[row.ParentId] {
+			missing[row.ParentId] = true
+			shape.missing = append(shape.missing, row.ParentId)
+		}
+	}
+
+	var count func(id string) int64
+	count = func(id string) int64 {
+		if total, ok := shape.total[id]; ok {
+			return total
+		}
+		var total int64
+		for _, reply := range all[id] {
+			total += 1 + count(reply)
+		}
+		shape.total[id] = total
+		return total
+	}
+
+	var show func(id string, first bool)
+	show = func(id string, first bool) {
+		replies := all[id]
+		shape.replies[id] = int64(len(replies))
+		count(id)
+		if first && page.AfterId != "" {
+			next := make([]string, 0, len(replies))
+			for _, reply := range replies {
+				if createdAt[reply] > page.After || (createdAt[reply] == page.After && reply > page.AfterId) {
+					next = append(next, reply)
+				}
+			}
+			replies = next
+		}
+		if page.PerLevel > 0 && len(replies) > page.PerLevel {
+			replies = replies[:page.PerLevel]
+		}
+		shape.children[id] = replies
+		for _, reply := range replies {
+			show(reply, false)
+		}
+	}
+
+	show(nodeId, true)
+	// The orphans are only on the first page
+	if page.AfterId != "" {
+		shape.orphans = shape.orphans[:0]
+	}
+	for _, orphan := range shape.orphans {
+		show(orphan, false)
+	}
+
+	return shape
+}
+
+// The notes that are shown, the note itself first
+func (shape replyShape) ids(nodeId string) []string {
+	ids := append([]string{nodeId}, shape.orphans...)
+	for _, replies := range shape.children {
+		ids = append(ids, replies...)
+	}
+	return ids
+}
+
+// The note with the replies that are shown under it, nil when we do not have the note
+func (shape replyShape) build(id string, events map[string]Event, level int64) *Event {
+	ev, ok := events[id]
+	if !ok {
+		return nil
+	}
+	ev.Tree = level
+	ev.Replies = shape.replies[id]
+	ev.AllReplies = shape.total[id]
+	ev.Children = make([]*Event, 0, len(shape.children[id]))
+	for _, reply := range shape.children[id] {
+		if child := shape.build(reply, events, level+1); child != nil {
+			ev.Children = append(ev.Children, child)
+		}
+	}
+	return &ev
+}
+
+func (st *Storage) getReplyShapes(ctx context.Context, nodeIds []string, page ReplyPage) (map[string]replyShape, error) {
+	var rows []replyRow
+	if err := st.GormDB.WithContext(ctx).Raw(replyTreeQuery, nodeIds, nodeIds, nodeIds).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	byNode := make(map[string][]replyRow, len(nodeIds))
+	for _, row := range rows {
+		byNode[row.NodeId] = append(byNode[row.NodeId], row)
+	}
+	shapes := make(map[string]replyShape, len(nodeIds))
+	for _, nodeId := range nodeIds {
+		shapes[nodeId] = shapeReplies(nodeId, byNode[nodeId], page)
+	}
+	return shapes, nil
+}
+
+func (st *Storage) getEvents(ctx context.Context, ids []string) (map[string]Event, error) {
+	var rows []NotesAndProfiles
+	if err := st.GormDB.WithContext(ctx).Raw(noteRowsQuery+` AND notes.event_id IN (?)`, ids).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	events, _, _, err := st.procesEventRows(&rows)
+	if err != nil {
+		return nil, err
+	}
+	return events, st.addCounters(ctx, events)
+}
+
+/**
+ * A note and the replies under it we have, without garbage and blocked users. The note does not have to
+ * be the root, so the replies of any note in a thread can be paged.
+ */
+func (st *Storage) GetThread(ctx context.Context, eventId string, page ReplyPage) (ReplyTree, error) {
+	shapes, err := st.getReplyShapes(ctx, []string{eventId}, page)
+	if err != nil {
+		return ReplyTree{}, err
+	}
+	shape := shapes[eventId]
+
+	events, err := st.getEvents(ctx, shape.ids(eventId))
+	if err != nil {
+		return ReplyTree{}, err
+	}
+
+	tree := ReplyTree{Root: shape.build(eventId, events, 1), Orphans: make([]*Event, 0), MissingIds: shape.missing}
+	orphans := shape.orphans
+	if tree.Root == nil {
+		// Without the note its replies are orphans too
+		orphans = append(shape.children[eventId], orphans...)
+	}
+	for _, id := range orphans {
+		if orphan := shape.build(id, events, 2); orphan != nil {
+			orphan.Orphan = true
+			tree.Orphans = append(tree.Orphans, orphan)
+		}
+	}
+	return tree, nil
+}
+
+/**
+ * The replies of the notes of a feed. The orphans are put under the note after its own replies.
+ */
+func (st *Storage) addReplies(ctx context.Context, eventMap map[string]Event) error {
+	if len(eventMap) == 0 {
+		return nil
+	}
+	nodeIds := make([]string, 0, len(eventMap))
+	for id := range eventMap {
+		nodeIds = append(nodeIds, id)
+	}
+
+	shapes, err := st.getReplyShapes(ctx, nodeIds, ReplyPage{})
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0)
+	for nodeId, shape := range shapes {
+		ids = append(ids, shape.ids(nodeId)[1:]...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	events, err := st.getEvents(ctx, ids)
+	if err != nil {
+		return err
+	}
+	// The notes themselves are already loaded
+	for id, ev := range eventMap {
+		events[id] = ev
+	}
+
+	for nodeId, shape := range shapes {
+		ev := shape.build(nodeId, events, 1)
+		for _, id := range shape.orphans {
+			if orphan := shape.build(id, events, 2); orphan != nil {
+				orphan.Orphan = true
+				ev.Children = append(ev.Children, orphan)
+			}
+		}
+		eventMap[nodeId] = *ev
+	}
+	return nil
+}
+
+// The pubkeys we have no profile of
+func (st *Storage) GetMissingProfiles(ctx context.Context, pubkeys []string) []string {
+	if len(pubkeys) == 0 {
+		return nil
+	}
+
+	var known []string
+	st.GormDB.WithContext(ctx).Model(&Profile{}).Where("pubkey IN (?)", pubkeys).Pluck("pubkey", &known)
+
+	have := make(map[string]bool, len(known))
+	for _, pubkey := range known {
+		have[pubkey] = true
+	}
+	missing := make([]string, 0)
+	for _, pubkey := range pubkeys {
+		if !have[pubkey] {
+			have[pubkey] = true
+			missing = append(missing, pubkey)
+		}
+	}
+	return missing
+}
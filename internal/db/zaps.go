@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+/**
+ * Gives the nostrPubkey of the LNURL server of a lightning address (LUD-16), the key that signs the zap
+ * receipts of that address.
+ */
+type ZapperLookup func(ctx context.Context, lud16 string) (string, error)
+
+/**
+ * The lookups of the zappers in one batch of events share this time. A wallet that does not answer only costs
+ * the sync this once, the zaps we could not check in time are not counted.
+ */
+var zapLookupTimeout = 10 * time.Second
+
+// A zap receipt that passed the checks of NIP-57
+type zapReceipt struct {
+	Request       nostr.Event // The zap request of the zapper, with the message
+	Amount        int64       // In millisats
+	Recipient     string
+	TargetEventId string
+}
+
+/**
+ * Check a kind 9735 zap receipt before we count it (NIP-57 appendix F). Anyone can publish a receipt, so the zap
+ * request in the description must be signed, the invoice must be for that request and have its amount and the
+ * receipt must be made by the LNURL server of the lightning address of the one who is zapped. Without the profile
+ * of the recipient we cannot know that server and the zap is not counted.
+ */
+func (st *Storage) checkZap(ctx context.Context, ev *nostr.Event) (zapReceipt, error) {
+	zap := zapReceipt{}
+	p := ev.Tags.GetFirst([]string{"p"})
+	description := ev.Tags.GetFirst([]string{"description"})
+	bolt11 := ev.Tags.GetFirst([]string{"bolt11"})
+	if p == nil || description == nil || bolt11 == nil {
+		return zap, fmt.Errorf("zap %s needs a p, description and bolt11 tag", ev.ID)
+	}
+	zap.Recipient = p.Value()
+	if e := ev.Tags.GetLast([]string{"e"}); e != nil {
+		zap.TargetEventId = e.Value()
+	}
+
+	if err := json.Unmarshal([]byte(description.Value()), &zap.Request); err != nil {
+		return zap, fmt.Errorf("zap %s has no valid zap request: %w", ev.ID, err)
+	}
+	if zap.Request.Kind != nostr.KindZapRequest {
+		return zap, fmt.Errorf("zap %s has a kind %d as zap request", ev.ID, zap.Request.Kind)
+	}
+	if ok, err := zap.Request.CheckSignature(); !ok {
+		return zap, fmt.Errorf("zap %s has a zap request with an invalid signature: %v", ev.ID, err)
+	}
+	if requested := zap.Request.Tags.GetFirst([]string{"p"}); requested == nil || requested.Value() != zap.Recipient {
+		return zap, fmt.Errorf("zap %s is for another pubkey than its zap request", ev.ID)
+	}
+
+	hash, err := bolt11DescriptionHash(bolt11.Value())
+	if err != nil {
+		return zap, fmt.Errorf("zap %s: %w", ev.ID, err)
+	}
+	if sum := sha256.Sum256([]byte(description.Value())); hash != hex.EncodeToString(sum[:]) {
+		return zap, fmt.Errorf("zap %s has an invoice for another zap request", ev.ID)
+	}
+
+	zap.Amount = bolt11Msats(bolt11.Value())
+	if zap.Amount == 0 {
+		return zap, fmt.Errorf("zap %s has an invoice without an amount", ev.ID)
+	}
+	if requested := zap.Request.Tags.GetFirst([]string{"amount"}); requested != nil && requested.Value() != strconv.FormatInt(zap.Amount, 10) {
+		return zap, fmt.Errorf("zap %s is for %d msats, the zap request asked %s", ev.ID, zap.Amount, requested.Value())
+	}
+
+	zapper, err := st.zapperPubkey(ctx, zap.Recipient)
+	if err != nil {
+		return zap, fmt.Errorf("zap %s: %w", ev.ID, err)
+	}
+	if ev.PubKey != zapper {
+		return zap, fmt.Errorf("zap %s is not made by the wallet of %s", ev.ID, zap.Recipient)
+	}
+	return zap, nil
+}
+
+// The key of the LNURL server of the lightning address in the profile of the pubkey
+func (st *Storage) zapperPubkey(ctx context.Context, pubkey string) (string, error) {
+	if st.Zappers == nil {
+		return "", errors.New("there is no lookup for the zappers")
+	}
+
+	var lud16 []string
+	if err := st.GormDB.WithContext(ctx).Model(&Profile{}).Where("pubkey = ?", pubkey).Pluck("lud16", &lud16).Error; err != nil {
+		return "", err
+	}
+	if len(lud16) == 0 || lud16[0] == "" {
+		return "", fmt.Errorf("no lightning address for %s", pubkey)
+	}
+	return st.Zappers(ctx, lud16[0])
+}
+
+/**
+ * The description hash (the h field) of a bolt11 invoice in hex. The data of the invoice is a timestamp of 7
+ * groups of 5 bits, then the tagged fields and at the end 104 groups for the signature. A tagged field is its
+ * type, a length of 2 groups and the data.
+ */
+func bolt11DescriptionHash(invoice string) (string, error) {
+	_, data, err := bech32.DecodeNoLimit(strings.ToLower(invoice))
+	if err != nil {
+		return "", fmt.Errorf("invalid invoice: %w", err)
+	}
+	if len(data) < 7+104 {
+		return "", errors.New("invalid invoice: too short")
+	}
+
+	fields := data[7 : len(data)-104]
+	for len(fields) >= 3 {
+		fieldType, length := fields[0], int(fields[1])<<5|int(fields[2])
+		fields = fields[3:]
+		if length > len(fields) {
+			return "", errors.New("invalid invoice: field is too long")
+		}
+		// h is 23 in bech32, the 256 bits of the hash are 52 groups
+		if fieldType == 23 && length == 52 {
+			hash, err := bech32.ConvertBits(fields[:length], 5, 8, false)
+			if err != nil {
+				return "", fmt.Errorf("invalid invoice: %w", err)
+			}
+			return hex.EncodeToString(hash[:32]), nil
+		}
+		fields = fields[length:]
+	}
+	return "", errors.New("the invoice has no description hash")
+}
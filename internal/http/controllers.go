@@ -4,6 +4,10 @@ import (
 	"amavis442/nostr-reader/internal/db"
 	"amavis442/nostr-reader/internal/logger"
 	wrapper "amavis442/nostr-reader/internal/nostr"
+	"amavis442/nostr-reader/internal/outbox"
+	"amavis442/nostr-reader/internal/syncer"
+	"amavis442/nostr-reader/internal/tag"
+	"amavis442/nostr-reader/internal/thread"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -15,6 +19,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
@@ -22,8 +27,11 @@ import (
 
 type Controller struct {
 	Pubkey string
-	Db     *db.Storage
+	Db     db.Store
 	Nostr  *wrapper.Wrapper
+	Sync   *syncer.Manager
+	Outbox *outbox.Outbox
+	Thread *thread.Fetcher
 }
 
 /**
@@ -46,13 +54,19 @@ type Url struct {
 	Url string `json:"url"`
 }
 
+type ReportRequest struct {
+	Pubkey  string `json:"pubkey"`
+	EventId string `json:"event_id"` // Optional, only when a note is reported
+	Type    string `json:"type" enums:"nudity,malware,profanity,illegal,spam,impersonation,other"`
+	Reason  string `json:"reason"`
+}
+
 /**
  * Not all events are processed at once and we do not want to miss out on events, so put them in a queque and use FIFO to process.
  */
 //var EventsQueue = make([]nostr.Event, 0)
 
 // var ptagsQueue = make([]string, 0)
-var syncHash string = ""
 
 // Send from client API
 type PageRequest struct {
@@ -62,9 +76,21 @@ type PageRequest struct {
 	PerPage    uint   `json:"per_page"`
 	Since      uint   `json:"since"`
 	Renew      bool   `json:"renew"`
+	Unread     bool   `json:"unread"`
 	Context    string `json:"context"`
 }
 
+// The notes to mark as read or not read
+type ReadRequest struct {
+	EventIds []string `json:"event_ids"`
+}
+
+// Mark the notes of a feed as read up to and including the note with that id
+type ReadUpToRequest struct {
+	Context string `json:"context" enums:"follow,global,bookmark,notifications,inbox"`
+	Id      uint64 `json:"id"`
+}
+
 // Response godoc
 // @Description  Standard response to return to client
 type Response struct {
@@ -112,6 +138,8 @@ func (c *Controller) parseUrlParams(r *http.Request) PageRequest {
 		p.Renew = false
 	}
 
+	p.Unread, _ = strconv.ParseBool(r.URL.Query().Get("unread"))
+
 	p.Context = r.URL.Query().Get("context")
 
 	since := r.URL.Query().Get("since")
@@ -136,6 +164,7 @@ func (c *Controller) parseUrlParams(r *http.Request) PageRequest {
 // @Param		 per_page	query	int	false	"Results per page"	Default(10)
 // @Param		 renew		query	bool	false	"Renew page and ignore start_id" Default(false)
 // @Param		 since		query	int	false	"Since"
+// @Param		 unread		query	bool	false	"Only the notes that are not read" Default(false)
 // @Param		context	query string false "string enum" Enums(follow, bookmark, refresh, global)
 // @Success      200  {object}  Response
 // @Failure      400  {string}  string    "error"
@@ -157,6 +186,7 @@ func (c *Controller) GetNotes() http.HandlerFunc {
 		pagination.SetNext(p.NextCursor)
 		pagination.SetPerPage(p.PerPage)
 		pagination.SetSince(p.Since)
+		pagination.Unread = p.Unread
 
 		var options db.Options
 		switch p.Context {
@@ -233,6 +263,7 @@ func (c *Controller) GetInbox() http.HandlerFunc {
 		pagination.SetNext(p.NextCursor)
 		pagination.SetPerPage(p.PerPage)
 		pagination.SetSince(p.Since)
+		pagination.Unread = p.Unread
 
 		events, err := c.Db.GetInbox(ctx, p.Context, &pagination, c.Pubkey)
 
@@ -249,127 +280,376 @@ func (c *Controller) GetInbox() http.HandlerFunc {
 	}
 }
 
+// GetNotifications godoc
+// @Summary      Notifications
+// @Description  Mentions, replies, reactions, reposts, zaps and new followers, grouped per type and note with the newest group first. Every group has its newest notifications. Muted types are left out. Use next_cursor as cursor for the next page
+// @Tags         notifications
+// @Accept       json
+// @Produce      json
+// @Param		 type	query	string	false	"Type, can be given more than once" Enums(mention, reply, reaction, repost, zap, follower)
+// @Param		 unseen	query	bool	false	"Only the notifications that are not seen"
+// @Param		 cursor	query	string	false	"next_cursor of the previous page"
+// @Param		 per_page	query	int	false	"Groups per page, default 20"
+// @Success      200  {object}  Response
+// @Failure      400  {object}  Response
+// @Failure      500  {object}  Response
+// @Router       /api/getnotifications [get]
 func (c *Controller) GetNotifications() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		defer r.Body.Close()
-		ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 		defer cancel()
 
-		p := c.parseUrlParams(r)
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Notifications"
 
-		pagination := db.Pagination{}
-		pagination.SetPerPage(uint(p.PerPage))
-		pagination.SetCursor(p.Cursor)
-		pagination.SetPrev(p.PrevCursor)
-		pagination.SetNext(p.NextCursor)
-		pagination.SetPerPage(p.PerPage)
-		pagination.SetSince(p.Since)
+		query := r.URL.Query()
+		page := db.NotificationPage{Types: query["type"], Unseen: query.Get("unseen") == "true"}
+
+		var err error
+		for _, t := range page.Types {
+			if !db.IsNotificationType(t) {
+				err = fmt.Errorf("unknown notification type %s", t)
+			}
+		}
+		if err == nil {
+			page.Before, page.BeforeId, err = parseNotificationCursor(query.Get("cursor"))
+		}
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
 
-		events, err := c.Db.GetNotifications(ctx, &pagination)
+		page.Limit, _ = strconv.Atoi(query.Get("per_page"))
+		if page.Limit < 1 || page.Limit > 100 {
+			page.Limit = 20
+		}
 
-		data := &ResponseEventData{Paging: &pagination, Events: events}
-		response := &Response{}
-		response.Status = "ok"
-		response.Message = "Pagination results"
+		groups, err := c.Db.GetNotifications(ctx, page)
 		if err != nil {
-			response.Status = "failed"
+			response.Status = "error"
 			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
 		}
-		response.Data = data
+
+		nextCursor := ""
+		if len(groups) == page.Limit {
+			last := groups[len(groups)-1]
+			nextCursor = fmt.Sprintf("%d:%d", last.Latest, last.LastId)
+		}
+
+		type Page struct {
+			Groups     []db.NotificationGroup `json:"groups"`
+			NextCursor string                 `json:"next_cursor"`
+		}
+		response.Data = Page{Groups: groups, NextCursor: nextCursor}
+
 		render.JSON(w, r, response)
 	}
 }
 
-func (c *Controller) StartSync() http.HandlerFunc {
+// MarkNotificationsSeen godoc
+// @Summary      Mark notifications as seen
+// @Description  Mark the notifications with the ids, the group of the type and target_event_id or all of them as seen. Returns how many were marked
+// @Tags         notifications
+// @Accept       json
+// @Produce      json
+// @Param        Body body db.NotificationsSeen true "Which notifications"
+// @Success      200  {object}  Response
+// @Failure      400  {object}  Response
+// @Router       /api/notifications/seen [post]
+func (c *Controller) MarkNotificationsSeen() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+		defer r.Body.Close()
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*") // for CORS
-		w.WriteHeader(http.StatusOK)
-
 		response := &Response{}
 		response.Status = "ok"
-		syncHash = fmt.Sprint(time.Now().Unix())
-		response.Message = syncHash
+		response.Message = "Marked as seen"
 
-		//EventsQueue = EventsQueue[:0]
-		createdAt := c.Db.GetLastTimeStamp(ctx)
+		var j db.NotificationsSeen
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+			response.Status = "error"
+			response.Message = "ids, type or all is required"
+			render.JSON(w, r, response)
+			return
+		}
 
-		filter := c.Nostr.GetEventData(createdAt, true)
-		evs := c.Nostr.GetEvents(ctx, filter)
-		var pubkeys = make([]string, 0)
-		var err error
-		pubkeys, err = c.Db.SaveEvents(ctx, evs)
+		marked, err := c.Db.MarkNotificationsSeen(ctx, j)
+		response.Data = marked
 		if err != nil {
 			response.Status = "error"
 			response.Message = err.Error()
 		}
 
-		// Todo build check for ttl so user data is not refreshed every time.
-		var tresholdTime int64 = time.Now().Unix() - 60*60*24
+		render.JSON(w, r, response)
+	}
+}
+
+// GetUnseenNotifications godoc
+// @Summary      Number of notifications not seen
+// @Description  The total and the number per type of the notifications that are not seen, without the muted types
+// @Tags         notifications
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Failure      500  {object}  Response
+// @Router       /api/notifications/unseen [get]
+func (c *Controller) GetUnseenNotifications() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Unseen notifications"
 
-		pubkeys, _ = c.Db.CheckProfiles(ctx, pubkeys, tresholdTime)
-		// Last but not least, try to get the user metadata
-		c.Nostr.UpdateProfiles(ctx, pubkeys)
-		err = c.Db.SaveProfiles(ctx, evs)
+		unseen, err := c.Db.GetUnseenNotifications(ctx)
+		response.Data = unseen
 		if err != nil {
 			response.Status = "error"
 			response.Message = err.Error()
 		}
 
-		err = json.NewEncoder(w).Encode(response)
+		render.JSON(w, r, response)
+	}
+}
+
+// GetNotificationSettings godoc
+// @Summary      Notification settings
+// @Description  Every type of notification and if it is muted
+// @Tags         notifications
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Failure      500  {object}  Response
+// @Router       /api/notifications/settings [get]
+func (c *Controller) GetNotificationSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Notification settings"
+
+		settings, err := c.Db.GetNotificationSettings(ctx)
+		response.Data = settings
 		if err != nil {
-			panic(err)
+			response.Status = "error"
+			response.Message = err.Error()
 		}
+
+		render.JSON(w, r, response)
 	}
 }
 
-func (c *Controller) SyncNote() http.HandlerFunc {
+// SaveNotificationSetting godoc
+// @Summary      Mute a type of notification
+// @Description  Muted notifications are still stored, but they are not shown and not counted
+// @Tags         notifications
+// @Accept       json
+// @Produce      json
+// @Param        Body body db.NotificationSetting true "Type and if it is muted"
+// @Success      200  {object}  Response
+// @Failure      400  {object}  Response
+// @Router       /api/notifications/settings [post]
+func (c *Controller) SaveNotificationSetting() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
 
-		type Request struct {
-			ID string
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Notification setting saved"
+
+		var j db.NotificationSetting
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil || j.Type == "" {
+			response.Status = "error"
+			response.Message = "type is required"
+			render.JSON(w, r, response)
+			return
 		}
-		var j Request
-		err := json.NewDecoder(r.Body).Decode(&j)
+
+		err := c.Db.SaveNotificationSetting(ctx, j.Type, j.Muted)
+		response.Data = j
 		if err != nil {
-			panic(err)
+			response.Status = "error"
+			response.Message = err.Error()
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*") // for CORS
-		w.WriteHeader(http.StatusOK)
+		render.JSON(w, r, response)
+	}
+}
+
+// StartSync godoc
+// @Summary      Start a sync
+// @Description  Get the new events from the relays. Only one sync runs at a time, while one runs the status is error
+// @Tags         sync
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Router       /api/sync [post]
+func (c *Controller) StartSync() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Sync started"
 
-		log.Println("Sync event with Id: ", j.ID)
-		var tagMap nostr.TagMap = make(nostr.TagMap, 0)
-		tagMap["e"] = []string{j.ID}
-		filter := nostr.Filter{
-			Tags:  tagMap,
-			Limit: 1,
+		run, err := c.Sync.Start(r.Context(), syncer.TriggerApi)
+		response.Data = run
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
 		}
 
-		evs := c.Nostr.GetEvents(ctx, filter)
+		render.JSON(w, r, response)
+	}
+}
 
-		c.Db.SaveEvents(ctx, evs)
-		ev, _ := c.Db.FindEvent(ctx, j.ID)
+// GetSyncStatus godoc
+// @Summary      Status of the sync
+// @Description  The running sync or else the last one, with the events per relay and per kind
+// @Tags         sync
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Router       /api/sync/status [get]
+func (c *Controller) GetSyncStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Sync status"
+		response.Data = c.Sync.Status()
+
+		render.JSON(w, r, response)
+	}
+}
 
-		log.Println("Need to get it", j.ID, filter)
+// GetSyncHistory godoc
+// @Summary      Sync history
+// @Description  The last finished syncs, newest first
+// @Tags         sync
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Router       /api/sync/history [get]
+func (c *Controller) GetSyncHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Sync history"
+		response.Data = c.Sync.History()
 
-		syncHash = fmt.Sprint(time.Now().Unix())
+		render.JSON(w, r, response)
+	}
+}
 
+// GetThread godoc
+// @Summary      Get the full thread of a note
+// @Description  Gets the root, the replies and the profiles of the thread from the relays, stores them and returns the tree, oldest replies first. Missing tells if notes of the thread could not be found
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param		 id	path	string	true	"Event id of a note in the thread"
+// @Param		 per_level	query	int	false	"At most this many replies under every note, all when not given"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/thread/{id} [get]
+func (c *Controller) GetThread() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		response := &Response{}
 		response.Status = "ok"
-		response.Message = syncHash
-		response.Data = ev
-		err = json.NewEncoder(w).Encode(response)
+		response.Message = "Thread"
+
+		id := chi.URLParam(r, "id")
+		page, err := parseReplyPage(r)
+		if err == nil && !nostr.IsValid32ByteHex(id) {
+			err = fmt.Errorf("id must be an event id")
+		}
 		if err != nil {
-			panic(err)
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		thread, err := c.Thread.Fetch(r.Context(), id, page)
+		response.Data = thread
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// GetReplies godoc
+// @Summary      Page through the replies of a note
+// @Description  The replies we have under any note of a thread, oldest first. Replies and all_replies of a note tell how many there are, use the created_at and event id of the last reply shown as cursor for the next page
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param		 id	path	string	true	"Event id of the note"
+// @Param		 per_level	query	int	false	"At most this many replies under every note, all when not given"
+// @Param		 cursor	query	string	false	"created_at:event_id of the last reply shown under the note"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/thread/{id}/replies [get]
+func (c *Controller) GetReplies() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Replies"
+
+		id := chi.URLParam(r, "id")
+		page, err := parseReplyPage(r)
+		if err == nil {
+			page.After, page.AfterId, err = parseNoteCursor(r.URL.Query().Get("cursor"))
+		}
+		if err == nil && !nostr.IsValid32ByteHex(id) {
+			err = fmt.Errorf("id must be an event id")
+		}
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		tree, err := c.Db.GetThread(ctx, id, page)
+		response.Data = tree
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// per_level is optional, without it all the replies are shown
+func parseReplyPage(r *http.Request) (db.ReplyPage, error) {
+	page := db.ReplyPage{}
+	if perLevel := r.URL.Query().Get("per_level"); perLevel != "" {
+		n, err := strconv.Atoi(perLevel)
+		if err != nil || n < 1 {
+			return page, fmt.Errorf("per_level should be a number above 0")
 		}
+		page.PerLevel = n
 	}
+	return page, nil
 }
 
 // BlockUser godoc
@@ -773,6 +1053,16 @@ func (c *Controller) GetRelays() http.HandlerFunc {
 		response.Status = "ok"
 		response.Message = "Relays"
 		relays := c.Db.GetRelays(ctx)
+
+		health := make(map[string]db.RelayHealth)
+		for _, h := range c.Nostr.GetRelayHealth() {
+			health[h.Url] = h
+		}
+		for i := range relays {
+			if h, ok := health[relays[i].Url]; ok {
+				relays[i].Health = &h
+			}
+		}
 		response.Data = relays
 
 		err := json.NewEncoder(w).Encode(&response)
@@ -784,7 +1074,7 @@ func (c *Controller) GetRelays() http.HandlerFunc {
 
 // GetNewNotesCount godoc
 // @Summary      Get count of new notes
-// @Description  Get count of new notes
+// @Description  Get count of new notes after the cursor in the feed of the context and the number of unread notes of every feed
 // @Tags         notes
 // @Accept       json
 // @Produce      json
@@ -837,12 +1127,11 @@ func (c *Controller) GetNewNotesCount() http.HandlerFunc {
 
 		count, err := c.Db.GetNewNotesCount(ctx, p.Cursor, options)
 
-		response.Data = fmt.Sprintf("%d", count)
+		response.Data = count
 
 		if err != nil {
 			response.Status = "error"
 			response.Message = err.Error()
-			response.Data = "0"
 		}
 
 		err = json.NewEncoder(w).Encode(&response)
@@ -852,41 +1141,197 @@ func (c *Controller) GetNewNotesCount() http.HandlerFunc {
 	}
 }
 
-// GetLastSeenID godoc
-// @Summary      Last seen note id
-// @Description  Last seen note id
+// MarkRead godoc
+// @Summary      Mark notes as read
+// @Description  Mark the notes as read, their notifications are seen too
 // @Tags         notes
 // @Accept       json
 // @Produce      json
+// @Param        Body body ReadRequest true "Event ids"
 // @Success      200  {object}  Response
-// @Failure      400  {string}  string    "error"
-// @Failure      404  {string}  string    "error"
+// @Failure      400  {object}  Response
 // @Failure      500  {string}  string    "error"
-// @Router       /api/getlastseenid [get]
-func (c *Controller) GetLastSeenID() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
-		defer cancel()
+// @Router       /api/read [post]
+func (c *Controller) MarkRead() http.HandlerFunc {
+	return c.markRead(true)
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*") // for CORS
-		w.WriteHeader(http.StatusOK)
+// MarkUnread godoc
+// @Summary      Mark notes as not read
+// @Description  Mark the notes as not read, their notifications are new again
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        Body body ReadRequest true "Event ids"
+// @Success      200  {object}  Response
+// @Failure      400  {object}  Response
+// @Failure      500  {string}  string    "error"
+// @Router       /api/unread [post]
+func (c *Controller) MarkUnread() http.HandlerFunc {
+	return c.markRead(false)
+}
 
-		maxid, err := c.Db.GetLastSeenID(ctx)
+func (c *Controller) markRead(read bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
 
 		response := &Response{}
 		response.Status = "ok"
-		response.Message = "new notes count"
-		response.Data = maxid
+		response.Message = "Marked as read"
+		if !read {
+			response.Message = "Marked as not read"
+		}
+
+		var j ReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil || len(j.EventIds) == 0 {
+			response.Status = "error"
+			response.Message = "event_ids is required"
+			render.JSON(w, r, response)
+			return
+		}
 
+		var err error
+		if read {
+			err = c.Db.MarkRead(ctx, j.EventIds)
+		} else {
+			err = c.Db.MarkUnread(ctx, j.EventIds)
+		}
+		response.Data = j.EventIds
 		if err != nil {
 			response.Status = "error"
 			response.Message = err.Error()
-			response.Data = 0
 		}
-		err = json.NewEncoder(w).Encode(response)
-		if err != nil {
-			panic(err)
+
+		render.JSON(w, r, response)
+	}
+}
+
+// MarkReadUpTo godoc
+// @Summary      Mark a feed as read
+// @Description  Mark every note of the feed up to and including the note with the id as read, the id is one of the cursors of the feed. Returns the number of notes that were marked
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        Body body ReadUpToRequest true "Feed and note id"
+// @Success      200  {object}  Response
+// @Failure      400  {object}  Response
+// @Router       /api/read/upto [post]
+func (c *Controller) MarkReadUpTo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Marked as read"
+
+		var j ReadUpToRequest
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil || j.Id == 0 {
+			response.Status = "error"
+			response.Message = "context and id are required"
+			render.JSON(w, r, response)
+			return
+		}
+
+		marked, err := c.Db.MarkReadUpTo(ctx, feedOf(j.Context), j.Id)
+		response.Data = marked
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// MarkThreadRead godoc
+// @Summary      Mark a thread as read
+// @Description  Mark the root of the thread of the note and every reply under it as read
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        Body body BookMark true "Event id of a note in the thread"
+// @Success      200  {object}  Response
+// @Failure      400  {object}  Response
+// @Failure      500  {string}  string    "error"
+// @Router       /api/read/thread [post]
+func (c *Controller) MarkThreadRead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Thread marked as read"
+
+		var j BookMark
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil || j.EventId == "" {
+			response.Status = "error"
+			response.Message = "event_id is required"
+			render.JSON(w, r, response)
+			return
+		}
+
+		response.Data = j.EventId
+		if err := c.Db.MarkThreadRead(ctx, j.EventId); err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// The feed of a context, refresh is the same feed
+func feedOf(context string) string {
+	switch context {
+	case "", "refresh":
+		return db.FeedFollow
+	case "refresh.global":
+		return db.FeedGlobal
+	}
+	return context
+}
+
+// GetLastSeenID godoc
+// @Summary      Last seen note id
+// @Description  Last seen note id
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/getlastseenid [get]
+func (c *Controller) GetLastSeenID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*") // for CORS
+		w.WriteHeader(http.StatusOK)
+
+		maxid, err := c.Db.GetLastSeenID(ctx)
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "new notes count"
+		response.Data = maxid
+
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			response.Data = 0
+		}
+		err = json.NewEncoder(w).Encode(response)
+		if err != nil {
+			panic(err)
 		}
 	}
 }
@@ -1011,6 +1456,7 @@ func (c *Controller) Publish() http.HandlerFunc {
 			if err != nil {
 				slog.Warn(logger.GetCallerInfo(1)+" Something went wrong", "error", err.Error())
 			}
+			replyEv.Urls = c.Db.GetRelayHints(ctx, msg.Event_id, 1)
 			postEv, err = c.Nostr.DoReply(msg.Msg, *replyEv)
 			if err != nil {
 				slog.Warn("Something went wrong creating post for broadcasting: ", "error", err.Error())
@@ -1018,26 +1464,30 @@ func (c *Controller) Publish() http.HandlerFunc {
 
 		}
 
+		if postEv.Event == nil {
+			render.JSON(w, r, Response{Status: "error", Message: "cannot create the note"})
+			return
+		}
+
 		var wg sync.WaitGroup
 		wg.Add(1)
+		// SaveNote cleans up the content in place, the outbox has to publish what we signed
+		noteEv := *postEv.Event
 		go func(wg *sync.WaitGroup, c *Controller, postEv *db.Event) {
-			c.Db.SaveNote(ctx, postEv)
-			if err != nil {
+			if _, err := c.Db.SaveNote(ctx, postEv); err != nil {
 				slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
 			}
 			wg.Done()
-		}(&wg, c, &postEv)
+		}(&wg, c, &db.Event{Event: &noteEv, Urls: postEv.Urls})
 
 		wg.Add(1)
 
 		go func(ctx context.Context, c *Controller, postEv *db.Event) {
-			success, err := c.Nostr.BroadCast(ctx, *postEv)
+			// When no relay takes it now, the outbox tries again later
+			_, err := c.Outbox.Publish(ctx, *postEv)
 			if err != nil {
 				slog.Warn(logger.GetCallerInfo(1)+"cannot broadcast", "error", err.Error())
 			}
-			if !success {
-				slog.Warn(logger.GetCallerInfo(1) + "cannot broadcast succesfully")
-			}
 			wg.Done()
 		}(ctx, c, &postEv)
 
@@ -1135,7 +1585,10 @@ func (c *Controller) SetMetaData() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 
 		user.Pubkey = c.Pubkey
-		err = c.Nostr.DoPublishMetaData(ctx, &user)
+		ev, err := c.Nostr.DoMetaData(&user)
+		if err == nil {
+			_, err = c.Outbox.Publish(ctx, ev)
+		}
 
 		response := &Response{}
 		response.Status = "ok"
@@ -1249,3 +1702,698 @@ func (c *Controller) SearchProfiles() http.HandlerFunc {
 		render.JSON(w, r, response)
 	}
 }
+
+// SearchNotes godoc
+// @Summary      Search the notes we have
+// @Description  Full-text search over the content of the notes. All words must match, use "a phrase" for words in that order and word* for a prefix. Use paging.next_cursor as cursor for the next page
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param		 q	query	string	true	"Search query"
+// @Param		 author	query	string	false	"Pubkey or npub, can be given more than once"
+// @Param		 hashtag	query	string	false	"Hashtag, can be given more than once"
+// @Param		 since	query	int	false	"Notes created at or after this unix time"
+// @Param		 until	query	int	false	"Notes created at or before this unix time"
+// @Param		 sort	query	string	false	"string enum" Enums(new, rank)
+// @Param		 cursor	query	int	false	"paging.next_cursor of the previous page"
+// @Param		 per_page	query	int	false	"Notes per page, default 20"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/search/notes [get]
+func (c *Controller) SearchNotes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Search notes"
+
+		query := r.URL.Query()
+		search := db.NoteSearch{
+			Query:    query.Get("q"),
+			Hashtags: query["hashtag"],
+			ByRank:   query.Get("sort") == "rank",
+		}
+
+		var err error
+		for _, author := range query["author"] {
+			var pubkey string
+			if pubkey, err = decodePubkey(author); err != nil {
+				break
+			}
+			search.Authors = append(search.Authors, pubkey)
+		}
+		if err == nil && query.Get("since") != "" {
+			search.Since, err = strconv.ParseInt(query.Get("since"), 10, 64)
+		}
+		if err == nil && query.Get("until") != "" {
+			search.Until, err = strconv.ParseInt(query.Get("until"), 10, 64)
+		}
+		if err == nil && strings.TrimSpace(search.Query) == "" {
+			err = fmt.Errorf("q is empty")
+		}
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		p := c.parseUrlParams(r)
+		if p.PerPage < 1 || p.PerPage > 100 {
+			p.PerPage = 20
+		}
+		pagination := db.Pagination{}
+		pagination.SetCursor(p.Cursor)
+		pagination.SetPerPage(p.PerPage)
+
+		results, err := c.Db.SearchNotes(ctx, search, &pagination)
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		type Page struct {
+			Paging *db.Pagination    `json:"paging"`
+			Notes  []db.SearchResult `json:"notes"`
+		}
+		response.Data = Page{Paging: &pagination, Notes: results}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// Report godoc
+// @Summary      Report a note or pubkey
+// @Description  Publish a report (NIP-56) about a note or pubkey to the relays
+// @Tags         moderation
+// @Accept       json
+// @Produce      json
+// @Param        Body body ReportRequest true "Body for the report"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/report [post]
+func (c *Controller) Report() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Report"
+
+		var j ReportRequest
+		err := json.NewDecoder(r.Body).Decode(&j)
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		if strings.HasPrefix(j.Pubkey, "npub") {
+			_, value, err := nip19.Decode(j.Pubkey)
+			if err != nil {
+				response.Status = "error"
+				response.Message = err.Error()
+				render.JSON(w, r, response)
+				return
+			}
+			j.Pubkey = value.(string)
+		}
+
+		if !tag.IsReportType(j.Type) {
+			response.Status = "error"
+			response.Message = "unknown report type: " + j.Type
+			render.JSON(w, r, response)
+			return
+		}
+
+		ev, err := c.Nostr.DoReport(j.Pubkey, j.EventId, j.Type, j.Reason)
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		err = c.Db.SaveReport(ctx, ev.Event, true)
+		if err != nil {
+			slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+		}
+
+		_, err = c.Outbox.Publish(ctx, ev)
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		response.Data = ev.Event
+		render.JSON(w, r, response)
+	}
+}
+
+// GetModeration godoc
+// @Summary      Reported notes and pubkeys
+// @Description  Notes and pubkeys reported by you or the people you follow, with the reasons and if they are hidden
+// @Tags         moderation
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/getmoderation [get]
+func (c *Controller) GetModeration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Moderation"
+
+		entries, err := c.Db.GetModeration(ctx)
+		response.Data = entries
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// GetSpamClusters godoc
+// @Summary      Near-duplicate notes
+// @Description  Groups of almost the same notes posted by different authors, the last seen first. A cluster is garbage when enough authors posted it within the spam window, its notes are then hidden
+// @Tags         moderation
+// @Accept       json
+// @Produce      json
+// @Param		 all	query	bool	false	"Also the clusters that are not garbage"
+// @Param		 limit	query	int	false	"Number of clusters, default 100"
+// @Success      200  {object}  Response
+// @Failure      500  {string}  string    "error"
+// @Router       /api/spam/clusters [get]
+func (c *Controller) GetSpamClusters() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit < 1 || limit > 500 {
+			limit = 100
+		}
+		all := r.URL.Query().Get("all") == "true"
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Spam clusters"
+
+		clusters, err := c.Db.GetSpamClusters(ctx, !all, limit)
+		response.Data = clusters
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// Backfill godoc
+// @Summary      Get the history of an author
+// @Description  Queue getting the older notes of a pubkey from the relays. A finished backfill starts again.
+// @Tags         sync
+// @Accept       json
+// @Produce      json
+// @Param        Body body Pubkey true "Body for the retrieval of data"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/backfill [post]
+func (c *Controller) Backfill() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+
+		var user Pubkey
+		err := json.NewDecoder(r.Body).Decode(&user)
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		if strings.HasPrefix(user.Pubkey, "npub") {
+			_, value, err := nip19.Decode(user.Pubkey)
+			if err != nil {
+				response.Status = "error"
+				response.Message = err.Error()
+				render.JSON(w, r, response)
+				return
+			}
+			user.Pubkey = value.(string)
+		}
+
+		response.Message = "Backfill queued for pubkey: " + user.Pubkey
+		response.Data = user.Pubkey
+
+		err = c.Db.CreateBackfill(ctx, user.Pubkey, true)
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// GetBackfills godoc
+// @Summary      Progress of the backfills
+// @Description  Progress of getting the history of authors
+// @Tags         sync
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/getbackfills [get]
+func (c *Controller) GetBackfills() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Backfills"
+
+		backfills, err := c.Db.GetBackfills(ctx)
+		response.Data = backfills
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// GetRetention godoc
+// @Summary      What a cleanup would delete
+// @Description  A dry run of the retention rules: how many notes every rule would delete, the expired notes that are kept because a note we keep replies to them and the rows of notes that are already gone
+// @Tags         retention
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Failure      500  {string}  string    "error"
+// @Router       /api/retention [get]
+func (c *Controller) GetRetention() http.HandlerFunc {
+	return c.retention(true)
+}
+
+// ApplyRetention godoc
+// @Summary      Clean the database now
+// @Description  Delete the notes the retention rules do not keep any longer and vacuum the database, returns what was deleted
+// @Tags         retention
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  Response
+// @Failure      500  {string}  string    "error"
+// @Router       /api/retention [post]
+func (c *Controller) ApplyRetention() http.HandlerFunc {
+	return c.retention(false)
+}
+
+func (c *Controller) retention(dryRun bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Retention"
+
+		report, err := c.Db.ApplyRetention(ctx, dryRun)
+		response.Data = report
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// GetOutbox godoc
+// @Summary      Deliveries of your events
+// @Description  Deliveries to the relays of the events you published, by default the pending and failed ones
+// @Tags         publish
+// @Accept       json
+// @Produce      json
+// @Param		 status	query	string	false	"pending, failed or delivered, comma separated"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/outbox [get]
+func (c *Controller) GetOutbox() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		statuses := []db.DeliveryStatus{db.DeliveryPending, db.DeliveryFailed}
+		if r.URL.Query().Get("status") != "" {
+			statuses = statuses[:0]
+			for _, status := range strings.Split(r.URL.Query().Get("status"), ",") {
+				statuses = append(statuses, db.DeliveryStatus(strings.TrimSpace(status)))
+			}
+		}
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Outbox"
+
+		entries, err := c.Db.GetOutbox(ctx, statuses, 500)
+		response.Data = entries
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// Rebroadcast godoc
+// @Summary      Send an event again
+// @Description  Send one of your events again to the relays that did not accept it yet
+// @Tags         publish
+// @Accept       json
+// @Produce      json
+// @Param        Body body BookMark true "Event id"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/outbox/rebroadcast [post]
+func (c *Controller) Rebroadcast() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Rebroadcast"
+
+		var j BookMark
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil || j.EventId == "" {
+			response.Status = "error"
+			response.Message = "event_id is required"
+			render.JSON(w, r, response)
+			return
+		}
+
+		deliveries, err := c.Outbox.Rebroadcast(ctx, j.EventId)
+		response.Data = deliveries
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// GetEventRelays godoc
+// @Summary      Relays of an event
+// @Description  The relays we saw the event on, with when we saw it first and last
+// @Tags         relay
+// @Accept       json
+// @Produce      json
+// @Param		 event_id	query	string	true	"Event id"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/geteventrelays [get]
+func (c *Controller) GetEventRelays() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Event relays"
+
+		eventId := r.URL.Query().Get("event_id")
+		if eventId == "" {
+			response.Status = "error"
+			response.Message = "event_id is required"
+			render.JSON(w, r, response)
+			return
+		}
+
+		relays, err := c.Db.GetEventRelays(ctx, eventId)
+		response.Data = relays
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// GetMissingFromRelay godoc
+// @Summary      Your notes missing from a relay
+// @Description  Your notes we never saw on the relay
+// @Tags         relay
+// @Accept       json
+// @Produce      json
+// @Param		 relay	query	string	true	"Relay url"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/getmissingfromrelay [get]
+func (c *Controller) GetMissingFromRelay() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Missing from relay"
+
+		relayUrl := r.URL.Query().Get("relay")
+		if relayUrl == "" {
+			response.Status = "error"
+			response.Message = "relay is required"
+			render.JSON(w, r, response)
+			return
+		}
+
+		notes, err := c.Db.GetOwnNotesMissingFromRelay(ctx, relayUrl, 500)
+		response.Data = notes
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// Accepts a hex pubkey or an npub
+func decodePubkey(key string) (string, error) {
+	if strings.HasPrefix(key, "npub") {
+		prefix, value, err := nip19.Decode(key)
+		if err != nil {
+			return "", err
+		}
+		if prefix == "npub" {
+			key = value.(string)
+		}
+	}
+	if !nostr.IsValid32ByteHex(key) {
+		return "", fmt.Errorf("invalid pubkey: %s", key)
+	}
+	return key, nil
+}
+
+// A notes cursor is the created_at and the event id of the last note of the page
+func parseNoteCursor(cursor string) (int64, string, error) {
+	if cursor == "" {
+		return 0, "", nil
+	}
+	createdAt, eventId, ok := strings.Cut(cursor, ":")
+	until, err := strconv.ParseInt(createdAt, 10, 64)
+	if !ok || err != nil || !nostr.IsValid32ByteHex(eventId) {
+		return 0, "", fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	return until, eventId, nil
+}
+
+// A notifications cursor is the latest created_at and the last id of the last group of the page
+func parseNotificationCursor(cursor string) (int64, uint, error) {
+	if cursor == "" {
+		return 0, 0, nil
+	}
+	latest, lastId, ok := strings.Cut(cursor, ":")
+	before, err := strconv.ParseInt(latest, 10, 64)
+	beforeId, idErr := strconv.ParseUint(lastId, 10, 64)
+	if !ok || err != nil || idErr != nil || beforeId == 0 {
+		return 0, 0, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	return before, uint(beforeId), nil
+}
+
+// GetAuthor godoc
+// @Summary      Profile page of an author
+// @Description  The profile, if you follow or block the author, the number of notes and replies we have and the followers in the kind 3 lists we know
+// @Tags         profile
+// @Accept       json
+// @Produce      json
+// @Param		 pubkey	path	string	true	"Pubkey or npub"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/profile/{pubkey} [get]
+func (c *Controller) GetAuthor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Author"
+
+		pubkey, err := decodePubkey(chi.URLParam(r, "pubkey"))
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		// Someone we never saw, get the profile and follow list from the relays
+		if !c.Db.HasAuthor(ctx, pubkey) {
+			evs := c.Nostr.GetEvents(ctx, nostr.Filter{
+				Kinds:   []int{nostr.KindProfileMetadata, nostr.KindContactList},
+				Authors: []string{pubkey},
+			})
+			if _, err := c.Db.SaveEvents(ctx, evs); err != nil {
+				slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+			}
+		}
+
+		author, err := c.Db.GetAuthor(ctx, pubkey)
+		response.Data = author
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// GetAuthorNotes godoc
+// @Summary      Notes of an author
+// @Description  The notes and replies of an author, newest first. When we have less than a page the relays are asked for more. Use next_cursor as cursor for the next page
+// @Tags         profile
+// @Accept       json
+// @Produce      json
+// @Param		 pubkey	path	string	true	"Pubkey or npub"
+// @Param		 cursor	query	string	false	"next_cursor of the previous page"
+// @Param		 per_page	query	int	false	"Notes per page, default 20"
+// @Success      200  {object}  Response
+// @Failure      400  {string}  string    "error"
+// @Failure      404  {string}  string    "error"
+// @Failure      500  {string}  string    "error"
+// @Router       /api/profile/{pubkey}/notes [get]
+func (c *Controller) GetAuthorNotes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+		defer cancel()
+
+		response := &Response{}
+		response.Status = "ok"
+		response.Message = "Author notes"
+
+		pubkey, err := decodePubkey(chi.URLParam(r, "pubkey"))
+		var until int64
+		var untilId string
+		if err == nil {
+			until, untilId, err = parseNoteCursor(r.URL.Query().Get("cursor"))
+		}
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if perPage < 1 || perPage > 100 {
+			perPage = 20
+		}
+
+		notes, err := c.Db.GetAuthorNotes(ctx, pubkey, until, untilId, perPage)
+		if err == nil && len(notes) < perPage {
+			// Our history is thin, ask the relays for the page
+			filter := nostr.Filter{
+				Kinds:   []int{nostr.KindTextNote},
+				Authors: []string{pubkey},
+				Limit:   perPage * 2,
+			}
+			if until > 0 {
+				ts := nostr.Timestamp(until)
+				filter.Until = &ts
+			}
+			if evs := c.Nostr.GetEvents(ctx, filter); len(evs) > 0 {
+				if _, err := c.Db.SaveEvents(ctx, evs); err != nil {
+					slog.Warn(logger.GetCallerInfo(1), "error", err.Error())
+				}
+				notes, err = c.Db.GetAuthorNotes(ctx, pubkey, until, untilId, perPage)
+			}
+		}
+		if err != nil {
+			response.Status = "error"
+			response.Message = err.Error()
+			render.JSON(w, r, response)
+			return
+		}
+
+		nextCursor := ""
+		if len(notes) == perPage {
+			last := notes[len(notes)-1].Event
+			nextCursor = fmt.Sprintf("%d:%s", last.CreatedAt, last.ID)
+		}
+
+		type Page struct {
+			Notes      []db.Event `json:"notes"`
+			NextCursor string     `json:"next_cursor"`
+		}
+		response.Data = Page{Notes: notes, NextCursor: nextCursor}
+
+		render.JSON(w, r, response)
+	}
+}
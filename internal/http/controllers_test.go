@@ -0,0 +1,145 @@
+package http
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"amavis442/nostr-reader/internal/outbox"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestDecodePubkey(t *testing.T) {
+	pubkey := "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+	npub, _ := nip19.EncodePublicKey(pubkey)
+
+	for _, key := range []string{pubkey, npub} {
+		if decoded, err := decodePubkey(key); err != nil || decoded != pubkey {
+			t.Logf("%s should decode to the hex pubkey", key)
+			t.Fail()
+		}
+	}
+	if _, err := decodePubkey("npub1nope"); err == nil {
+		t.Log("an invalid npub should give an error")
+		t.Fail()
+	}
+}
+
+func TestParseNoteCursor(t *testing.T) {
+	id := "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+
+	until, eventId, err := parseNoteCursor("1700000000:" + id)
+	if err != nil || until != 1700000000 || eventId != id {
+		t.Log("cursor should give the created_at and event id")
+		t.Fail()
+	}
+	if until, _, err := parseNoteCursor(""); err != nil || until != 0 {
+		t.Log("no cursor is the first page")
+		t.Fail()
+	}
+	if _, _, err := parseNoteCursor("1700000000"); err == nil {
+		t.Log("a cursor without event id should give an error")
+		t.Fail()
+	}
+}
+
+func testStore(t *testing.T) *db.Storage {
+	st := &db.Storage{Pubkey: "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"}
+	if err := st.Connect(context.Background(), &db.DbConfig{Driver: db.Sqlite, Path: filepath.Join(t.TempDir(), "nostr-reader.db")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := st.GormDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return st
+}
+
+func TestGetEventRelays(t *testing.T) {
+	st := testStore(t)
+	c := &Controller{Db: st}
+	ctx := context.Background()
+
+	ev := nostr.Event{Kind: nostr.KindTextNote, Content: "seen on relays", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := ev.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	note := &db.Event{Event: &ev, Urls: []string{"wss://a.example.com/", "wss://b.example.com"}}
+	if _, err := st.SaveEvents(ctx, []*db.Event{note}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c.GetEventRelays()(w, httptest.NewRequest("GET", "/api/geteventrelays?event_id="+ev.ID, nil))
+	var response struct {
+		Status string          `json:"status"`
+		Data   []db.EventRelay `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || response.Status != "ok" || len(response.Data) != 2 {
+		t.Logf("the 2 relays of the note should be returned, got %d %v", w.Code, response.Data)
+		t.Fail()
+	}
+	for _, relay := range response.Data {
+		if relay.EventId != ev.ID || relay.RelayUrl == "wss://a.example.com/" || relay.FirstSeen.IsZero() {
+			t.Logf("every relay should have the event, a normalized url and when it was seen, got %+v", relay)
+			t.Fail()
+		}
+	}
+
+	w = httptest.NewRecorder()
+	c.GetEventRelays()(w, httptest.NewRequest("GET", "/api/geteventrelays", nil))
+	response.Status = ""
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || response.Status != "error" {
+		t.Logf("without event_id the status should be error, got %d %s", w.Code, response.Status)
+		t.Fail()
+	}
+}
+
+func TestPublishKeepsTheSignedContent(t *testing.T) {
+	st := testStore(t)
+	w := &wrapper.Wrapper{}
+	w.SetConfig(&wrapper.WrapperConfig{PrivateKey: nostr.GeneratePrivateKey(), Relays: map[string]db.Relay{}})
+	c := &Controller{Db: st, Nostr: w, Outbox: outbox.NewOutbox(st, w)}
+
+	content := "<b>bold</b> & more"
+	body, _ := json.Marshal(Msg{Msg: content})
+	rec := httptest.NewRecorder()
+	c.Publish()(rec, httptest.NewRequest("POST", "/api/publish", bytes.NewReader(body)))
+
+	var response Response
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil || response.Status != "ok" {
+		t.Fatal("the note should be published", err)
+	}
+	var ev nostr.Event
+	json.Unmarshal([]byte(response.Data.(string)), &struct{ Event *nostr.Event }{&ev})
+
+	queued, err := st.GetOutboxEvent(context.Background(), ev.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := queued.CheckSignature(); !ok || queued.Content != content {
+		t.Logf("the outbox should have the note as it was signed, got %q", queued.Content)
+		t.Fail()
+	}
+	if !st.HasNote(context.Background(), ev.ID) {
+		t.Log("the note should be saved")
+		t.Fail()
+	}
+}
@@ -3,6 +3,9 @@ package http
 import (
 	"amavis442/nostr-reader/internal/db"
 	wrapper "amavis442/nostr-reader/internal/nostr"
+	"amavis442/nostr-reader/internal/outbox"
+	"amavis442/nostr-reader/internal/syncer"
+	"amavis442/nostr-reader/internal/thread"
 	"fmt"
 	"log/slog"
 	"mime"
@@ -19,8 +22,11 @@ type ServerConfig struct {
 type HttpServer struct {
 	DevMode  bool
 	Server   *ServerConfig
-	Database *db.Storage
+	Database db.Store
 	Nostr    *wrapper.Wrapper
+	Sync     *syncer.Manager
+	Outbox   *outbox.Outbox
+	Thread   *thread.Fetcher
 	Router   *chi.Mux
 }
 
@@ -47,6 +53,9 @@ func (s *HttpServer) Start() {
 	c.Pubkey = s.Nostr.Cfg.PubKey
 	c.Db = s.Database
 	c.Nostr = s.Nostr
+	c.Sync = s.Sync
+	c.Outbox = s.Outbox
+	c.Thread = s.Thread
 
 	var port string = "8080"
 	if s.Server.Port > 0 {
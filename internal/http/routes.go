@@ -40,6 +40,28 @@ func routes(c *Controller, port string) *chi.Mux {
 
 	router.Get("/api/getlastseenid", c.GetLastSeenID())
 
+	/**
+	 * Read state of the notes, opening a page does not mark them as read
+	 */
+	router.Post("/api/read", c.MarkRead())
+	router.Post("/api/unread", c.MarkUnread())
+	router.Post("/api/read/upto", c.MarkReadUpTo())
+	router.Post("/api/read/thread", c.MarkThreadRead())
+
+	/**
+	 * Notifications are grouped in /api/getnotifications, seen is apart from the read state of the notes
+	 */
+	router.Post("/api/notifications/seen", c.MarkNotificationsSeen())
+	router.Get("/api/notifications/unseen", c.GetUnseenNotifications())
+	router.Get("/api/notifications/settings", c.GetNotificationSettings())
+	router.Post("/api/notifications/settings", c.SaveNotificationSetting())
+
+	/**
+	 * Open a note: get the whole thread from the relays
+	 */
+	router.Get("/api/thread/{id}", c.GetThread())
+	router.Get("/api/thread/{id}/replies", c.GetReplies())
+
 	/**
 	 * Put a user on the naughty list
 	 */
@@ -54,18 +76,58 @@ func routes(c *Controller, port string) *chi.Mux {
 	router.Get("/api/getfollowed", c.GetFollowedProfiles())
 	router.Get("/api/searchprofiles", c.SearchProfiles())
 
+	/**
+	 * Find that note someone posted last week
+	 */
+	router.Get("/api/search/notes", c.SearchNotes())
+
+	/**
+	 * Sync with the relays now and see how the syncs went
+	 */
+	router.Post("/api/sync", c.StartSync())
+	router.Get("/api/sync/status", c.GetSyncStatus())
+	router.Get("/api/sync/history", c.GetSyncHistory())
+
+	/**
+	 * Get the older notes of an author. Following someone queues this automatically
+	 */
+	router.Post("/api/backfill", c.Backfill())
+	router.Get("/api/getbackfills", c.GetBackfills())
+
+	/**
+	 * Report notes or pubkeys (NIP-56). Reports of the people you follow hide them from the global feed,
+	 * like the near duplicates that many authors post
+	 */
+	router.Post("/api/report", c.Report())
+	router.Get("/api/getmoderation", c.GetModeration())
+	router.Get("/api/spam/clusters", c.GetSpamClusters())
+
 	/**
 	 * Bookmark events you want to keep track of
 	 */
 	router.Post("/api/bookmark", c.AddBookMark())
 	router.Post("/api/removebookmark", c.RemoveBookMark())
 
+	/**
+	 * Events you published which are not delivered to all relays yet
+	 */
+	router.Get("/api/outbox", c.GetOutbox())
+	router.Post("/api/outbox/rebroadcast", c.Rebroadcast())
+
+	/**
+	 * See what the retention rules would delete, or clean up now instead of waiting for the schedule
+	 */
+	router.Get("/api/retention", c.GetRetention())
+	router.Post("/api/retention", c.ApplyRetention())
+
 	/**
 	 * Relay settings
 	 */
 	router.Post("/api/addrelay", c.AddRelay())
 	router.Post("/api/removerelay", c.RemoveRelay())
 	router.Get("/api/getrelays", c.GetRelays())
+	router.Get("/api/geteventrelays", c.GetEventRelays())
+	router.Get("/api/getmissingfromrelay", c.GetMissingFromRelay())
 
 	/**
 	 * Sometimes it is nice to see pictures in the post and not just a link
@@ -84,5 +146,11 @@ func routes(c *Controller, port string) *chi.Mux {
 	router.Post("/api/setmetadata", c.SetMetaData())
 	router.Get("/api/getprofile", c.GetProfile())
 
+	/**
+	 * The profile page of someone else, with the notes of that author
+	 */
+	router.Get("/api/profile/{pubkey}", c.GetAuthor())
+	router.Get("/api/profile/{pubkey}/notes", c.GetAuthorNotes())
+
 	return router
 }
@@ -0,0 +1,420 @@
+package negentropy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/**
+ * Range based set reconciliation of NIP-77 (negentropy protocol version 1).
+ * Both sides sort their events on (created_at, id), split the range in buckets and only compare the ids of
+ * the buckets with a different fingerprint. See https://github.com/hoytech/negentropy for the spec.
+ */
+
+const (
+	ProtocolVersion = 0x61
+
+	idSize          = 32
+	fingerprintSize = 16
+	buckets         = 16
+
+	modeSkip        = 0
+	modeFingerprint = 1
+	modeIdList      = 2
+)
+
+const maxTimestamp = math.MaxUint64
+
+var ErrUnsupportedVersion = errors.New("unsupported negentropy protocol version")
+
+type Item struct {
+	Timestamp uint64
+	ID        [idSize]byte
+}
+
+type bound struct {
+	Item
+	prefixLen int
+}
+
+// Items sort on timestamp and then on id
+func less(a, b Item) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+/**
+ * The sorted events of one side.
+ */
+type Storage struct {
+	items  []Item
+	sealed bool
+}
+
+func (s *Storage) Insert(timestamp int64, id string) error {
+	if s.sealed {
+		return errors.New("storage already sealed")
+	}
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != idSize {
+		return fmt.Errorf("invalid event id %q", id)
+	}
+
+	item := Item{Timestamp: uint64(timestamp)}
+	copy(item.ID[:], raw)
+	s.items = append(s.items, item)
+	return nil
+}
+
+// Sort the items, after this nothing can be added
+func (s *Storage) Seal() {
+	sort.Slice(s.items, func(i, j int) bool { return less(s.items[i], s.items[j]) })
+	s.sealed = true
+}
+
+func (s *Storage) Size() int {
+	return len(s.items)
+}
+
+// First index in [first, last) that is not lower than b
+func (s *Storage) findLowerBound(first, last int, b bound) int {
+	return first + sort.Search(last-first, func(i int) bool {
+		return !less(s.items[first+i], b.Item)
+	})
+}
+
+/**
+ * Sum of the ids as 256 bit little endian numbers, with the count appended and hashed.
+ */
+func (s *Storage) fingerprint(lower, upper int) []byte {
+	var sum [idSize]byte
+	for _, item := range s.items[lower:upper] {
+		var carry uint16
+		for i := 0; i < idSize; i++ {
+			v := uint16(sum[i]) + uint16(item.ID[i]) + carry
+			sum[i] = byte(v)
+			carry = v >> 8
+		}
+	}
+
+	h := sha256.New()
+	h.Write(sum[:])
+	h.Write(encodeVarInt(uint64(upper - lower)))
+	return h.Sum(nil)[:fingerprintSize]
+}
+
+/**
+ * One side of a reconciliation. The client calls Initiate and keeps calling Reconcile with the answers of the relay
+ * until the returned message is nil. The relay side only uses Reconcile.
+ */
+type Negentropy struct {
+	storage     *Storage
+	isInitiator bool
+
+	lastTimestampIn  uint64
+	lastTimestampOut uint64
+}
+
+func New(storage *Storage) (*Negentropy, error) {
+	if !storage.sealed {
+		return nil, errors.New("storage is not sealed")
+	}
+	return &Negentropy{storage: storage}, nil
+}
+
+// The first message of the client
+func (n *Negentropy) Initiate() []byte {
+	n.isInitiator = true
+	n.lastTimestampOut = 0
+
+	out := []byte{ProtocolVersion}
+	return n.splitRange(0, n.storage.Size(), bound{Item: Item{Timestamp: maxTimestamp}}, out)
+}
+
+/**
+ * Process a message of the other side. For the client haveIds are the ids only we have and needIds the ids only
+ * the relay has. The returned message is nil when the client is done.
+ */
+func (n *Negentropy) Reconcile(query []byte) (out []byte, haveIds []string, needIds []string, err error) {
+	n.lastTimestampIn = 0
+	n.lastTimestampOut = 0
+
+	r := &reader{buf: query}
+	full := []byte{ProtocolVersion}
+
+	version, err := r.byte()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if version < 0x60 || version > 0x6f {
+		return nil, nil, nil, errors.New("invalid negentropy protocol version byte")
+	}
+	if version != ProtocolVersion {
+		if n.isInitiator {
+			return nil, nil, nil, ErrUnsupportedVersion
+		}
+		return full, nil, nil, nil // Tell the client which version we speak
+	}
+
+	size := n.storage.Size()
+	prevBound := bound{}
+	prevIndex := 0
+	skip := false
+
+	for r.len() > 0 {
+		var o []byte
+		doSkip := func() {
+			if skip {
+				skip = false
+				o = n.encodeBound(o, prevBound)
+				o = append(o, encodeVarInt(modeSkip)...)
+			}
+		}
+
+		currBound, err := n.decodeBound(r)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		mode, err := r.varInt()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		lower := prevIndex
+		upper := n.storage.findLowerBound(prevIndex, size, currBound)
+
+		switch mode {
+		case modeSkip:
+			skip = true
+
+		case modeFingerprint:
+			theirs, err := r.bytes(fingerprintSize)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if !bytes.Equal(theirs, n.storage.fingerprint(lower, upper)) {
+				doSkip()
+				o = n.splitRange(lower, upper, currBound, o)
+			} else {
+				skip = true
+			}
+
+		case modeIdList:
+			count, err := r.varInt()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			theirs := make(map[[idSize]byte]bool, count)
+			for i := uint64(0); i < count; i++ {
+				raw, err := r.bytes(idSize)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				var id [idSize]byte
+				copy(id[:], raw)
+				theirs[id] = true
+			}
+
+			if n.isInitiator {
+				skip = true
+				for _, item := range n.storage.items[lower:upper] {
+					if theirs[item.ID] {
+						delete(theirs, item.ID)
+					} else {
+						haveIds = append(haveIds, hex.EncodeToString(item.ID[:]))
+					}
+				}
+				for id := range theirs {
+					needIds = append(needIds, hex.EncodeToString(id[:]))
+				}
+			} else {
+				doSkip()
+				o = n.encodeBound(o, currBound)
+				o = append(o, encodeVarInt(modeIdList)...)
+				o = append(o, encodeVarInt(uint64(upper-lower))...)
+				for _, item := range n.storage.items[lower:upper] {
+					o = append(o, item.ID[:]...)
+				}
+			}
+
+		default:
+			return nil, nil, nil, fmt.Errorf("unexpected negentropy mode %d", mode)
+		}
+
+		full = append(full, o...)
+		prevIndex = upper
+		prevBound = currBound
+	}
+
+	if len(full) == 1 && n.isInitiator {
+		return nil, haveIds, needIds, nil
+	}
+	return full, haveIds, needIds, nil
+}
+
+/**
+ * Small ranges are send as a list of ids, bigger ones as fingerprints of buckets.
+ */
+func (n *Negentropy) splitRange(lower, upper int, upperBound bound, o []byte) []byte {
+	count := upper - lower
+
+	if count < buckets*2 {
+		o = n.encodeBound(o, upperBound)
+		o = append(o, encodeVarInt(modeIdList)...)
+		o = append(o, encodeVarInt(uint64(count))...)
+		for _, item := range n.storage.items[lower:upper] {
+			o = append(o, item.ID[:]...)
+		}
+		return o
+	}
+
+	perBucket := count / buckets
+	withExtra := count % buckets
+	curr := lower
+	for i := 0; i < buckets; i++ {
+		size := perBucket
+		if i < withExtra {
+			size++
+		}
+		fp := n.storage.fingerprint(curr, curr+size)
+		curr += size
+
+		next := upperBound
+		if curr != upper {
+			next = minimalBound(n.storage.items[curr-1], n.storage.items[curr])
+		}
+		o = n.encodeBound(o, next)
+		o = append(o, encodeVarInt(modeFingerprint)...)
+		o = append(o, fp...)
+	}
+	return o
+}
+
+// The shortest bound that is above prev and not above curr
+func minimalBound(prev, curr Item) bound {
+	if curr.Timestamp != prev.Timestamp {
+		return bound{Item: Item{Timestamp: curr.Timestamp}}
+	}
+
+	shared := 0
+	for shared < idSize && curr.ID[shared] == prev.ID[shared] {
+		shared++
+	}
+	b := bound{Item: Item{Timestamp: curr.Timestamp}, prefixLen: shared + 1}
+	copy(b.ID[:b.prefixLen], curr.ID[:b.prefixLen])
+	return b
+}
+
+/**
+ * Timestamps are send as the difference with the previous one plus 1, 0 means infinity.
+ */
+func (n *Negentropy) encodeBound(o []byte, b bound) []byte {
+	if b.Timestamp == maxTimestamp {
+		n.lastTimestampOut = maxTimestamp
+		o = append(o, encodeVarInt(0)...)
+	} else {
+		delta := b.Timestamp - n.lastTimestampOut
+		n.lastTimestampOut = b.Timestamp
+		o = append(o, encodeVarInt(delta+1)...)
+	}
+	o = append(o, encodeVarInt(uint64(b.prefixLen))...)
+	return append(o, b.ID[:b.prefixLen]...)
+}
+
+func (n *Negentropy) decodeBound(r *reader) (bound, error) {
+	ts, err := r.varInt()
+	if err != nil {
+		return bound{}, err
+	}
+	if ts == 0 {
+		ts = maxTimestamp
+	} else {
+		ts--
+	}
+	if n.lastTimestampIn == maxTimestamp || ts == maxTimestamp {
+		n.lastTimestampIn = maxTimestamp
+		ts = maxTimestamp
+	} else {
+		ts += n.lastTimestampIn
+		n.lastTimestampIn = ts
+	}
+
+	prefixLen, err := r.varInt()
+	if err != nil {
+		return bound{}, err
+	}
+	if prefixLen > idSize {
+		return bound{}, errors.New("bound id prefix too long")
+	}
+	prefix, err := r.bytes(int(prefixLen))
+	if err != nil {
+		return bound{}, err
+	}
+
+	b := bound{Item: Item{Timestamp: ts}, prefixLen: int(prefixLen)}
+	copy(b.ID[:], prefix)
+	return b, nil
+}
+
+// Most significant group of 7 bits first, the high bit is set on all but the last byte
+func encodeVarInt(v uint64) []byte {
+	if v == 0 {
+		return []byte{0}
+	}
+	var o []byte
+	for v > 0 {
+		o = append([]byte{byte(v & 0x7f)}, o...)
+		v >>= 7
+	}
+	for i := 0; i < len(o)-1; i++ {
+		o[i] |= 0x80
+	}
+	return o
+}
+
+type reader struct {
+	buf []byte
+}
+
+func (r *reader) len() int {
+	return len(r.buf)
+}
+
+func (r *reader) byte() (byte, error) {
+	if len(r.buf) < 1 {
+		return 0, errors.New("negentropy message too short")
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if len(r.buf) < n {
+		return nil, errors.New("negentropy message too short")
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+func (r *reader) varInt() (uint64, error) {
+	var v uint64
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v = (v << 7) | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+}
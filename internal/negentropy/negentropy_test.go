@@ -0,0 +1,196 @@
+package negentropy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func testId(n int) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("event-%d", n)))
+	return hex.EncodeToString(h[:])
+}
+
+func newStorage(t *testing.T, items map[int]int64) *Storage {
+	s := &Storage{}
+	for n, ts := range items {
+		if err := s.Insert(ts, testId(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Seal()
+	return s
+}
+
+// Run the client against the server in memory and return what the client has and needs
+func reconcile(t *testing.T, client, server *Storage) (have, need []string, rounds int) {
+	c, err := New(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := c.Initiate()
+	for msg != nil {
+		rounds++
+		if rounds > 50 {
+			t.Fatal("reconciliation does not end")
+		}
+		answer, _, _, err := s.Reconcile(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var h, n []string
+		msg, h, n, err = c.Reconcile(answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have = append(have, h...)
+		need = append(need, n...)
+	}
+	sort.Strings(have)
+	sort.Strings(need)
+	return have, need, rounds
+}
+
+func TestReconcile(t *testing.T) {
+	for _, size := range []int{0, 1, 20, 100, 5000} {
+		t.Run(fmt.Sprintf("size %d", size), func(t *testing.T) {
+			client := map[int]int64{}
+			server := map[int]int64{}
+			var wantHave, wantNeed []string
+
+			for n := 0; n < size; n++ {
+				ts := int64(1700000000 + n/3) // Some events share a timestamp
+				switch n % 7 {
+				case 0:
+					client[n] = ts
+					wantHave = append(wantHave, testId(n))
+				case 1:
+					server[n] = ts
+					wantNeed = append(wantNeed, testId(n))
+				default:
+					client[n] = ts
+					server[n] = ts
+				}
+			}
+			sort.Strings(wantHave)
+			sort.Strings(wantNeed)
+
+			have, need, _ := reconcile(t, newStorage(t, client), newStorage(t, server))
+			if fmt.Sprint(have) != fmt.Sprint(wantHave) {
+				t.Errorf("have %d ids, want %d", len(have), len(wantHave))
+			}
+			if fmt.Sprint(need) != fmt.Sprint(wantNeed) {
+				t.Errorf("need %d ids, want %d", len(need), len(wantNeed))
+			}
+		})
+	}
+}
+
+func TestReconcileEqualSetsIsOneRound(t *testing.T) {
+	items := map[int]int64{}
+	for n := 0; n < 1000; n++ {
+		items[n] = int64(1700000000 + n)
+	}
+
+	have, need, rounds := reconcile(t, newStorage(t, items), newStorage(t, items))
+	if len(have) != 0 || len(need) != 0 {
+		t.Fatalf("equal sets differ: have %d, need %d", len(have), len(need))
+	}
+	if rounds != 1 {
+		t.Errorf("rounds = %d, want 1", rounds)
+	}
+}
+
+func TestVarInt(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 255, 16383, 16384, 1 << 40} {
+		r := &reader{buf: encodeVarInt(v)}
+		got, err := r.varInt()
+		if err != nil || got != v || r.len() != 0 {
+			t.Errorf("varint %d: got %d, %v", v, got, err)
+		}
+	}
+	if got := encodeVarInt(300); hex.EncodeToString(got) != "822c" {
+		t.Errorf("encodeVarInt(300) = %x, want 822c", got)
+	}
+}
+
+func TestServerAnswersUnknownVersion(t *testing.T) {
+	n, _ := New(newStorage(t, nil))
+	out, _, _, err := n.Reconcile([]byte{0x62})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0] != ProtocolVersion {
+		t.Errorf("answer = %x, want %x", out, ProtocolVersion)
+	}
+}
+
+/**
+ * Messages as the reference implementation (hoytech/negentropy, protocol V1) writes them, so we can talk to
+ * strfry and the other relays. An upper bound of infinity is timestamp 0 without id prefix, mode 2 is an IdList.
+ */
+func TestInteropVectors(t *testing.T) {
+	a, b := testId(1), testId(2)
+
+	empty, _ := New(newStorage(t, nil))
+	if got := hex.EncodeToString(empty.Initiate()); got != "6100000200" {
+		t.Errorf("initiate of an empty set = %s, want 6100000200", got)
+	}
+
+	one, _ := New(newStorage(t, map[int]int64{1: 1000}))
+	if got := hex.EncodeToString(one.Initiate()); got != "6100000201"+a {
+		t.Errorf("initiate of one id = %s, want 6100000201%s", got, a)
+	}
+
+	// A relay answers an IdList with all the ids it has in the range
+	server, _ := New(newStorage(t, map[int]int64{2: 1000}))
+	answer, _, _, err := server.Reconcile([]byte{0x61, 0x00, 0x00, 0x02, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(answer); got != "6100000201"+b {
+		t.Errorf("answer = %s, want 6100000201%s", got, b)
+	}
+
+	msg, have, need, err := empty.Reconcile(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != nil || len(have) != 0 || len(need) != 1 || need[0] != b {
+		t.Errorf("the client should be done and need %s, got msg %x, have %v, need %v", b, msg, have, need)
+	}
+}
+
+// The fingerprint of NIP-77: the ids added as 256 bit little endian numbers, then the count as varint, sha256, 16 bytes
+func TestFingerprintFollowsTheSpec(t *testing.T) {
+	items := map[int]int64{}
+	for n := 0; n < 100; n++ {
+		items[n] = int64(1700000000 + n)
+	}
+	s := newStorage(t, items)
+
+	sum := new(big.Int)
+	for n := 0; n < 100; n++ {
+		id, _ := hex.DecodeString(testId(n))
+		slices.Reverse(id)
+		sum.Add(sum, new(big.Int).SetBytes(id))
+	}
+	sum.Mod(sum, new(big.Int).Lsh(big.NewInt(1), 256))
+	le := make([]byte, 32)
+	sum.FillBytes(le)
+	slices.Reverse(le)
+	want := sha256.Sum256(append(le, encodeVarInt(100)...))
+
+	if got := s.fingerprint(0, 100); hex.EncodeToString(got) != hex.EncodeToString(want[:16]) {
+		t.Errorf("fingerprint = %x, want %x", got, want[:16])
+	}
+}
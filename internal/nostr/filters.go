@@ -0,0 +1,193 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+/**
+ * Limits of the filters we use for syncing. Relays do not like huge filters, so the
+ * authors and ids are split in chunks of ChunkSize.
+ */
+type SyncConfig struct {
+	ChunkSize     int
+	FollowsLimit  int
+	MentionsLimit int
+	RepliesLimit  int
+	RepliesDays   int  // Replies are synced for our own notes of the last RepliesDays days
+	RepliesNotes  int  // But for no more than RepliesNotes of them
+	Global        bool // Also get a sample of all notes for the global feed
+	GlobalLimit   int
+	ProfileTTL    int // Hours before we get a profile again
+	ProfileBatch  int // Profiles in one request
+
+	Negentropy       bool // Reconcile the notes of our follows with NIP-77 on relays that support it
+	NegentropyDays   int  // How many days back we reconcile
+	NegentropyUpload bool // Give relays the notes they miss
+}
+
+// The kinds we want of the people we follow
+var SyncKinds = []int{nostr.KindTextNote, nostr.KindContactList, nostr.KindReaction, nostr.KindArticle, nostr.KindDeletion, nostr.KindProfileMetadata, nostr.KindRecommendServer, 2003, 2004, 9802, db.KindReporting}
+
+const (
+	SyncFilterFollows  = "follows"
+	SyncFilterMentions = "mentions"
+	SyncFilterReplies  = "replies"
+	SyncFilterGlobal   = "global"
+)
+
+/**
+ * A sync filter with its own cursor (Key). All chunks must be finished by a relay before its cursor moves.
+ */
+type SyncFilter struct {
+	Key     string
+	Filters nostr.Filters
+}
+
+func DefaultSyncConfig() *SyncConfig {
+	return &SyncConfig{
+		ChunkSize:     250,
+		FollowsLimit:  1000,
+		MentionsLimit: 500,
+		RepliesLimit:  500,
+		RepliesDays:   30,
+		RepliesNotes:  1000,
+		Global:        true,
+		GlobalLimit:   200,
+		ProfileTTL:    24,
+		ProfileBatch:  100,
+
+		NegentropyDays: 7,
+	}
+}
+
+func (wrapper *Wrapper) SetSyncConfig(cfg *SyncConfig) {
+	defaults := DefaultSyncConfig()
+	if cfg == nil {
+		wrapper.Sync = *defaults
+		return
+	}
+
+	wrapper.Sync = *cfg
+	if wrapper.Sync.ChunkSize < 1 {
+		wrapper.Sync.ChunkSize = defaults.ChunkSize
+	}
+	if wrapper.Sync.FollowsLimit < 1 {
+		wrapper.Sync.FollowsLimit = defaults.FollowsLimit
+	}
+	if wrapper.Sync.MentionsLimit < 1 {
+		wrapper.Sync.MentionsLimit = defaults.MentionsLimit
+	}
+	if wrapper.Sync.RepliesLimit < 1 {
+		wrapper.Sync.RepliesLimit = defaults.RepliesLimit
+	}
+	if wrapper.Sync.RepliesDays < 1 {
+		wrapper.Sync.RepliesDays = defaults.RepliesDays
+	}
+	if wrapper.Sync.RepliesNotes < 1 {
+		wrapper.Sync.RepliesNotes = defaults.RepliesNotes
+	}
+	if wrapper.Sync.GlobalLimit < 1 {
+		wrapper.Sync.GlobalLimit = defaults.GlobalLimit
+	}
+	if wrapper.Sync.ProfileTTL < 1 {
+		wrapper.Sync.ProfileTTL = defaults.ProfileTTL
+	}
+	if wrapper.Sync.ProfileBatch < 1 {
+		wrapper.Sync.ProfileBatch = defaults.ProfileBatch
+	}
+	if wrapper.Sync.NegentropyDays < 1 {
+		wrapper.Sync.NegentropyDays = defaults.NegentropyDays
+	}
+}
+
+/**
+ * Instead of one global firehose we sync the notes of the people we follow, the notes that mention us,
+ * the replies and reactions on our own notes and, when enabled, a small sample of everything else.
+ * The since is for relays that do not have a cursor yet, older notes are for the backfill.
+ */
+func (wrapper *Wrapper) GetSyncFilters(follows []string, ownNoteIds []string) []SyncFilter {
+	var since nostr.Timestamp = nostr.Timestamp(time.Now().Unix() - 60)
+
+	interactions := []int{nostr.KindTextNote, nostr.KindRepost, nostr.KindReaction, nostr.KindZap}
+
+	filters := make([]SyncFilter, 0)
+
+	authors := append([]string{wrapper.Cfg.PubKey}, follows...)
+	followsFilter := SyncFilter{Key: SyncFilterFollows}
+	for _, chunk := range chunks(authors, wrapper.Sync.ChunkSize) {
+		followsFilter.Filters = append(followsFilter.Filters, nostr.Filter{
+			Kinds:   SyncKinds,
+			Authors: chunk,
+			Since:   &since,
+			Limit:   wrapper.Sync.FollowsLimit,
+		})
+	}
+	filters = append(filters, followsFilter)
+
+	// A contact list with us in it is a new follower
+	mentions := append([]int{nostr.KindContactList}, interactions...)
+	filters = append(filters, SyncFilter{Key: SyncFilterMentions, Filters: nostr.Filters{{
+		Kinds: mentions,
+		Tags:  nostr.TagMap{"p": []string{wrapper.Cfg.PubKey}},
+		Since: &since,
+		Limit: wrapper.Sync.MentionsLimit,
+	}}})
+
+	if len(ownNoteIds) > 0 {
+		repliesFilter := SyncFilter{Key: SyncFilterReplies}
+		for _, chunk := range chunks(ownNoteIds, wrapper.Sync.ChunkSize) {
+			repliesFilter.Filters = append(repliesFilter.Filters, nostr.Filter{
+				Kinds: interactions,
+				Tags:  nostr.TagMap{"e": chunk},
+				Since: &since,
+				Limit: wrapper.Sync.RepliesLimit,
+			})
+		}
+		filters = append(filters, repliesFilter)
+	}
+
+	if wrapper.Sync.Global {
+		filters = append(filters, SyncFilter{Key: SyncFilterGlobal, Filters: nostr.Filters{{
+			Kinds: []int{nostr.KindTextNote, nostr.KindProfileMetadata},
+			Since: &since,
+			Limit: wrapper.Sync.GlobalLimit,
+		}}})
+	}
+
+	return filters
+}
+
+/**
+ * The notes of the people we follow and our own of the last NegentropyDays, for a NIP-77 reconciliation.
+ */
+func (wrapper *Wrapper) GetNegentropyFilters(follows []string) nostr.Filters {
+	since := nostr.Timestamp(time.Now().AddDate(0, 0, -wrapper.Sync.NegentropyDays).Unix())
+	authors := append([]string{wrapper.Cfg.PubKey}, follows...)
+
+	filters := make(nostr.Filters, 0)
+	for _, chunk := range chunks(authors, wrapper.Sync.ChunkSize) {
+		filters = append(filters, nostr.Filter{
+			Kinds:   []int{nostr.KindTextNote},
+			Authors: chunk,
+			Since:   &since,
+		})
+	}
+	return filters
+}
+
+func chunks(items []string, size int) [][]string {
+	result := make([][]string, 0)
+	if size < 1 {
+		size = len(items)
+	}
+	for size < len(items) {
+		items, result = items[size:], append(result, items[0:size:size])
+	}
+	if len(items) > 0 {
+		result = append(result, items)
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package nostr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChunks(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+
+	result := chunks(items, 2)
+	if len(result) != 3 {
+		t.Log("5 items in chunks of 2 should give 3 chunks")
+		t.Fail()
+	}
+	if len(result[2]) != 1 || result[2][0] != "e" {
+		t.Log("last chunk should only have e")
+		t.Fail()
+	}
+
+	if len(chunks([]string{}, 2)) != 0 {
+		t.Log("no items should give no chunks")
+		t.Fail()
+	}
+}
+
+func TestGetSyncFiltersChunksAuthors(t *testing.T) {
+	var w Wrapper
+	w.Cfg.PubKey = "self"
+	w.SetSyncConfig(&SyncConfig{ChunkSize: 2, Global: false})
+
+	filters := w.GetSyncFilters([]string{"a", "b", "c"}, []string{})
+
+	if len(filters) != 2 {
+		t.Log("without own notes and global we should only have the follows and mentions filter")
+		t.FailNow()
+	}
+	if filters[0].Key != SyncFilterFollows || len(filters[0].Filters) != 2 {
+		t.Log("4 authors (self included) in chunks of 2 should give 2 follows filters")
+		t.Fail()
+	}
+	if since := int64(*filters[0].Filters[0].Since); since < time.Now().Unix()-120 {
+		t.Log("relays without a cursor should start a minute ago, not from the newest note we have")
+		t.Fail()
+	}
+	if filters[1].Filters[0].Tags["p"][0] != "self" {
+		t.Log("mentions filter should be on our own pubkey")
+		t.Fail()
+	}
+}
@@ -0,0 +1,110 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	healthMinBackoff = 30 * time.Second
+	healthMaxBackoff = time.Hour
+)
+
+func (wrapper *Wrapper) relayHealth(relayUrl string) *db.RelayHealth {
+	if wrapper.health == nil {
+		wrapper.health = make(map[string]*db.RelayHealth)
+	}
+	h, ok := wrapper.health[relayUrl]
+	if !ok {
+		h = &db.RelayHealth{Url: relayUrl}
+		wrapper.health[relayUrl] = h
+	}
+	return h
+}
+
+/**
+ * A relay that failed is skipped until its backoff has passed. Then it gets a new chance,
+ * so a relay that is back online is used again without a restart.
+ */
+func (wrapper *Wrapper) RelayAvailable(relayUrl string) bool {
+	wrapper.healthMu.Lock()
+	defer wrapper.healthMu.Unlock()
+
+	h, ok := wrapper.health[relayUrl]
+	if !ok || h.RetryAt == nil {
+		return true
+	}
+	return time.Now().After(*h.RetryAt)
+}
+
+func (wrapper *Wrapper) RelaySucceeded(relayUrl string, latency time.Duration) {
+	wrapper.healthMu.Lock()
+	defer wrapper.healthMu.Unlock()
+
+	now := time.Now()
+	h := wrapper.relayHealth(relayUrl)
+	if latency > 0 {
+		h.LatencyMs = latency.Milliseconds()
+	}
+	h.Successes++
+	h.ConsecutiveFailures = 0
+	h.LastSuccessAt = &now
+	h.RetryAt = nil
+}
+
+/**
+ * Every failure in a row doubles the time we leave the relay alone.
+ */
+func (wrapper *Wrapper) RelayFailed(relayUrl string, err error) {
+	wrapper.healthMu.Lock()
+	defer wrapper.healthMu.Unlock()
+
+	now := time.Now()
+	h := wrapper.relayHealth(relayUrl)
+	h.Errors++
+	h.ConsecutiveFailures++
+	h.LastErrorAt = &now
+	if err != nil {
+		h.LastError = err.Error()
+	}
+
+	backoff := min(healthMinBackoff<<min(h.ConsecutiveFailures-1, 16), healthMaxBackoff)
+	retryAt := now.Add(backoff)
+	h.RetryAt = &retryAt
+}
+
+// Events with a bad id or signature, a relay sending these is not to be trusted
+func (wrapper *Wrapper) RelayRejected(relayUrl string, count int) {
+	wrapper.healthMu.Lock()
+	defer wrapper.healthMu.Unlock()
+
+	h := wrapper.relayHealth(relayUrl)
+	h.Rejected += int64(count)
+}
+
+// A copy of the health of all the relays we used
+func (wrapper *Wrapper) GetRelayHealth() []db.RelayHealth {
+	wrapper.healthMu.Lock()
+	defer wrapper.healthMu.Unlock()
+
+	health := make([]db.RelayHealth, 0, len(wrapper.health))
+	for _, h := range wrapper.health {
+		health = append(health, *h)
+	}
+	return health
+}
+
+// Restore the stored health, so relays that were failing are not hammered after a restart
+func (wrapper *Wrapper) SetRelayHealth(health []db.RelayHealth) {
+	wrapper.healthMu.Lock()
+	defer wrapper.healthMu.Unlock()
+
+	wrapper.health = make(map[string]*db.RelayHealth, len(health))
+	for i := range health {
+		h := health[i]
+		h.Url = nostr.NormalizeURL(h.Url)
+		wrapper.health[h.Url] = &h
+	}
+}
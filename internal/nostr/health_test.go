@@ -0,0 +1,105 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRelayBackoffAndRecovery(t *testing.T) {
+	var w Wrapper
+	url := "wss://relay.example.com"
+
+	if !w.RelayAvailable(url) {
+		t.Log("unknown relay should be available")
+		t.Fail()
+	}
+
+	w.RelayFailed(url, errors.New("timeout"))
+	w.RelayFailed(url, errors.New("timeout"))
+	if w.RelayAvailable(url) {
+		t.Log("failing relay should be in backoff")
+		t.Fail()
+	}
+
+	health := w.GetRelayHealth()
+	if len(health) != 1 || health[0].ConsecutiveFailures != 2 || health[0].Errors != 2 {
+		t.Log("expected 2 consecutive failures")
+		t.Fail()
+	}
+	if health[0].RetryAt.Sub(*health[0].LastErrorAt) != 2*healthMinBackoff {
+		t.Log("backoff should double on the second failure")
+		t.Fail()
+	}
+
+	w.RelaySucceeded(url, 20*time.Millisecond)
+	if !w.RelayAvailable(url) {
+		t.Log("relay should be available again after a success")
+		t.Fail()
+	}
+	health = w.GetRelayHealth()
+	if health[0].ConsecutiveFailures != 0 || health[0].LatencyMs != 20 {
+		t.Log("success should reset the failures and store the latency")
+		t.Fail()
+	}
+}
+
+func TestRelayUrlsAreNormalized(t *testing.T) {
+	var w Wrapper
+	w.SetConfig(&WrapperConfig{Relays: map[string]db.Relay{"ws://127.0.0.1:1/": {Read: true}}})
+	defer w.Close()
+
+	relays := w.GetRelays()
+	if _, ok := relays["ws://127.0.0.1:1"]; !ok || len(relays) != 1 {
+		t.Logf("the config should use the normalized url as key, got %v", relays)
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := w.Relay(ctx, "ws://127.0.0.1:1/"); err == nil {
+		t.Fatal("nothing listens on port 1")
+	}
+	if w.RelayAvailable("ws://127.0.0.1:1") {
+		t.Log("the failure should be on the same key as the config")
+		t.Fail()
+	}
+
+	w.UpdateRelays([]db.Relay{{Url: "wss://Relay.Example.com/", Read: true}})
+	if _, ok := w.GetRelays()["wss://relay.example.com"]; !ok {
+		t.Log("updated relays should be normalized too")
+		t.Fail()
+	}
+
+	w.SetRelayHealth([]db.RelayHealth{{Url: "wss://Relay.Example.com/", ConsecutiveFailures: 1}})
+	if health := w.GetRelayHealth(); len(health) != 1 || health[0].Url != "wss://relay.example.com" {
+		t.Log("stored health should be normalized when it is restored")
+		t.Fail()
+	}
+}
+
+func TestRelayRecoversOnAPooledConnection(t *testing.T) {
+	_, relayUrl := queryRelay(t, signedNotes(t, 3, func(i int) int64 { return int64(1700000000 + i) }))
+
+	var w Wrapper
+	w.SetConfig(&WrapperConfig{Relays: map[string]db.Relay{relayUrl: {Read: true}}})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := w.Relay(ctx, relayUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	// The backoff has passed, the connection is still in the pool
+	w.SetRelayHealth([]db.RelayHealth{{Url: relayUrl, ConsecutiveFailures: 2}})
+	w.GetPage(ctx, nostr.Filter{Kinds: []int{nostr.KindTextNote}, Limit: 10})
+	if health := w.GetRelayHealth(); len(health) != 1 || health[0].ConsecutiveFailures != 0 {
+		t.Logf("a query that is answered should reset the failures, got %+v", health)
+		t.Fail()
+	}
+}
@@ -0,0 +1,230 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	streamMinBackoff = 5 * time.Second
+	streamMaxBackoff = 5 * time.Minute
+)
+
+/*
+ * Live mode: keep a subscription open on every read relay and send all incoming events to the events channel.
+ * Every relay starts at its own cursor when it has one (keyed on relay url).
+ * The channel is not closed by Stream, it just returns when the context is done.
+ */
+func (wrapper *Wrapper) Stream(ctx context.Context, syncFilter SyncFilter, cursors map[string]db.SyncCursor, events chan<- *db.Event) {
+	relays := make([]string, 0)
+	for relayUrl, v := range wrapper.GetRelays() {
+		if v.Read {
+			relays = append(relays, relayUrl)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, relayUrl := range relays {
+		wg.Add(1)
+		go func(relayUrl string) {
+			defer wg.Done()
+			filters := make(nostr.Filters, 0, len(syncFilter.Filters))
+			for _, f := range syncFilter.Filters {
+				if since := cursors[relayUrl].Since; since > 0 {
+					ts := nostr.Timestamp(since)
+					f.Since = &ts
+				}
+				filters = append(filters, f)
+			}
+			wrapper.streamRelay(ctx, relayUrl, filters, events)
+		}(relayUrl)
+	}
+	wg.Wait()
+}
+
+/*
+ * When a relay disconnects or closes our subscription, we wait and subscribe again with since set to
+ * the newest event we got from that relay, so nothing is lost in between. The wait doubles on every failure.
+ */
+func (wrapper *Wrapper) streamRelay(ctx context.Context, relayUrl string, filters nostr.Filters, events chan<- *db.Event) {
+	var since nostr.Timestamp
+	for _, f := range filters {
+		if f.Since != nil && (since == 0 || *f.Since < since) {
+			since = *f.Since
+		}
+	}
+	backoff := streamMinBackoff
+
+	for ctx.Err() == nil {
+		relay, err := wrapper.Relay(ctx, relayUrl)
+		if err != nil {
+			slog.Info("can't connect to relay: "+relayUrl, "error", err.Error(), "retry", backoff.String())
+			if !waitFor(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, streamMaxBackoff)
+			continue
+		}
+
+		current := make(nostr.Filters, 0, len(filters))
+		for _, f := range filters {
+			if since > 0 {
+				s := since
+				f.Since = &s
+			}
+			current = append(current, f)
+		}
+
+		sub, err := relay.Subscribe(ctx, current)
+		if err != nil {
+			slog.Info("can't subscribe to relay: "+relayUrl, "error", err.Error(), "retry", backoff.String())
+			wrapper.RelayFailed(relayUrl, err)
+			if !waitFor(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, streamMaxBackoff)
+			continue
+		}
+		slog.Info("Streaming from relay", "relay", relayUrl, "since", since)
+		wrapper.RelaySucceeded(relayUrl, 0)
+		backoff = streamMinBackoff
+
+	loop:
+		for {
+			select {
+			case ev, ok := <-sub.Events:
+				if !ok || ev == nil {
+					break loop
+				}
+				if len(wrapper.VerifyEvents(relayUrl, []*nostr.Event{ev})) == 0 {
+					continue
+				}
+				if ev.CreatedAt > since {
+					since = ev.CreatedAt
+				}
+				if ev.Kind == nostr.KindTextNote && len(ev.Content) == 0 {
+					continue
+				}
+				myEvent := &db.Event{}
+				myEvent.Event = ev
+				myEvent.Urls = append(myEvent.Urls, relay.URL)
+
+				select {
+				case events <- myEvent:
+				case <-ctx.Done():
+					break loop
+				}
+			case <-sub.EndOfStoredEvents:
+				slog.Info("End of stored events", "relay", relayUrl)
+			case reason := <-sub.ClosedReason:
+				slog.Info("Subscription closed by relay", "relay", relayUrl, "reason", reason)
+				break loop
+			case <-ctx.Done():
+				break loop
+			}
+		}
+
+		// The connection stays in the pool, a dropped one is connected again on the next round
+		sub.Unsub()
+
+		if !waitFor(ctx, backoff) {
+			return
+		}
+	}
+}
+
+/**
+ * The same sync filter asks the relays for the same events. Since and until are left out, they only tell
+ * where a new subscription starts.
+ */
+func SameSyncFilter(a SyncFilter, b SyncFilter) bool {
+	if a.Key != b.Key || len(a.Filters) != len(b.Filters) {
+		return false
+	}
+	for i := range a.Filters {
+		fa, fb := a.Filters[i].Clone(), b.Filters[i].Clone()
+		fa.Since, fa.Until, fb.Since, fb.Until = nil, nil, nil, nil
+		if !nostr.FilterEqual(fa, fb) || fa.Limit != fb.Limit {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * The live subscriptions per sync filter. Update starts the new filters, stops the ones that are gone and
+ * only restarts a filter that changed, so a refresh does not drop events or ask the relays for the same again.
+ */
+type LiveFilters struct {
+	start   func(ctx context.Context, syncFilter SyncFilter)
+	running map[string]liveFilter
+	wg      sync.WaitGroup
+}
+
+type liveFilter struct {
+	filter SyncFilter
+	cancel context.CancelFunc
+}
+
+// Start runs until its context is done
+func NewLiveFilters(start func(ctx context.Context, syncFilter SyncFilter)) *LiveFilters {
+	return &LiveFilters{start: start, running: make(map[string]liveFilter)}
+}
+
+// Returns how many filters were started or restarted
+func (l *LiveFilters) Update(ctx context.Context, filters []SyncFilter) int {
+	wanted := make(map[string]bool, len(filters))
+	started := 0
+	for _, syncFilter := range filters {
+		wanted[syncFilter.Key] = true
+		if running, ok := l.running[syncFilter.Key]; ok {
+			if SameSyncFilter(running.filter, syncFilter) {
+				continue
+			}
+			running.cancel()
+		}
+
+		filterCtx, cancel := context.WithCancel(ctx)
+		l.running[syncFilter.Key] = liveFilter{filter: syncFilter, cancel: cancel}
+		l.wg.Add(1)
+		go func(syncFilter SyncFilter) {
+			defer l.wg.Done()
+			l.start(filterCtx, syncFilter)
+		}(syncFilter)
+		started++
+	}
+
+	for key, running := range l.running {
+		if !wanted[key] {
+			running.cancel()
+			delete(l.running, key)
+		}
+	}
+	return started
+}
+
+// Stop every subscription and wait until they are done
+func (l *LiveFilters) Stop() {
+	for key, running := range l.running {
+		running.cancel()
+		delete(l.running, key)
+	}
+	l.wg.Wait()
+}
+
+// Returns false when the context is done before the duration has passed
+func waitFor(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
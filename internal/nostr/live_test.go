@@ -0,0 +1,93 @@
+package nostr
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSameSyncFilterIgnoresSince(t *testing.T) {
+	var w Wrapper
+	w.Cfg.PubKey = "self"
+	w.SetSyncConfig(&SyncConfig{ChunkSize: 10})
+
+	before := w.GetSyncFilters([]string{"a"}, []string{})
+	after := w.GetSyncFilters([]string{"a"}, []string{})
+	since := nostr.Timestamp(2000)
+	after[0].Filters[0].Since = &since
+	if !SameSyncFilter(before[0], after[0]) {
+		t.Log("only another since should be the same filter")
+		t.Fail()
+	}
+
+	followed := w.GetSyncFilters([]string{"a", "b"}, []string{})
+	if SameSyncFilter(before[0], followed[0]) {
+		t.Log("a new follow should change the filter")
+		t.Fail()
+	}
+}
+
+func TestLiveFiltersOnlyRestartChanges(t *testing.T) {
+	var mu sync.Mutex
+	starts := make(map[string]int)
+	stopped := make(map[string]int)
+	live := NewLiveFilters(func(ctx context.Context, syncFilter SyncFilter) {
+		mu.Lock()
+		starts[syncFilter.Key]++
+		mu.Unlock()
+		<-ctx.Done()
+		mu.Lock()
+		stopped[syncFilter.Key]++
+		mu.Unlock()
+	})
+
+	var w Wrapper
+	w.Cfg.PubKey = "self"
+	w.SetSyncConfig(&SyncConfig{ChunkSize: 10})
+	ctx := context.Background()
+
+	if started := live.Update(ctx, w.GetSyncFilters([]string{"a"}, []string{})); started != 2 {
+		t.Logf("the follows and mentions filter should be started, got %d", started)
+		t.Fail()
+	}
+	if started := live.Update(ctx, w.GetSyncFilters([]string{"a"}, []string{})); started != 0 {
+		t.Logf("a refresh with the same filters should not subscribe again, got %d", started)
+		t.Fail()
+	}
+	if started := live.Update(ctx, w.GetSyncFilters([]string{"a", "b"}, []string{"note"})); started != 2 {
+		t.Logf("only the changed follows and the new replies filter should be started, got %d", started)
+		t.Fail()
+	}
+	live.Update(ctx, w.GetSyncFilters([]string{"a", "b"}, []string{}))
+
+	// Stopped subscriptions finish in their own goroutine
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		done := stopped[SyncFilterFollows] == 1 && stopped[SyncFilterReplies] == 1
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	live.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if starts[SyncFilterMentions] != 1 || stopped[SyncFilterMentions] != 1 {
+		t.Log("the mentions filter never changed and should run once until stop")
+		t.Fail()
+	}
+	if starts[SyncFilterFollows] != 2 || stopped[SyncFilterFollows] != 2 {
+		t.Log("the follows filter should be restarted once")
+		t.Fail()
+	}
+	if starts[SyncFilterReplies] != 1 || stopped[SyncFilterReplies] != 1 {
+		t.Log("the replies filter should be stopped when it is gone")
+		t.Fail()
+	}
+}
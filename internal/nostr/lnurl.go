@@ -0,0 +1,91 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// How long we remember the key of a lightning address, also when it has none
+const zapperTTL = time.Hour
+
+/**
+ * The nostrPubkey of the LNURL servers of lightning addresses (LUD-16, NIP-57). Every zap receipt is checked with
+ * it, so the answers are kept for a while.
+ */
+type Zappers struct {
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]zapper
+}
+
+type zapper struct {
+	pubkey    string
+	err       error
+	fetchedAt time.Time
+}
+
+func NewZappers() *Zappers {
+	return &Zappers{Client: &http.Client{Timeout: 10 * time.Second}, cache: make(map[string]zapper)}
+}
+
+// The key that signs the zap receipts of the lightning address, name@domain
+func (z *Zappers) Pubkey(ctx context.Context, lud16 string) (string, error) {
+	lud16 = strings.ToLower(strings.TrimSpace(lud16))
+
+	z.mu.Lock()
+	cached, ok := z.cache[lud16]
+	z.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < zapperTTL {
+		return cached.pubkey, cached.err
+	}
+
+	pubkey, err := z.fetch(ctx, lud16)
+	if ctx.Err() != nil {
+		return "", err
+	}
+
+	z.mu.Lock()
+	z.cache[lud16] = zapper{pubkey: pubkey, err: err, fetchedAt: time.Now()}
+	z.mu.Unlock()
+	return pubkey, err
+}
+
+func (z *Zappers) fetch(ctx context.Context, lud16 string) (string, error) {
+	name, domain, ok := strings.Cut(lud16, "@")
+	if !ok || name == "" || domain == "" {
+		return "", fmt.Errorf("invalid lightning address: %s", lud16)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+domain+"/.well-known/lnurlp/"+name, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := z.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("lightning address %s gives status %d", lud16, resp.StatusCode)
+	}
+
+	var pay struct {
+		AllowsNostr bool   `json:"allowsNostr"`
+		NostrPubkey string `json:"nostrPubkey"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pay); err != nil {
+		return "", fmt.Errorf("lightning address %s: %w", lud16, err)
+	}
+	if !pay.AllowsNostr || !nostr.IsValid32ByteHex(pay.NostrPubkey) {
+		return "", fmt.Errorf("lightning address %s does not make zap receipts", lud16)
+	}
+	return pay.NostrPubkey, nil
+}
@@ -0,0 +1,55 @@
+package nostr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestZapperPubkey(t *testing.T) {
+	pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	var requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/.well-known/lnurlp/alice":
+			w.Write([]byte(`{"tag":"payRequest","allowsNostr":true,"nostrPubkey":"` + pubkey + `"}`))
+		case "/.well-known/lnurlp/bob":
+			w.Write([]byte(`{"tag":"payRequest"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	zappers := NewZappers()
+	zappers.Client = server.Client()
+	domain := strings.TrimPrefix(server.URL, "https://")
+	ctx := context.Background()
+
+	if got, err := zappers.Pubkey(ctx, "Alice@"+domain); err != nil || got != pubkey {
+		t.Logf("alice should have the key of the server, got %s %v", got, err)
+		t.Fail()
+	}
+	if _, err := zappers.Pubkey(ctx, "alice@"+domain); err != nil || requests.Load() != 1 {
+		t.Log("the key should be remembered")
+		t.Fail()
+	}
+	if _, err := zappers.Pubkey(ctx, "bob@"+domain); err == nil {
+		t.Log("an address that does not allow nostr has no zapper")
+		t.Fail()
+	}
+	if _, err := zappers.Pubkey(ctx, "carol@"+domain); err == nil {
+		t.Log("an unknown address has no zapper")
+		t.Fail()
+	}
+	if _, err := zappers.Pubkey(ctx, "not an address"); err == nil {
+		t.Log("an invalid address should be an error")
+		t.Fail()
+	}
+}
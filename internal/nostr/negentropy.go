@@ -0,0 +1,290 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"amavis442/nostr-reader/internal/negentropy"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// How long we trust the supported_nips of a relay
+const nipSupportTTL = 24 * time.Hour
+
+// How many missing events we ask for in one request
+const negentropyFetchSize = 500
+
+type nipSupport struct {
+	supported bool
+	checkedAt time.Time
+}
+
+/**
+ * The events a NIP-77 reconciliation got us, and per relay the ids the relay does not have.
+ */
+type NegentropyResult struct {
+	SyncResult
+	Have map[string][]string
+}
+
+/**
+ * Does the relay advertise NIP-77 in its information document. The answer is cached, also when the relay
+ * has no document.
+ */
+func (wrapper *Wrapper) SupportsNegentropy(ctx context.Context, relayUrl string) bool {
+	wrapper.negentropyMu.Lock()
+	support, ok := wrapper.negentropySupport[relayUrl]
+	wrapper.negentropyMu.Unlock()
+	if ok && time.Since(support.checkedAt) < nipSupportTTL {
+		return support.supported
+	}
+
+	info, err := nip11.Fetch(ctx, relayUrl)
+	support = nipSupport{supported: err == nil && slices.Contains(info.SupportedNIPs, 77), checkedAt: time.Now()}
+
+	wrapper.negentropyMu.Lock()
+	defer wrapper.negentropyMu.Unlock()
+	if wrapper.negentropySupport == nil {
+		wrapper.negentropySupport = make(map[string]nipSupport)
+	}
+	wrapper.negentropySupport[relayUrl] = support
+
+	return support.supported
+}
+
+/**
+ * Reconcile our items for the filter with every read relay that supports NIP-77 and get the events we miss.
+ * Every missing event is fetched from one relay. When that relay does not give it, the claim is released and
+ * the other relays that have it are asked after all relays are reconciled.
+ */
+func (wrapper *Wrapper) NegentropySync(ctx context.Context, filter nostr.Filter, items []db.NegentropyItem) NegentropyResult {
+	var mu sync.Mutex
+	var claimed sync.Map
+	found := make(map[string]*db.Event)
+	needs := make(map[string][]string)
+	tried := make(map[string]map[string]bool)
+	result := NegentropyResult{
+		SyncResult: SyncResult{Cursors: make(map[string]db.SyncCursor), Relays: make(map[string]RelayStats)},
+		Have:       make(map[string][]string),
+	}
+
+	wrapper.Do(ctx, db.Relay{Read: true}, func(ctx context.Context, relay *nostr.Relay) bool {
+		if !wrapper.SupportsNegentropy(ctx, relay.URL) {
+			return true
+		}
+		begin := time.Now()
+
+		haveIds, needIds, err := Reconcile(ctx, relay.URL, filter, items)
+		stats := RelayStats{Url: relay.URL, Need: len(needIds), Have: len(haveIds), Complete: err == nil}
+		if err != nil {
+			slog.Info("negentropy failed on: "+relay.URL, "error", err.Error())
+			stats.Error = err.Error()
+		} else {
+			wrapper.RelaySucceeded(relay.URL, 0)
+		}
+
+		// Another relay can already be getting the same event
+		var fetch []string
+		for _, id := range needIds {
+			if _, loaded := claimed.LoadOrStore(id, relay.URL); !loaded {
+				fetch = append(fetch, id)
+			}
+		}
+
+		evs, err := wrapper.fetchIds(ctx, relay, fetch)
+		if err != nil {
+			stats.Complete = false
+			stats.Error = err.Error()
+		}
+		stats.Events = len(evs)
+		stats.DurationMs = time.Since(begin).Milliseconds()
+		slog.Info(fmt.Sprintf("negentropy got %d of %d missing events from: %s", len(evs), len(needIds), relay.URL), "have", len(haveIds))
+
+		got := make(map[string]bool, len(evs))
+		for _, ev := range evs {
+			got[ev.ID] = true
+		}
+		for _, id := range fetch {
+			if !got[id] {
+				claimed.Delete(id) // Free for a relay that is still reconciling
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		addFound(found, relay.URL, evs)
+		needs[relay.URL] = needIds
+		tried[relay.URL] = make(map[string]bool, len(fetch))
+		for _, id := range fetch {
+			tried[relay.URL][id] = true
+		}
+		if len(haveIds) > 0 {
+			result.Have[relay.URL] = haveIds
+		}
+		result.Relays[relay.URL] = stats
+		return true
+	})
+
+	// The claims that were released after the other relays were done
+	relayUrls := make([]string, 0, len(needs))
+	for relayUrl := range needs {
+		relayUrls = append(relayUrls, relayUrl)
+	}
+	sort.Strings(relayUrls)
+	for _, relayUrl := range relayUrls {
+		var retry []string
+		for _, id := range needs[relayUrl] {
+			if _, ok := found[id]; !ok && !tried[relayUrl][id] {
+				retry = append(retry, id)
+			}
+		}
+		if len(retry) == 0 {
+			continue
+		}
+
+		relay, err := wrapper.Relay(ctx, relayUrl)
+		if err != nil {
+			continue
+		}
+		evs, err := wrapper.fetchIds(ctx, relay, retry)
+		stats := result.Relays[relayUrl]
+		stats.Events += len(evs)
+		if err != nil {
+			stats.Complete = false
+			stats.Error = err.Error()
+		}
+		result.Relays[relayUrl] = stats
+		addFound(found, relayUrl, evs)
+		for _, id := range retry {
+			tried[relayUrl][id] = true
+		}
+	}
+
+	for _, ev := range found {
+		result.Events = append(result.Events, ev)
+	}
+	return result
+}
+
+// Get the events by id in chunks, only the ones with a valid signature
+func (wrapper *Wrapper) fetchIds(ctx context.Context, relay *nostr.Relay, ids []string) ([]*nostr.Event, error) {
+	var evs []*nostr.Event
+	var lastErr error
+	for _, chunk := range chunks(ids, negentropyFetchSize) {
+		chunkEvs, _, err := QueryEose(ctx, relay, nostr.Filter{IDs: chunk, Limit: len(chunk)})
+		evs = append(evs, chunkEvs...)
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return wrapper.VerifyEvents(relay.URL, evs), lastErr
+}
+
+func addFound(found map[string]*db.Event, relayUrl string, evs []*nostr.Event) {
+	for _, ev := range evs {
+		if existing, ok := found[ev.ID]; ok {
+			existing.Urls = append(existing.Urls, relayUrl)
+			continue
+		}
+		found[ev.ID] = &db.Event{Event: ev, Urls: []string{relayUrl}}
+	}
+}
+
+/**
+ * Run one NIP-77 reconciliation on its own connection. haveIds are the ids only we have, needIds the ids only the relay has.
+ */
+func Reconcile(ctx context.Context, relayUrl string, filter nostr.Filter, items []db.NegentropyItem) (haveIds []string, needIds []string, err error) {
+	storage := &negentropy.Storage{}
+	for _, item := range items {
+		if err := storage.Insert(item.EventCreatedAt, item.EventId); err != nil {
+			return nil, nil, err
+		}
+	}
+	storage.Seal()
+	neg, err := negentropy.New(storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := nostr.NewConnection(ctx, relayUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	// Reading blocks, closing the connection is the only way to stop it
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	subId := fmt.Sprintf("neg-%d", time.Now().UnixNano())
+	if err := writeJSON(conn, []any{"NEG-OPEN", subId, filter, hex.EncodeToString(neg.Initiate())}); err != nil {
+		return nil, nil, err
+	}
+	defer writeJSON(conn, []any{"NEG-CLOSE", subId})
+
+	for {
+		var buf bytes.Buffer
+		if err := conn.ReadMessage(ctx, &buf); err != nil {
+			return haveIds, needIds, err
+		}
+
+		var envelope []json.RawMessage
+		if err := json.Unmarshal(buf.Bytes(), &envelope); err != nil || len(envelope) < 3 {
+			continue
+		}
+		var label, id, payload string
+		json.Unmarshal(envelope[0], &label)
+		json.Unmarshal(envelope[1], &id)
+		json.Unmarshal(envelope[2], &payload)
+		if id != subId {
+			continue
+		}
+
+		switch label {
+		case "NEG-ERR":
+			return haveIds, needIds, fmt.Errorf("negentropy error: %s", payload)
+		case "NEG-MSG":
+			query, err := hex.DecodeString(payload)
+			if err != nil {
+				return haveIds, needIds, err
+			}
+			next, have, need, err := neg.Reconcile(query)
+			if err != nil {
+				return haveIds, needIds, err
+			}
+			haveIds = append(haveIds, have...)
+			needIds = append(needIds, need...)
+			if next == nil {
+				return haveIds, needIds, nil
+			}
+			if err := writeJSON(conn, []any{"NEG-MSG", subId, hex.EncodeToString(next)}); err != nil {
+				return haveIds, needIds, err
+			}
+		}
+	}
+}
+
+func writeJSON(conn *nostr.Connection, msg []any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(data)
+}
@@ -0,0 +1,122 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"amavis442/nostr-reader/internal/relaytest"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestNegentropySyncGetsOnlyMissingEvents(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	sign := func(i int) *nostr.Event {
+		ev := &nostr.Event{Kind: nostr.KindTextNote, Content: "note " + strconv.Itoa(i), CreatedAt: nostr.Timestamp(1700000000 + i), Tags: nostr.Tags{}}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+
+	var relayEvs []*nostr.Event
+	var items []db.NegentropyItem
+	var wantNeed, wantHave []string
+	for i := 0; i < 300; i++ {
+		ev := sign(i)
+		switch i % 10 {
+		case 0:
+			relayEvs = append(relayEvs, ev)
+			wantNeed = append(wantNeed, ev.ID)
+		case 1:
+			items = append(items, db.NegentropyItem{EventId: ev.ID, EventCreatedAt: int64(ev.CreatedAt)})
+			wantHave = append(wantHave, ev.ID)
+		default:
+			relayEvs = append(relayEvs, ev)
+			items = append(items, db.NegentropyItem{EventId: ev.ID, EventCreatedAt: int64(ev.CreatedAt)})
+		}
+	}
+
+	relayUrl := relaytest.New(t, relayEvs, relaytest.Options{}).Url
+
+	var w Wrapper
+	w.SetConfig(&WrapperConfig{Relays: map[string]db.Relay{relayUrl: {Read: true}}})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result := w.NegentropySync(ctx, nostr.Filter{Kinds: []int{nostr.KindTextNote}, Authors: []string{pubkey}}, items)
+
+	var got []string
+	for _, ev := range result.Events {
+		got = append(got, ev.Event.ID)
+	}
+	sort.Strings(got)
+	sort.Strings(wantNeed)
+	if strings.Join(got, ",") != strings.Join(wantNeed, ",") {
+		t.Logf("got %d events, want only the %d the relay has and we miss", len(got), len(wantNeed))
+		t.Fail()
+	}
+
+	have := result.Have[relayUrl]
+	sort.Strings(have)
+	sort.Strings(wantHave)
+	if strings.Join(have, ",") != strings.Join(wantHave, ",") {
+		t.Logf("relay misses %d events, want %d", len(have), len(wantHave))
+		t.Fail()
+	}
+
+	stats := result.Relays[relayUrl]
+	if !stats.Complete || stats.Need != len(wantNeed) || stats.Events != len(wantNeed) {
+		t.Logf("unexpected relay stats %+v", stats)
+		t.Fail()
+	}
+}
+
+func TestNegentropySyncAsksAnotherRelayWhenAFetchFails(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	var relayEvs []*nostr.Event
+	for i := 0; i < 20; i++ {
+		ev := &nostr.Event{Kind: nostr.KindTextNote, Content: "note " + strconv.Itoa(i), CreatedAt: nostr.Timestamp(1700000000 + i), Tags: nostr.Tags{}}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		relayEvs = append(relayEvs, ev)
+	}
+
+	for name, opts := range map[string][2]relaytest.Options{
+		// The failing relay claims everything, but fails while the other one is still reconciling
+		"while reconciling": {{ReqClosed: true}, {NegDelay: 300 * time.Millisecond}},
+		// The failing relay claims everything, and fails after the other one is done
+		"after reconciling": {{ReqClosed: true, ReqDelay: 500 * time.Millisecond}, {NegDelay: 100 * time.Millisecond}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			failingUrl := relaytest.New(t, relayEvs, opts[0]).Url
+			workingUrl := relaytest.New(t, relayEvs, opts[1]).Url
+
+			var w Wrapper
+			w.SetConfig(&WrapperConfig{Relays: map[string]db.Relay{failingUrl: {Read: true}, workingUrl: {Read: true}}})
+			defer w.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			result := w.NegentropySync(ctx, nostr.Filter{Kinds: []int{nostr.KindTextNote}, Authors: []string{pubkey}}, nil)
+			if len(result.Events) != len(relayEvs) {
+				t.Logf("the events the failing relay did not give should come from the other relay, got %d of %d", len(result.Events), len(relayEvs))
+				t.Fail()
+			}
+			if result.Relays[failingUrl].Complete || result.Relays[failingUrl].Error == "" {
+				t.Log("the failing relay should be marked as not complete")
+				t.Fail()
+			}
+		})
+	}
+}
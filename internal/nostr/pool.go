@@ -0,0 +1,89 @@
+package nostr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// How long we wait for a relay to accept the websocket
+const poolConnectTimeout = 15 * time.Second
+
+/**
+ * Keeps one websocket per relay open, so not every request does its own handshake.
+ * A relay is only connected when it is first used and connected again when the connection dropped.
+ * go-nostr pings the open connections every 29 seconds to keep them alive.
+ */
+type RelayPool struct {
+	mu      sync.Mutex
+	relays  map[string]*nostr.Relay
+	connect map[string]*sync.Mutex
+}
+
+func (pool *RelayPool) urlLock(relayUrl string) *sync.Mutex {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.connect == nil {
+		pool.connect = make(map[string]*sync.Mutex)
+	}
+	l, ok := pool.connect[relayUrl]
+	if !ok {
+		l = &sync.Mutex{}
+		pool.connect[relayUrl] = l
+	}
+	return l
+}
+
+/**
+ * Get the connection to the relay. connected is true when a new connection had to be made.
+ */
+func (pool *RelayPool) Get(ctx context.Context, relayUrl string) (relay *nostr.Relay, connected bool, err error) {
+	l := pool.urlLock(relayUrl)
+	l.Lock()
+	defer l.Unlock()
+
+	pool.mu.Lock()
+	relay, ok := pool.relays[relayUrl]
+	pool.mu.Unlock()
+	if ok && relay.IsConnected() {
+		return relay, false, nil
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, poolConnectTimeout)
+	defer cancel()
+	// We check the signatures ourselves in VerifyEvents, go-nostr would do it one by one in the read loop
+	relay = nostr.NewRelay(context.Background(), relayUrl)
+	relay.AssumeValid = true
+	if err = relay.Connect(connectCtx); err != nil {
+		return nil, false, err
+	}
+
+	pool.mu.Lock()
+	if pool.relays == nil {
+		pool.relays = make(map[string]*nostr.Relay)
+	}
+	pool.relays[relayUrl] = relay
+	pool.mu.Unlock()
+
+	return relay, true, nil
+}
+
+// Close the connections to the relays that are not in keep
+func (pool *RelayPool) Prune(keep map[string]bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for relayUrl, relay := range pool.relays {
+		if !keep[relayUrl] {
+			relay.Close()
+			delete(pool.relays, relayUrl)
+		}
+	}
+}
+
+func (pool *RelayPool) Close() {
+	pool.Prune(nil)
+}
@@ -0,0 +1,103 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"amavis442/nostr-reader/internal/relaytest"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRelayPoolReusesConnections(t *testing.T) {
+	relayUrl := relaytest.New(t, nil, relaytest.Options{}).Url
+	var pool RelayPool
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, connected, err := pool.Get(ctx, relayUrl)
+	if err != nil || !connected {
+		t.Fatal("the first get should connect", err)
+	}
+	second, connected, err := pool.Get(ctx, relayUrl)
+	if err != nil || connected || second != first {
+		t.Log("the second get should give the open connection")
+		t.Fail()
+	}
+
+	first.Close()
+	third, connected, err := pool.Get(ctx, relayUrl)
+	if err != nil || !connected || third == first {
+		t.Log("a dropped connection should be made again")
+		t.Fail()
+	}
+
+	pool.Prune(map[string]bool{})
+	if third.IsConnected() {
+		t.Log("a relay that is not kept should be closed")
+		t.Fail()
+	}
+}
+
+func TestRelayPoolConnectsOnce(t *testing.T) {
+	relayUrl := relaytest.New(t, nil, relaytest.Options{}).Url
+	var pool RelayPool
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	connects := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, connected, err := pool.Get(ctx, relayUrl)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+			}
+			if connected {
+				connects++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if connects != 1 {
+		t.Logf("callers at the same time should share one connection, got %d connects", connects)
+		t.Fail()
+	}
+}
+
+func TestWrapperRelaysAreSafeToUpdate(t *testing.T) {
+	var w Wrapper
+	w.SetConfig(&WrapperConfig{Relays: map[string]db.Relay{"wss://a.example.com": {Read: true}}})
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w.UpdateRelays([]db.Relay{{Url: "wss://b.example.com", Read: true}})
+		}()
+		go func() {
+			defer wg.Done()
+			for range w.GetRelays() {
+			}
+		}()
+	}
+	wg.Wait()
+
+	if relays := w.GetRelays(); len(relays) != 1 || !relays["wss://b.example.com"].Read {
+		t.Logf("the relays should be the updated ones, got %v", relays)
+		t.Fail()
+	}
+}
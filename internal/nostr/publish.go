@@ -0,0 +1,63 @@
+package nostr
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The relays we publish to
+func (wrapper *Wrapper) WriteRelays() []string {
+	relays := make([]string, 0)
+	for relayUrl, v := range wrapper.GetRelays() {
+		if v.Write {
+			relays = append(relays, relayUrl)
+		}
+	}
+	return relays
+}
+
+/**
+ * A relay that answers OK false works fine, it just does not want the event. go-nostr gives the reason
+ * after "msg: ".
+ */
+func isRejection(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "msg: ")
+}
+
+/**
+ * Publish the event to the relays and wait for their OK. The error of a relay holds the reason
+ * it gave when it did not accept the event. Only a connection that fails counts against the health
+ * of the relay, what it does with the event is up to the caller.
+ */
+func (wrapper *Wrapper) PublishTo(ctx context.Context, ev *nostr.Event, relayUrls []string) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(relayUrls))
+
+	for _, relayUrl := range relayUrls {
+		wg.Add(1)
+		go func(relayUrl string) {
+			defer wg.Done()
+
+			relay, err := wrapper.Relay(ctx, relayUrl)
+			if err == nil {
+				err = relay.Publish(ctx, *ev)
+				if err == nil {
+					wrapper.RelaySucceeded(relay.URL, 0)
+				} else if !isRejection(err) {
+					wrapper.RelayFailed(relay.URL, err)
+				}
+			}
+
+			mu.Lock()
+			results[relayUrl] = err
+			mu.Unlock()
+		}(relayUrl)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,282 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// How many pages of filter.Limit events we get from one relay in one sync
+var maxSyncPages = 10
+
+/**
+ * Events of a sync run and until when every relay is synced.
+ * Relays that did not send an EOSE are not in Cursors, so they start from their old cursor next time.
+ */
+type SyncResult struct {
+	Events  []*db.Event
+	Cursors map[string]db.SyncCursor
+	Relays  map[string]RelayStats
+}
+
+// What one relay did in a sync run
+type RelayStats struct {
+	Url        string `json:"url"`
+	Events     int    `json:"events"`
+	DurationMs int64  `json:"duration_ms"`
+	Complete   bool   `json:"complete"`
+	Error      string `json:"error,omitempty"`
+	Need       int    `json:"need,omitempty"` // Negentropy: events only the relay has
+	Have       int    `json:"have,omitempty"` // Negentropy: events only we have
+}
+
+/**
+ * Like GetEvents, but every relay gets its own since from cursors (keyed on relay url).
+ * Relays without a cursor use the since of the filters. When a relay has more than maxSyncPages pages, we keep
+ * what we got and the part we did not reach becomes the gap of the cursor. The next syncs fetch the gap, from
+ * the oldest event we got back to the old since, until it is closed.
+ */
+func (wrapper *Wrapper) GetEventsPerRelay(ctx context.Context, syncFilter SyncFilter, cursors map[string]db.SyncCursor) SyncResult {
+	var m sync.Map
+	var mu sync.Mutex
+	result := SyncResult{Cursors: make(map[string]db.SyncCursor), Relays: make(map[string]RelayStats)}
+
+	wrapper.Do(ctx, db.Relay{Read: true}, func(ctx context.Context, relay *nostr.Relay) bool {
+		start := nostr.Now()
+		begin := time.Now()
+
+		var evs []*nostr.Event
+		var lastErr error
+		answered := true // Every query ended with an EOSE
+		query := func(f nostr.Filter) nostr.Timestamp {
+			pageEvs, complete, until, err := QueryPages(ctx, relay, f, maxSyncPages)
+			evs = append(evs, pageEvs...)
+			if err != nil {
+				lastErr = err
+			}
+			if !complete && until == 0 {
+				answered = false
+			}
+			return until
+		}
+
+		cursor := cursors[relay.URL]
+		next := cursor
+		next.Since = int64(start)
+
+		// The new events, the highest until of the filters that were cut off is where the new gap ends
+		var gapSince int64
+		var gapUntil nostr.Timestamp
+		for _, f := range syncFilter.Filters {
+			if cursor.Since > 0 {
+				ts := nostr.Timestamp(cursor.Since)
+				f.Since = &ts
+			}
+			if f.Since != nil {
+				gapSince = int64(*f.Since)
+			}
+			gapUntil = max(gapUntil, query(f))
+		}
+
+		// The gap of an earlier sync that was cut off
+		if cursor.GapUntil > 0 {
+			var remaining nostr.Timestamp
+			for _, f := range syncFilter.Filters {
+				f.Since = nil
+				if cursor.GapSince > 0 {
+					ts := nostr.Timestamp(cursor.GapSince)
+					f.Since = &ts
+				}
+				until := nostr.Timestamp(cursor.GapUntil)
+				f.Until = &until
+				remaining = max(remaining, query(f))
+			}
+			next.GapUntil = int64(remaining)
+			if remaining == 0 {
+				next.GapSince = 0
+			}
+		}
+		// A gap that is still open is older than the new one, so they become one
+		if gapUntil > 0 {
+			if next.GapUntil == 0 {
+				next.GapSince = gapSince
+			}
+			next.GapUntil = int64(gapUntil)
+		}
+
+		complete := answered && next.GapUntil == 0
+		evs = wrapper.VerifyEvents(relay.URL, evs)
+		slog.Info(fmt.Sprintf("synced %d events from: %s", len(evs), relay.URL), "filter", syncFilter.Key, "complete", complete)
+
+		mu.Lock()
+		mergeEvents(&m, relay.URL, evs)
+		mu.Unlock()
+
+		stats := RelayStats{
+			Url:        relay.URL,
+			Events:     len(evs),
+			DurationMs: time.Since(begin).Milliseconds(),
+			Complete:   complete,
+		}
+		if lastErr != nil {
+			stats.Error = lastErr.Error()
+		}
+
+		if answered {
+			wrapper.RelaySucceeded(relay.URL, 0)
+		}
+
+		mu.Lock()
+		if answered {
+			result.Cursors[relay.URL] = next
+		}
+		result.Relays[relay.URL] = stats
+		mu.Unlock()
+		return true
+	})
+
+	result.Events = mergedEvents(&m)
+	return result
+}
+
+/**
+ * One page of filter from every read relay, for walking back in time. Complete is only true for relays that
+ * ended with an EOSE, so the caller can tell a relay that has nothing more from one that did not answer.
+ */
+func (wrapper *Wrapper) GetPage(ctx context.Context, filter nostr.Filter) SyncResult {
+	var m sync.Map
+	var mu sync.Mutex
+	result := SyncResult{Cursors: make(map[string]db.SyncCursor), Relays: make(map[string]RelayStats)}
+
+	wrapper.Do(ctx, db.Relay{Read: true}, func(ctx context.Context, relay *nostr.Relay) bool {
+		begin := time.Now()
+		evs, eose, err := QueryEose(ctx, relay, filter)
+		evs = wrapper.VerifyEvents(relay.URL, evs)
+
+		stats := RelayStats{
+			Url:        relay.URL,
+			Events:     len(evs),
+			DurationMs: time.Since(begin).Milliseconds(),
+			Complete:   eose && err == nil,
+		}
+		if err != nil {
+			stats.Error = err.Error()
+		}
+		if stats.Complete {
+			wrapper.RelaySucceeded(relay.URL, 0)
+		}
+
+		mu.Lock()
+		mergeEvents(&m, relay.URL, evs)
+		result.Relays[relay.URL] = stats
+		mu.Unlock()
+		return true
+	})
+
+	result.Events = mergedEvents(&m)
+	return result
+}
+
+// Every event once, with all the relays we got it from
+func mergeEvents(m *sync.Map, relayUrl string, evs []*nostr.Event) {
+	for _, ev := range evs {
+		if resultEv, ok := m.Load(ev.ID); ok {
+			existingEv := resultEv.(*db.Event)
+			existingEv.Urls = append(existingEv.Urls, relayUrl)
+			continue
+		}
+		m.Store(ev.ID, &db.Event{Event: ev, Urls: []string{relayUrl}})
+	}
+}
+
+func mergedEvents(m *sync.Map) []*db.Event {
+	var evs []*db.Event
+	m.Range(func(k, v any) bool {
+		event := v.(*db.Event)
+		if event.Event.Kind == nostr.KindTextNote && len(event.Event.Content) == 0 {
+			return true
+		}
+		evs = append(evs, event)
+		return true
+	})
+	return evs
+}
+
+/**
+ * Relays give the newest events first, so when a page is full we ask for the older ones with until.
+ * complete is false when the relay did not answer with an EOSE or when we could not get everything since
+ * filter.Since. When we stopped after maxPages, until is the oldest event we got and the events between
+ * filter.Since and until are still missing. Otherwise until is 0 and the caller should not move its cursor,
+ * or the events we did not get are lost.
+ */
+func QueryPages(ctx context.Context, relay *nostr.Relay, filter nostr.Filter, maxPages int) (evs []*nostr.Event, complete bool, until nostr.Timestamp, err error) {
+	for page := 0; page < maxPages; page++ {
+		pageEvs, eose, err := QueryEose(ctx, relay, filter)
+		evs = append(evs, pageEvs...)
+		if err != nil || !eose {
+			return evs, false, 0, err
+		}
+
+		if filter.Limit == 0 || len(pageEvs) < filter.Limit {
+			return evs, true, 0, nil
+		}
+
+		oldest := pageEvs[0].CreatedAt
+		for _, ev := range pageEvs {
+			if ev.CreatedAt < oldest {
+				oldest = ev.CreatedAt
+			}
+		}
+		if filter.Since != nil && oldest <= *filter.Since {
+			return evs, true, 0, nil
+		}
+		if filter.Until != nil && oldest >= *filter.Until {
+			// Full page with the same timestamp, we can not go further back
+			slog.Warn("Too many events with the same timestamp, try again next sync", "relay", relay.URL, "created_at", oldest)
+			return evs, false, 0, nil
+		}
+		filter.Until = &oldest
+	}
+
+	if filter.Until == nil {
+		return evs, false, 0, nil
+	}
+	slog.Warn("Too many events for one sync, the rest comes next sync", "relay", relay.URL, "pages", maxPages, "until", *filter.Until)
+	return evs, false, *filter.Until, nil
+}
+
+/**
+ * Same as relay.QuerySync, but it also tells us if the relay ended with an EOSE or that we just ran out of time.
+ */
+func QueryEose(ctx context.Context, relay *nostr.Relay, filter nostr.Filter) ([]*nostr.Event, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return nil, false, err
+	}
+	defer sub.Unsub()
+
+	var evs []*nostr.Event
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok || ev == nil {
+				return evs, false, nil
+			}
+			evs = append(evs, ev)
+		case <-sub.EndOfStoredEvents:
+			return evs, true, nil
+		case reason := <-sub.ClosedReason:
+			return evs, false, fmt.Errorf("subscription closed by %s: %s", relay.URL, reason)
+		case <-ctx.Done():
+			return evs, false, nil
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"amavis442/nostr-reader/internal/relaytest"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func queryRelay(t *testing.T, evs []*nostr.Event) (*nostr.Relay, string) {
+	relayUrl := relaytest.New(t, evs, relaytest.Options{}).Url
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	relay, err := nostr.RelayConnect(ctx, relayUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { relay.Close() })
+	return relay, relayUrl
+}
+
+func signedNotes(t *testing.T, n int, createdAt func(i int) int64) []*nostr.Event {
+	sk := nostr.GeneratePrivateKey()
+	evs := make([]*nostr.Event, 0, n)
+	for i := 0; i < n; i++ {
+		ev := &nostr.Event{Kind: nostr.KindTextNote, Content: "note " + strconv.Itoa(i), CreatedAt: nostr.Timestamp(createdAt(i)), Tags: nostr.Tags{}}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func uniqueIds(evs []*nostr.Event) map[string]bool {
+	ids := make(map[string]bool)
+	for _, ev := range evs {
+		ids[ev.ID] = true
+	}
+	return ids
+}
+
+func TestQueryPagesGetsOlderPages(t *testing.T) {
+	relay, _ := queryRelay(t, signedNotes(t, 25, func(i int) int64 { return int64(1700000000 + i) }))
+
+	since := nostr.Timestamp(1700000000)
+	evs, complete, _, err := QueryPages(context.Background(), relay, nostr.Filter{Kinds: []int{nostr.KindTextNote}, Since: &since, Limit: 10}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Log("all pages fit, so the query should be complete")
+		t.Fail()
+	}
+	if len(uniqueIds(evs)) != 25 {
+		t.Logf("paging with until should give all 25 notes, got %d", len(uniqueIds(evs)))
+		t.Fail()
+	}
+}
+
+func TestQueryPagesIsNotCompleteWhenTruncated(t *testing.T) {
+	relay, _ := queryRelay(t, signedNotes(t, 25, func(i int) int64 { return int64(1700000000 + i) }))
+
+	evs, complete, until, _ := QueryPages(context.Background(), relay, nostr.Filter{Kinds: []int{nostr.KindTextNote}, Limit: 10}, 2)
+	if complete {
+		t.Log("we stopped after 2 pages, the older notes are not there so the query is not complete")
+		t.Fail()
+	}
+	if until != 1700000000+6 {
+		t.Logf("until should be the oldest note we got, got %d", until)
+		t.Fail()
+	}
+	if len(uniqueIds(evs)) >= 25 {
+		t.Log("2 pages of 10 can not have all notes")
+		t.Fail()
+	}
+}
+
+func TestQueryPagesIsNotCompleteOnFullPageWithOneTimestamp(t *testing.T) {
+	relay, _ := queryRelay(t, signedNotes(t, 15, func(i int) int64 { return 1700000000 }))
+
+	_, complete, until, _ := QueryPages(context.Background(), relay, nostr.Filter{Kinds: []int{nostr.KindTextNote}, Limit: 10}, 10)
+	if complete {
+		t.Log("a full page with one timestamp can not be paged, so the query is not complete")
+		t.Fail()
+	}
+	if until != 0 {
+		t.Log("there is no gap we can close later, so there is no until")
+		t.Fail()
+	}
+}
+
+func TestGetEventsPerRelayKeepsCursorWhenTruncated(t *testing.T) {
+	now := time.Now().Unix()
+	_, relayUrl := queryRelay(t, signedNotes(t, 15, func(i int) int64 { return now - 100 }))
+
+	var w Wrapper
+	w.SetConfig(&WrapperConfig{Relays: map[string]db.Relay{relayUrl: {Read: true}}})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	syncFilter := SyncFilter{Key: SyncFilterGlobal, Filters: nostr.Filters{{Kinds: []int{nostr.KindTextNote}, Limit: 10}}}
+	result := w.GetEventsPerRelay(ctx, syncFilter, map[string]db.SyncCursor{relayUrl: {Since: now - 1000}})
+	if _, ok := result.Cursors[relayUrl]; ok {
+		t.Log("the cursor should not move when we did not get everything")
+		t.Fail()
+	}
+	stats, ok := result.Relays[relayUrl]
+	if !ok {
+		t.Log("the relay should be synced")
+		t.FailNow()
+	}
+	if stats.Complete {
+		t.Log("the relay stats should say the sync was not complete")
+		t.Fail()
+	}
+}
+
+func TestGetEventsPerRelayClosesTheGapOnTheNextRuns(t *testing.T) {
+	defer func(pages int) { maxSyncPages = pages }(maxSyncPages)
+	maxSyncPages = 1
+
+	now := time.Now().Unix()
+	notes := signedNotes(t, 25, func(i int) int64 { return now - 100 - int64(i)*60 })
+	_, relayUrl := queryRelay(t, notes)
+
+	var w Wrapper
+	w.SetConfig(&WrapperConfig{Relays: map[string]db.Relay{relayUrl: {Read: true}}})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	syncFilter := SyncFilter{Key: SyncFilterGlobal, Filters: nostr.Filters{{Kinds: []int{nostr.KindTextNote}, Limit: 10}}}
+	cursors := map[string]db.SyncCursor{relayUrl: {Since: now - 7200}}
+	got := make(map[string]bool)
+	for run := 0; run < 5; run++ {
+		result := w.GetEventsPerRelay(ctx, syncFilter, cursors)
+		for _, ev := range result.Events {
+			got[ev.Event.ID] = true
+		}
+		cursor, ok := result.Cursors[relayUrl]
+		if !ok {
+			t.Fatal("a relay that answered should get a cursor")
+		}
+		if run == 0 && (cursor.GapSince != now-7200 || cursor.GapUntil == 0 || cursor.Since < now) {
+			t.Logf("the first run should move the cursor and keep what it did not get as the gap, got %+v", cursor)
+			t.Fail()
+		}
+		cursors[relayUrl] = cursor
+		if cursor.GapUntil == 0 {
+			if !result.Relays[relayUrl].Complete {
+				t.Log("the relay is complete when the gap is closed")
+				t.Fail()
+			}
+			break
+		}
+	}
+
+	if cursors[relayUrl].GapUntil != 0 {
+		t.Logf("the gap should be closed after a few runs, got %+v", cursors[relayUrl])
+		t.Fail()
+	}
+	if len(got) != len(notes) {
+		t.Logf("all notes should be synced, got %d of %d", len(got), len(notes))
+		t.Fail()
+	}
+}
@@ -0,0 +1,102 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+/**
+ * Get events by id from our read relays. The ones we can not find there are asked from the
+ * hint relays (from the e tags or where the referencing note was seen) we do not have in our config.
+ */
+func (wrapper *Wrapper) GetEventsByIds(ctx context.Context, ids []string, hints []string) []*db.Event {
+	evs := wrapper.GetEvents(ctx, nostr.Filter{IDs: ids})
+
+	found := make(map[string]bool)
+	for _, ev := range evs {
+		found[ev.Event.ID] = true
+	}
+	var notFound []string
+	for _, id := range ids {
+		if !found[id] {
+			notFound = append(notFound, id)
+		}
+	}
+	if len(notFound) == 0 {
+		return evs
+	}
+
+	for _, ev := range wrapper.QueryHintRelays(ctx, nostr.Filter{IDs: notFound}, hints) {
+		if found[ev.Event.ID] {
+			continue
+		}
+		found[ev.Event.ID] = true
+		evs = append(evs, ev)
+	}
+
+	return evs
+}
+
+/**
+ * Ask the hint relays we do not have in our config, they only get a short time to answer.
+ */
+func (wrapper *Wrapper) QueryHintRelays(ctx context.Context, filter nostr.Filter, hints []string) []*db.Event {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	hintCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	found := make(map[string]*db.Event)
+	configured := wrapper.GetRelays()
+	seen := make(map[string]bool)
+	for _, hint := range hints {
+		relayUrl := nostr.NormalizeURL(hint)
+		if relayUrl == "" || seen[relayUrl] {
+			continue
+		}
+		seen[relayUrl] = true
+		if _, ok := configured[relayUrl]; ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(relayUrl string) {
+			defer wg.Done()
+
+			relay, err := nostr.RelayConnect(hintCtx, relayUrl)
+			if err != nil {
+				slog.Info("can't connect to hint relay: " + relayUrl)
+				return
+			}
+			defer relay.Close()
+
+			hintEvs, err := relay.QuerySync(hintCtx, filter)
+			if err != nil {
+				return
+			}
+			hintEvs = wrapper.VerifyEvents(relay.URL, hintEvs)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, ev := range hintEvs {
+				if existing, ok := found[ev.ID]; ok {
+					existing.Urls = append(existing.Urls, relay.URL)
+					continue
+				}
+				found[ev.ID] = &db.Event{Event: ev, Urls: []string{relay.URL}}
+			}
+		}(relayUrl)
+	}
+	wg.Wait()
+
+	evs := make([]*db.Event, 0, len(found))
+	for _, ev := range found {
+		evs = append(evs, ev)
+	}
+	return evs
+}
@@ -0,0 +1,36 @@
+package nostr
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+/**
+ * The notes that reference one of the ids in an e tag, from our read relays and the hint relays.
+ */
+func (wrapper *Wrapper) GetReplies(ctx context.Context, ids []string, hints []string) []*db.Event {
+	found := make(map[string]bool)
+	var evs []*db.Event
+	for _, chunk := range chunks(ids, wrapper.Sync.ChunkSize) {
+		filter := nostr.Filter{
+			Kinds: []int{nostr.KindTextNote},
+			Tags:  nostr.TagMap{"e": chunk},
+		}
+
+		chunkEvs := wrapper.GetEvents(ctx, filter)
+		if len(hints) > 0 {
+			chunkEvs = append(chunkEvs, wrapper.QueryHintRelays(ctx, filter, hints)...)
+		}
+		for _, ev := range chunkEvs {
+			if found[ev.Event.ID] || ev.Event.Kind != nostr.KindTextNote {
+				continue
+			}
+			found[ev.Event.ID] = true
+			evs = append(evs, ev)
+		}
+	}
+
+	return evs
+}
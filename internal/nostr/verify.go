@@ -0,0 +1,67 @@
+package nostr
+
+import (
+	"log/slog"
+	"runtime"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Checking signatures is cpu work, so no more checks at the same time than we have cpus, for all relays together
+var verifySlots = make(chan struct{}, runtime.NumCPU())
+
+/**
+ * The id must be the hash of the event and the signature must be of the author, otherwise a relay can
+ * make up notes of the people we follow or replace a note by using its id.
+ */
+func VerifyEvent(ev *nostr.Event) bool {
+	if ev == nil || ev.GetID() != ev.ID {
+		return false
+	}
+	ok, err := ev.CheckSignature()
+	return err == nil && ok
+}
+
+/**
+ * Returns only the valid events of a relay, in the same order. The rejected ones are counted in the relay health.
+ */
+func (wrapper *Wrapper) VerifyEvents(relayUrl string, evs []*nostr.Event) []*nostr.Event {
+	if len(evs) == 0 {
+		return evs
+	}
+
+	valid := make([]bool, len(evs))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(cap(verifySlots), len(evs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				verifySlots <- struct{}{}
+				valid[i] = VerifyEvent(evs[i])
+				<-verifySlots
+			}
+		}()
+	}
+	for i := range evs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := make([]*nostr.Event, 0, len(evs))
+	for i, ev := range evs {
+		if valid[i] {
+			result = append(result, ev)
+		}
+	}
+
+	if rejected := len(evs) - len(result); rejected > 0 {
+		slog.Warn("Rejected events with a bad id or signature", "relay", relayUrl, "count", rejected)
+		wrapper.RelayRejected(relayUrl, rejected)
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package nostr
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestVerifyEventsRejectsForgedEvents(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	sign := func(content string) *nostr.Event {
+		ev := &nostr.Event{Kind: nostr.KindTextNote, Content: content, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+
+	good := sign("hello")
+	forgedContent := sign("hello")
+	forgedContent.Content = "goodbye"
+	forgedId := sign("hello again")
+	forgedId.ID = good.ID
+
+	var w Wrapper
+	result := w.VerifyEvents("wss://relay.example.com", []*nostr.Event{good, forgedContent, forgedId})
+	if len(result) != 1 || result[0] != good {
+		t.Log("only the correctly signed event should be kept")
+		t.Fail()
+	}
+
+	health := w.GetRelayHealth()
+	if len(health) != 1 || health[0].Rejected != 2 {
+		t.Log("2 rejected events should be counted for the relay")
+		t.Fail()
+	}
+}
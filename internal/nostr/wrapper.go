@@ -2,7 +2,6 @@ package nostr
 
 import (
 	"amavis442/nostr-reader/internal/db"
-	"amavis442/nostr-reader/internal/logger"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,7 +9,6 @@ import (
 	"log"
 	"log/slog"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -31,11 +29,64 @@ type RelayUrl string
 var KeyUrl RelayUrl = "relayUrl"
 
 type Wrapper struct {
-	Cfg WrapperConfig
+	Cfg  WrapperConfig
+	Sync SyncConfig
+
+	healthMu sync.Mutex
+	health   map[string]*db.RelayHealth
+
+	relaysMu sync.RWMutex
+	pool     RelayPool
+
+	negentropyMu      sync.Mutex
+	negentropySupport map[string]nipSupport
 }
 
 func (wrapper *Wrapper) SetConfig(cfg *WrapperConfig) {
+	wrapper.relaysMu.Lock()
+	defer wrapper.relaysMu.Unlock()
+
 	wrapper.Cfg = *cfg
+	// go-nostr normalizes relay.URL, so the config has to use the same key for the health, pool and cursors
+	wrapper.Cfg.Relays = make(map[string]db.Relay, len(cfg.Relays))
+	for relayUrl, v := range cfg.Relays {
+		wrapper.Cfg.Relays[nostr.NormalizeURL(relayUrl)] = v
+	}
+}
+
+// A copy of the relay config, safe to range over while the relays are updated
+func (wrapper *Wrapper) GetRelays() map[string]db.Relay {
+	wrapper.relaysMu.RLock()
+	defer wrapper.relaysMu.RUnlock()
+
+	relays := make(map[string]db.Relay, len(wrapper.Cfg.Relays))
+	for relayUrl, v := range wrapper.Cfg.Relays {
+		relays[relayUrl] = v
+	}
+	return relays
+}
+
+/**
+ * Get the pooled connection to a relay and keep track of its health. A new connection counts as a success,
+ * the queries and publishes on it count too, so a relay that had failures is healthy again once it answers.
+ */
+func (wrapper *Wrapper) Relay(ctx context.Context, relayUrl string) (*nostr.Relay, error) {
+	relayUrl = nostr.NormalizeURL(relayUrl)
+	start := time.Now()
+	relay, connected, err := wrapper.pool.Get(ctx, relayUrl)
+	if err != nil {
+		wrapper.RelayFailed(relayUrl, err)
+		return nil, err
+	}
+	if connected {
+		wrapper.RelaySucceeded(relayUrl, time.Since(start))
+	}
+	return relay, nil
+}
+
+// Close all the relay connections
+func (wrapper *Wrapper) Close() {
+	wrapper.pool.Close()
 }
 
 func (wrapper *Wrapper) GetConfig() *WrapperConfig {
@@ -51,7 +102,7 @@ func (wrapper *Wrapper) GetConfig() *WrapperConfig {
  */
 func (wrapper *Wrapper) Do(ctx context.Context, r db.Relay, f func(context.Context, *nostr.Relay) bool) {
 	var wg sync.WaitGroup
-	for relayUrl, v := range wrapper.Cfg.Relays {
+	for relayUrl, v := range wrapper.GetRelays() {
 		if r.Write && !v.Write {
 			continue
 		}
@@ -61,22 +112,23 @@ func (wrapper *Wrapper) Do(ctx context.Context, r db.Relay, f func(context.Conte
 		if !r.Write && !v.Read {
 			continue
 		}
+		if !wrapper.RelayAvailable(relayUrl) {
+			continue
+		}
 		wg.Add(1)
 
 		go func(wg *sync.WaitGroup, relayUrl string, v db.Relay) {
 			defer wg.Done()
 
-			relay, err := nostr.RelayConnect(ctx, relayUrl)
+			relay, err := wrapper.Relay(ctx, relayUrl)
 			if err != nil {
-				slog.Info("can't connect to relay: " + relay.URL)
+				slog.Info("can't connect to relay: "+relayUrl, "error", err.Error())
 				return
 			}
 
 			if !f(ctx, relay) {
 				ctx.Done()
 			}
-
-			relay.Close()
 		}(&wg, relayUrl, v)
 	}
 	wg.Wait()
@@ -129,9 +181,15 @@ func (wrapper *Wrapper) DoReply(content string, replyEv db.Event) (db.Event, err
 
 	var hasRootTag bool = false
 
+	// Where other clients can find the note we reply to
+	var relayHint string
+	if len(replyEv.Urls) > 0 {
+		relayHint = replyEv.Urls[0]
+	}
+
 	// We reply to the root of the Thread
 	if len(replyETags) == 0 {
-		ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"e", replyEv.Event.ID, "", "root"})
+		ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"e", replyEv.Event.ID, relayHint, "root"})
 		ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"p", replyEv.Event.PubKey})
 	}
 
@@ -155,7 +213,7 @@ func (wrapper *Wrapper) DoReply(content string, replyEv db.Event) (db.Event, err
 			hasRootTag = true
 		}
 		if hasRootTag && len(replyETags) > 0 {
-			ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"e", replyEv.Event.ID, "", "reply"})
+			ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"e", replyEv.Event.ID, relayHint, "reply"})
 		}
 		ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"p", replyEv.Event.PubKey})
 	}
@@ -167,26 +225,37 @@ func (wrapper *Wrapper) DoReply(content string, replyEv db.Event) (db.Event, err
 	return ev, nil
 }
 
-func (wrapper *Wrapper) BroadCast(ctx context.Context, ev db.Event) (bool, error) {
-	var success atomic.Int64
-	wrapper.Do(ctx, db.Relay{Write: true}, func(ctx context.Context, relay *nostr.Relay) bool {
-		err := relay.Publish(ctx, *ev.Event)
-		if err != nil {
-			slog.Error(logger.GetCallerInfo(1), "broadcast", relay.URL, "error", err.Error())
-			wrapper.Cfg.Relays[relay.URL] = db.Relay{Write: false}
-		} else {
-			success.Add(1)
-		}
-		slog.Info(logger.GetCallerInfo(1), "broadcast", relay.URL, "data", ev.Event)
-		return true
-	})
+/*
+ * Creates a report (NIP-56) about a pubkey or, when eventId is set, about a note of that pubkey
+ */
+func (wrapper *Wrapper) DoReport(pubkey string, eventId string, reportType string, reason string) (db.Event, error) {
+	if len(pubkey) != 64 {
+		return db.Event{}, errors.New("report needs a valid pubkey")
+	}
+	var err error
+	ev := db.Event{}
+	ev.Event = &nostr.Event{}
+	ev.Event.Tags = nostr.Tags{}
+	ev.Event.PubKey, err = nostr.GetPublicKey(wrapper.Cfg.PrivateKey)
+	if err != nil {
+		return db.Event{}, err
+	}
+	ev.Event.CreatedAt = nostr.Now()
+	ev.Event.Kind = db.KindReporting
+	ev.Event.Content = reason
+
+	if eventId != "" {
+		ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"e", eventId, reportType})
+		ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"p", pubkey})
+	} else {
+		ev.Event.Tags = ev.Event.Tags.AppendUnique(nostr.Tag{"p", pubkey, reportType})
+	}
 
-	if success.Load() == 0 {
-		slog.Warn(logger.GetCallerInfo(1) + " cannot broadcast")
-		return false, errors.New("cannot Broadcast")
+	if err := ev.Event.Sign(wrapper.Cfg.PrivateKey); err != nil {
+		return db.Event{}, err
 	}
 
-	return true, nil
+	return ev, nil
 }
 
 /**
@@ -204,6 +273,8 @@ func (wrapper *Wrapper) GetEvents(ctx context.Context, filter nostr.Filter) []*d
 		if err != nil {
 			return true
 		}
+		wrapper.RelaySucceeded(relay.URL, 0)
+		evs = wrapper.VerifyEvents(relay.URL, evs)
 		for _, ev := range evs {
 			resultEv, ok := m.Load(ev.ID)
 			if !ok {
@@ -242,31 +313,6 @@ func (wrapper *Wrapper) GetEvents(ctx context.Context, filter nostr.Filter) []*d
 	return evs
 }
 
-/**
- * Before we try to get events, first get the last timestamp so we do not query all the events all the time but only the lastests.
- * We do not want to spam the relays when we just synced, so wait 60 seconds before we accept a new sync
- */
-func (wrapper *Wrapper) GetEventData(createdAt int64, withOffset bool) nostr.Filter {
-	var createdAtOffset int64 = time.Now().Unix() - 60
-	if createdAt < 1 {
-		createdAt = createdAtOffset
-	}
-	if createdAt > createdAtOffset && withOffset {
-		log.Printf("Time lapse is to short for getting new data %d %d", createdAt, createdAtOffset)
-		return nostr.Filter{}
-	}
-	var timeStamp nostr.Timestamp = nostr.Timestamp(createdAt + 1)
-
-	filter := nostr.Filter{
-		Kinds: []int{nostr.KindTextNote, nostr.KindReaction, nostr.KindArticle, nostr.KindDeletion, nostr.KindProfileMetadata, nostr.KindRecommendServer, 2003, 2004, 9802},
-		Since: &timeStamp,
-		Limit: 1000,
-	}
-
-	log.Println(filter)
-	return filter
-}
-
 /**
  * Get the metadata of a bunch of Pubkeys and store them.
  */
@@ -280,29 +326,31 @@ func (wrapper *Wrapper) UpdateProfiles(ctx context.Context, pubkeys []string) []
 		Authors: pubkeys,
 	}
 
-	var m sync.Map
+	var mu sync.Mutex
+	m := make(map[string]*nostr.Event)
 	wrapper.Do(ctx, db.Relay{Read: true}, func(ctx context.Context, relay *nostr.Relay) bool {
 		evs, err := relay.QuerySync(ctx, filter)
 		if err != nil {
 			return false
 		}
+		wrapper.RelaySucceeded(relay.URL, 0)
+		evs = wrapper.VerifyEvents(relay.URL, evs)
+
+		mu.Lock()
+		defer mu.Unlock()
 		for _, ev := range evs {
-			if _, ok := m.Load(ev.ID); !ok {
-				m.LoadOrStore(ev.ID, ev)
+			// Only the newest profile of a pubkey counts
+			if existing, ok := m[ev.PubKey]; !ok || ev.CreatedAt > existing.CreatedAt {
+				m[ev.PubKey] = ev
 			}
 		}
 		return true
 	})
 
 	var evs []*db.Event
-	m.Range(func(k, v any) bool {
-		var event db.Event
-		event.Event = &nostr.Event{}
-		event.Event = v.(*nostr.Event)
-
-		evs = append(evs, &event)
-		return true
-	})
+	for _, ev := range m {
+		evs = append(evs, &db.Event{Event: ev})
+	}
 
 	return evs
 }
@@ -320,6 +368,8 @@ func (wrapper *Wrapper) SearchProfileByNpub(ctx context.Context, npub string) (d
 		if err != nil {
 			return false
 		}
+		wrapper.RelaySucceeded(relay.URL, 0)
+		evs = wrapper.VerifyEvents(relay.URL, evs)
 		for _, ev := range evs {
 			if _, ok := m.Load(ev.PubKey); !ok {
 				m.LoadOrStore(ev.PubKey, ev)
@@ -353,6 +403,8 @@ func (wrapper *Wrapper) GetMetaData(ctx context.Context) (db.Event, error) {
 		if err != nil {
 			return false
 		}
+		wrapper.RelaySucceeded(relay.URL, 0)
+		evs = wrapper.VerifyEvents(relay.URL, evs)
 		for _, ev := range evs {
 			if _, ok := m.Load(ev.PubKey); !ok {
 				m.LoadOrStore(ev.PubKey, ev)
@@ -382,7 +434,10 @@ type NostrProfile struct {
 	DisplayName string
 }
 
-func (wrapper *Wrapper) DoPublishMetaData(ctx context.Context, user *db.Profile) error {
+/**
+ * Creates the signed kind 0 event of your profile
+ */
+func (wrapper *Wrapper) DoMetaData(user *db.Profile) (db.Event, error) {
 	var err error
 	ev := nostr.Event{}
 	ev.Tags = nostr.Tags{}
@@ -390,7 +445,7 @@ func (wrapper *Wrapper) DoPublishMetaData(ctx context.Context, user *db.Profile)
 	ev.PubKey, err = nostr.GetPublicKey(wrapper.Cfg.PrivateKey)
 	if err != nil {
 		log.Println(err)
-		return err
+		return db.Event{}, err
 	}
 
 	profile := &NostrProfile{
@@ -409,38 +464,29 @@ func (wrapper *Wrapper) DoPublishMetaData(ctx context.Context, user *db.Profile)
 	c, err := json.Marshal(profile)
 	if err != nil {
 		log.Println(err)
-		return err
+		return db.Event{}, err
 	}
 	ev.Content = string(c)
 	if err := ev.Sign(wrapper.Cfg.PrivateKey); err != nil {
-		return err
-	}
-
-	fmt.Println(ev)
-
-	var success atomic.Int64
-	wrapper.Do(ctx, db.Relay{Write: true}, func(ctx context.Context, relay *nostr.Relay) bool {
-		err := relay.Publish(ctx, ev)
-		if err != nil {
-			slog.Error(relay.URL, "error", err)
-		} else {
-			slog.Info("Publish to:", "ralay", relay.URL)
-			success.Add(1)
-		}
-		return true
-	})
-
-	if success.Load() == 0 {
-		return errors.New("cannot send profile metadata")
+		return db.Event{}, err
 	}
 
-	return nil
+	return db.Event{Event: &ev}, nil
 }
 
 func (wrapper *Wrapper) UpdateRelays(relays []db.Relay) {
+	wrapper.relaysMu.Lock()
+	defer wrapper.relaysMu.Unlock()
+
 	wrapper.Cfg.Relays = make(map[string]db.Relay, 0)
+	keep := make(map[string]bool, len(relays))
 
 	for _, relay := range relays {
-		wrapper.Cfg.Relays[relay.Url] = db.Relay{Read: relay.Read, Write: relay.Write, Search: relay.Search}
+		relayUrl := nostr.NormalizeURL(relay.Url)
+		wrapper.Cfg.Relays[relayUrl] = db.Relay{Read: relay.Read, Write: relay.Write, Search: relay.Search}
+		keep[relayUrl] = true
 	}
+
+	// Relays that are removed do not need their connection anymore
+	wrapper.pool.Prune(keep)
 }
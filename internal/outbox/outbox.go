@@ -0,0 +1,173 @@
+package outbox
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	maxAttempts = 10
+	minBackoff  = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+// A relay that answers with one of these will not change its mind, so we do not try again
+var permanentReasons = []string{"blocked:", "invalid:", "pow:", "restricted:"}
+
+var ErrNotDelivered = errors.New("cannot broadcast, no relay accepted the event")
+
+/**
+ * Every event we publish goes through the outbox, so relays that are down get it later.
+ */
+type Outbox struct {
+	Db    db.Store
+	Nostr *wrapper.Wrapper
+}
+
+func NewOutbox(st db.Store, nostrWrapper *wrapper.Wrapper) *Outbox {
+	return &Outbox{Db: st, Nostr: nostrWrapper}
+}
+
+/**
+ * Store the signed event and send it to all write relays. Returns ErrNotDelivered when no relay took it,
+ * the event stays in the outbox and is tried again later.
+ */
+func (o *Outbox) Publish(ctx context.Context, ev db.Event) ([]db.OutboxDelivery, error) {
+	if err := o.Db.CreateOutbox(ctx, ev.Event, o.Nostr.WriteRelays()); err != nil {
+		return nil, err
+	}
+	return o.deliverPending(ctx, ev.Event)
+}
+
+/**
+ * Send the event again to the relays that did not take it yet.
+ */
+func (o *Outbox) Rebroadcast(ctx context.Context, eventId string) ([]db.OutboxDelivery, error) {
+	ev, err := o.Db.GetOutboxEvent(ctx, eventId)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.Db.ResetDeliveries(ctx, eventId, o.Nostr.WriteRelays()); err != nil {
+		return nil, err
+	}
+	return o.deliverPending(ctx, ev)
+}
+
+/**
+ * Try the deliveries of which the backoff has passed.
+ */
+func (o *Outbox) Retry(ctx context.Context, limit int) error {
+	due, err := o.Db.GetDueDeliveries(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	perEvent := make(map[string][]db.OutboxDelivery)
+	for _, delivery := range due {
+		perEvent[delivery.EventId] = append(perEvent[delivery.EventId], delivery)
+	}
+
+	for eventId, deliveries := range perEvent {
+		ev, err := o.Db.GetOutboxEvent(ctx, eventId)
+		if err != nil {
+			slog.Error(err.Error(), "event_id", eventId)
+			continue
+		}
+		slog.Info("Retrying delivery", "event_id", eventId, "relays", len(deliveries))
+		o.deliver(ctx, ev, deliveries)
+	}
+	return nil
+}
+
+func (o *Outbox) deliverPending(ctx context.Context, ev *nostr.Event) ([]db.OutboxDelivery, error) {
+	deliveries, err := o.Db.GetDeliveries(ctx, ev.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]db.OutboxDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		if delivery.Status == db.DeliveryPending {
+			pending = append(pending, delivery)
+		}
+	}
+	o.deliver(ctx, ev, pending)
+
+	deliveries, err = o.Db.GetDeliveries(ctx, ev.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status == db.DeliveryDelivered {
+			return deliveries, nil
+		}
+	}
+	return deliveries, ErrNotDelivered
+}
+
+func (o *Outbox) deliver(ctx context.Context, ev *nostr.Event, deliveries []db.OutboxDelivery) {
+	if len(deliveries) == 0 {
+		return
+	}
+
+	relayUrls := make([]string, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		relayUrls = append(relayUrls, delivery.RelayUrl)
+	}
+	results := o.Nostr.PublishTo(ctx, ev, relayUrls)
+
+	delivered := make([]string, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		update(&delivery, results[delivery.RelayUrl], time.Now())
+		if err := o.Db.SaveDelivery(ctx, &delivery); err != nil {
+			slog.Error(err.Error())
+		}
+		if delivery.Status == db.DeliveryDelivered {
+			delivered = append(delivered, delivery.RelayUrl)
+		}
+	}
+
+	// The relay accepted it, so it carries the event now
+	if err := o.Db.SaveEventRelays(ctx, []*db.Event{{Event: ev, Urls: delivered}}); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+/**
+ * Process the answer of the relay. Every failed attempt doubles the wait for the next one.
+ */
+func update(delivery *db.OutboxDelivery, err error, now time.Time) {
+	delivery.Attempts++
+	if err == nil {
+		delivery.Status = db.DeliveryDelivered
+		delivery.Message = ""
+		delivery.DeliveredAt = &now
+		return
+	}
+
+	delivery.Message = err.Error()
+	if isPermanent(delivery.Message) || delivery.Attempts >= maxAttempts {
+		delivery.Status = db.DeliveryFailed
+		return
+	}
+
+	backoff := min(minBackoff<<min(delivery.Attempts-1, 16), maxBackoff)
+	delivery.Status = db.DeliveryPending
+	delivery.NextRetryAt = now.Add(backoff).Unix()
+}
+
+func isPermanent(message string) bool {
+	for _, reason := range permanentReasons {
+		if strings.Contains(message, "msg: "+reason) {
+			return true
+		}
+	}
+	return false
+}
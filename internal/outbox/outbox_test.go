@@ -0,0 +1,159 @@
+package outbox
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"amavis442/nostr-reader/internal/relaytest"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestUpdateDelivery(t *testing.T) {
+	now := time.Now()
+
+	var delivery db.OutboxDelivery
+	update(&delivery, errors.New("failed to connect"), now)
+	if delivery.Status != db.DeliveryPending || delivery.NextRetryAt != now.Add(minBackoff).Unix() {
+		t.Log("a failed attempt should be tried again after the backoff")
+		t.Fail()
+	}
+
+	update(&delivery, errors.New("msg: blocked: you are banned"), now)
+	if delivery.Status != db.DeliveryFailed || delivery.Message != "msg: blocked: you are banned" {
+		t.Log("a blocked event should not be tried again")
+		t.Fail()
+	}
+
+	delivery = db.OutboxDelivery{Attempts: maxAttempts - 1}
+	update(&delivery, errors.New("timeout"), now)
+	if delivery.Status != db.DeliveryFailed {
+		t.Log("should give up after the max attempts")
+		t.Fail()
+	}
+
+	update(&delivery, nil, now)
+	if delivery.Status != db.DeliveryDelivered || delivery.DeliveredAt == nil || delivery.Message != "" {
+		t.Log("an accepted event should be delivered")
+		t.Fail()
+	}
+}
+
+func testOutbox(t *testing.T, relayUrls ...string) (*Outbox, *db.Storage) {
+	st := &db.Storage{Pubkey: "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"}
+	if err := st.Connect(context.Background(), &db.DbConfig{Driver: db.Sqlite, Path: filepath.Join(t.TempDir(), "nostr-reader.db")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := st.GormDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	relays := make(map[string]db.Relay)
+	for _, relayUrl := range relayUrls {
+		relays[relayUrl] = db.Relay{Write: true}
+	}
+	w := &wrapper.Wrapper{}
+	w.SetConfig(&wrapper.WrapperConfig{Relays: relays})
+	t.Cleanup(w.Close)
+
+	return NewOutbox(st, w), st
+}
+
+func testNote(t *testing.T) db.Event {
+	ev := nostr.Event{Kind: nostr.KindTextNote, Content: "to the outbox", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := ev.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	return db.Event{Event: &ev}
+}
+
+func deliveryPerRelay(deliveries []db.OutboxDelivery) map[string]db.OutboxDelivery {
+	perRelay := make(map[string]db.OutboxDelivery)
+	for _, delivery := range deliveries {
+		perRelay[delivery.RelayUrl] = delivery
+	}
+	return perRelay
+}
+
+func TestPublishDeliversToWriteRelays(t *testing.T) {
+	block := relaytest.New(t, nil, relaytest.Options{})
+	block.Reject("blocked: not here")
+	acceptUrl, blockUrl := relaytest.New(t, nil, relaytest.Options{}).Url, block.Url
+	o, st := testOutbox(t, acceptUrl, blockUrl)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	note := testNote(t)
+	deliveries, err := o.Publish(ctx, note)
+	if err != nil {
+		t.Fatal("one relay took it, so the note is delivered", err)
+	}
+
+	perRelay := deliveryPerRelay(deliveries)
+	if perRelay[acceptUrl].Status != db.DeliveryDelivered {
+		t.Logf("the relay that accepted should have it delivered, got %+v", perRelay[acceptUrl])
+		t.Fail()
+	}
+	if perRelay[blockUrl].Status != db.DeliveryFailed || !strings.Contains(perRelay[blockUrl].Message, "blocked") {
+		t.Logf("a blocked event should fail for good, got %+v", perRelay[blockUrl])
+		t.Fail()
+	}
+	if !o.Nostr.RelayAvailable(blockUrl) {
+		t.Log("a relay that refuses the note still works and should not be backed off")
+		t.Fail()
+	}
+
+	relays, _ := st.GetEventRelays(ctx, note.Event.ID)
+	if len(relays) != 1 || relays[0].RelayUrl != acceptUrl {
+		t.Logf("only the relay that accepted carries the note, got %v", relays)
+		t.Fail()
+	}
+}
+
+func TestRetryDeliversLater(t *testing.T) {
+	relay := relaytest.New(t, nil, relaytest.Options{})
+	relay.Reject("error: try again later")
+	relayUrl := relay.Url
+	o, st := testOutbox(t, relayUrl)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	note := testNote(t)
+	deliveries, err := o.Publish(ctx, note)
+	if err != ErrNotDelivered {
+		t.Fatal("no relay took the note", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != db.DeliveryPending || deliveries[0].NextRetryAt <= time.Now().Unix() {
+		t.Fatalf("the delivery should wait for a retry, got %+v", deliveries)
+	}
+
+	// Nothing is due before the backoff has passed
+	relay.Accept()
+	if err := o.Retry(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries, _ := st.GetDeliveries(ctx, note.Event.ID); deliveries[0].Status != db.DeliveryPending {
+		t.Log("the delivery should not be tried before its backoff")
+		t.Fail()
+	}
+
+	st.GormDB.Model(&db.OutboxDelivery{}).Where("event_id = ?", note.Event.ID).Update("next_retry_at", 0)
+	if err := o.Retry(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _ = st.GetDeliveries(ctx, note.Event.ID)
+	if deliveries[0].Status != db.DeliveryDelivered || deliveries[0].Attempts != 2 {
+		t.Logf("the retry should deliver the note on the second attempt, got %+v", deliveries[0])
+		t.Fail()
+	}
+}
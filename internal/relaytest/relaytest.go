@@ -0,0 +1,155 @@
+package relaytest
+
+import (
+	"amavis442/nostr-reader/internal/negentropy"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/net/websocket"
+)
+
+/**
+ * A relay stand-in for the tests. It only knows enough of NIP-01, NIP-11 and NIP-77 to be queried, synced
+ * with negentropy and published to.
+ */
+type Relay struct {
+	Url string // The ws:// url to connect to
+
+	events    []*nostr.Event
+	opts      Options
+	reason    atomic.Value
+	published atomic.Int32
+}
+
+// How the stand-in misbehaves
+type Options struct {
+	NegDelay  time.Duration // Before the answer on a NEG-OPEN
+	ReqDelay  time.Duration // Before the answer on a REQ
+	ReqClosed bool          // Close every REQ instead of answering it
+}
+
+// A stand-in with the events, it is closed when the test is done
+func New(t testing.TB, evs []*nostr.Event, opts Options) *Relay {
+	relay := &Relay{events: evs, opts: opts}
+	relay.Accept()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "application/nostr+json" {
+			w.Write([]byte(`{"name":"stand-in","supported_nips":[1,11,77]}`))
+			return
+		}
+		websocket.Server{Handler: relay.handle}.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	relay.Url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return relay
+}
+
+// Answer every EVENT with an OK true
+func (relay *Relay) Accept() {
+	relay.reason.Store("")
+}
+
+// Answer every EVENT with an OK false and the reason, like "blocked: not here"
+func (relay *Relay) Reject(reason string) {
+	relay.reason.Store(reason)
+}
+
+// How many EVENTs were published to the relay, also the ones it rejected
+func (relay *Relay) Published() int {
+	return int(relay.published.Load())
+}
+
+func (relay *Relay) handle(ws *websocket.Conn) {
+	sessions := make(map[string]*negentropy.Negentropy)
+	send := func(msg []any) {
+		data, _ := json.Marshal(msg)
+		websocket.Message.Send(ws, string(data))
+	}
+
+	for {
+		var data string
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			return
+		}
+		var msg []json.RawMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil || len(msg) < 2 {
+			continue
+		}
+		var label, subId string
+		json.Unmarshal(msg[0], &label)
+
+		switch label {
+		case "EVENT":
+			var ev nostr.Event
+			if json.Unmarshal(msg[1], &ev) != nil {
+				continue
+			}
+			relay.published.Add(1)
+			reason := relay.reason.Load().(string)
+			send([]any{"OK", ev.ID, reason == "", reason})
+		case "REQ":
+			json.Unmarshal(msg[1], &subId)
+			time.Sleep(relay.opts.ReqDelay)
+			if relay.opts.ReqClosed {
+				send([]any{"CLOSED", subId, "error: not now"})
+				continue
+			}
+			var filter nostr.Filter
+			if len(msg) > 2 {
+				json.Unmarshal(msg[2], &filter)
+			}
+			// Newest first and no more than the limit, like a real relay
+			sorted := append([]*nostr.Event{}, relay.events...)
+			sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedAt > sorted[j].CreatedAt })
+			sent := 0
+			for _, ev := range sorted {
+				if filter.Limit > 0 && sent == filter.Limit {
+					break
+				}
+				if filter.Matches(ev) {
+					send([]any{"EVENT", subId, ev})
+					sent++
+				}
+			}
+			send([]any{"EOSE", subId})
+		case "NEG-OPEN", "NEG-MSG":
+			json.Unmarshal(msg[1], &subId)
+			var payload string
+			if label == "NEG-OPEN" {
+				time.Sleep(relay.opts.NegDelay)
+				storage := &negentropy.Storage{}
+				for _, ev := range relay.events {
+					storage.Insert(int64(ev.CreatedAt), ev.ID)
+				}
+				storage.Seal()
+				sessions[subId], _ = negentropy.New(storage)
+				if len(msg) > 3 {
+					json.Unmarshal(msg[3], &payload)
+				}
+			} else if len(msg) > 2 {
+				json.Unmarshal(msg[2], &payload)
+			}
+			session, ok := sessions[subId]
+			if !ok {
+				send([]any{"NEG-ERR", subId, "closed: no session"})
+				continue
+			}
+			query, _ := hex.DecodeString(payload)
+			out, _, _, err := session.Reconcile(query)
+			if err != nil {
+				send([]any{"NEG-ERR", subId, err.Error()})
+				continue
+			}
+			send([]any{"NEG-MSG", subId, hex.EncodeToString(out)})
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package syncer
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	RunRunning = "running"
+	RunDone    = "done"
+	RunFailed  = "failed"
+
+	TriggerTicker = "ticker"
+	TriggerApi    = "api"
+)
+
+// How many finished runs we remember for the history
+const historySize = 50
+
+// How many events we give a relay in one negentropy sync
+const uploadLimit = 500
+
+var ErrRunning = errors.New("a sync is already running")
+
+/**
+ * One sync run: what every relay did and how many events of each kind we saved.
+ */
+type Run struct {
+	ID         int64                         `json:"id"`
+	Trigger    string                        `json:"trigger"`
+	Status     string                        `json:"status"`
+	Error      string                        `json:"error,omitempty"`
+	StartedAt  time.Time                     `json:"started_at"`
+	FinishedAt *time.Time                    `json:"finished_at"`
+	DurationMs int64                         `json:"duration_ms"`
+	Relays     map[string]wrapper.RelayStats `json:"relays"`
+	Kinds      map[int]int                   `json:"kinds"`
+	Saved      int                           `json:"saved"`
+}
+
+func (run *Run) copy() Run {
+	c := *run
+	c.Relays = make(map[string]wrapper.RelayStats, len(run.Relays))
+	for k, v := range run.Relays {
+		c.Relays[k] = v
+	}
+	c.Kinds = make(map[int]int, len(run.Kinds))
+	for k, v := range run.Kinds {
+		c.Kinds[k] = v
+	}
+	return c
+}
+
+/**
+ * Runs the pull sync for the ticker and the api, so there is never more than one sync at a time.
+ */
+type Manager struct {
+	Db      db.Store
+	Nostr   *wrapper.Wrapper
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	current *Run
+	history []*Run
+}
+
+func NewManager(st db.Store, nostrWrapper *wrapper.Wrapper, timeout time.Duration) *Manager {
+	return &Manager{Db: st, Nostr: nostrWrapper, Timeout: timeout}
+}
+
+/**
+ * Start a run in the background. Returns ErrRunning and the running run when a sync is busy.
+ */
+func (m *Manager) Start(ctx context.Context, trigger string) (Run, error) {
+	run, err := m.begin(trigger)
+	if err != nil {
+		return m.busy(), err
+	}
+
+	go m.execute(context.WithoutCancel(ctx), run)
+
+	return m.snapshot(run), nil
+}
+
+/**
+ * Run a sync and wait until it is done.
+ */
+func (m *Manager) Run(ctx context.Context, trigger string) (Run, error) {
+	run, err := m.begin(trigger)
+	if err != nil {
+		return m.busy(), err
+	}
+
+	m.execute(ctx, run)
+
+	return m.snapshot(run), nil
+}
+
+// The running sync or else the last one. Nil when there was no sync yet.
+func (m *Manager) Status() *Run {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run := m.current
+	if run == nil && len(m.history) > 0 {
+		run = m.history[0]
+	}
+	if run == nil {
+		return nil
+	}
+	c := run.copy()
+	return &c
+}
+
+// The finished runs, newest first
+func (m *Manager) History() []Run {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	history := make([]Run, 0, len(m.history))
+	for _, run := range m.history {
+		history = append(history, run.copy())
+	}
+	return history
+}
+
+func (m *Manager) begin(trigger string) (*Run, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != nil {
+		return nil, ErrRunning
+	}
+
+	now := time.Now()
+	m.current = &Run{
+		ID:        now.UnixNano(),
+		Trigger:   trigger,
+		Status:    RunRunning,
+		StartedAt: now,
+		Relays:    make(map[string]wrapper.RelayStats),
+		Kinds:     make(map[int]int),
+	}
+	return m.current, nil
+}
+
+// The run that is in the way, it can just have finished
+func (m *Manager) busy() Run {
+	if run := m.Status(); run != nil {
+		return *run
+	}
+	return Run{}
+}
+
+func (m *Manager) snapshot(run *Run) Run {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return run.copy()
+}
+
+func (m *Manager) execute(ctx context.Context, run *Run) {
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+
+	slog.Info("Sync started", "trigger", run.Trigger)
+	err := m.sync(ctx, run)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	run.FinishedAt = &now
+	run.DurationMs = now.Sub(run.StartedAt).Milliseconds()
+	run.Status = RunDone
+	if err != nil {
+		run.Status = RunFailed
+		run.Error = err.Error()
+	}
+
+	m.current = nil
+	m.history = append([]*Run{run}, m.history...)
+	if len(m.history) > historySize {
+		m.history = m.history[:historySize]
+	}
+	slog.Info("Done syncing", "saved", run.Saved, "duration_ms", run.DurationMs)
+}
+
+func (m *Manager) sync(ctx context.Context, run *Run) error {
+	var errs []error
+	for _, syncFilter := range m.Filters(ctx) {
+		cursors := m.Db.GetSyncCursors(ctx, syncFilter.Key)
+		result := m.Nostr.GetEventsPerRelay(ctx, syncFilter, cursors)
+
+		saved, err := m.Db.SaveEvents(ctx, result.Events)
+		m.record(run, result, saved)
+		if err != nil {
+			// The events after the failing one are not stored, so the cursors stay where they are
+			slog.Error(err.Error())
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := m.Db.SaveSyncCursors(ctx, syncFilter.Key, result.Cursors); err != nil {
+			slog.Error(err.Error())
+			errs = append(errs, err)
+		}
+	}
+
+	if m.Nostr.Sync.Negentropy {
+		if err := m.negentropy(ctx, run); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Relays we could not reach are not in the results, the health tells us why
+	for _, h := range m.Nostr.GetRelayHealth() {
+		if _, ok := run.Relays[h.Url]; ok || h.LastErrorAt == nil || h.LastErrorAt.Before(run.StartedAt) {
+			continue
+		}
+		run.Relays[h.Url] = wrapper.RelayStats{Url: h.Url, Error: h.LastError}
+	}
+
+	return errors.Join(errs...)
+}
+
+/**
+ * Add the results of one filter to the run. A relay that is incomplete for one filter is incomplete for the run.
+ * Only the saved events are counted per kind, not the ones the store skipped or stopped at.
+ */
+func (m *Manager) record(run *Run, result wrapper.SyncResult, saved []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for url, stats := range result.Relays {
+		if existing, ok := run.Relays[url]; ok {
+			stats.Events += existing.Events
+			stats.DurationMs += existing.DurationMs
+			stats.Complete = stats.Complete && existing.Complete
+			stats.Need += existing.Need
+			stats.Have += existing.Have
+			if stats.Error == "" {
+				stats.Error = existing.Error
+			}
+		}
+		run.Relays[url] = stats
+	}
+
+	savedIds := make(map[string]bool, len(saved))
+	for _, id := range saved {
+		savedIds[id] = true
+	}
+	for _, ev := range result.Events {
+		if savedIds[ev.Event.ID] {
+			run.Kinds[ev.Event.Kind]++
+		}
+	}
+	run.Saved += len(savedIds)
+}
+
+/**
+ * NIP-77: compare the notes we have of our follows with the relays that support it and only get what we miss.
+ * With NegentropyUpload the relays also get the notes they miss.
+ */
+func (m *Manager) negentropy(ctx context.Context, run *Run) error {
+	var errs []error
+	for _, filter := range m.Nostr.GetNegentropyFilters(m.Db.GetFollows(ctx)) {
+		items, err := m.Db.GetNegentropyItems(ctx, filter.Authors, filter.Kinds, int64(*filter.Since))
+		if err != nil {
+			slog.Error(err.Error())
+			errs = append(errs, err)
+			continue
+		}
+
+		result := m.Nostr.NegentropySync(ctx, filter, items)
+		saved, err := m.Db.SaveEvents(ctx, result.Events)
+		if err != nil {
+			slog.Error(err.Error())
+			errs = append(errs, err)
+		}
+		m.record(run, result.SyncResult, saved)
+
+		if m.Nostr.Sync.NegentropyUpload {
+			m.upload(ctx, result.Have)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/**
+ * Give the relays the notes only we have, a relay that refuses one is not our problem. After the first note a
+ * relay does not take we stop, it will not want the rest either and a relay that is down is not asked again
+ * for every note.
+ */
+func (m *Manager) upload(ctx context.Context, have map[string][]string) {
+	for relayUrl, ids := range have {
+		if len(ids) > uploadLimit {
+			ids = ids[:uploadLimit]
+		}
+		evs, err := m.Db.GetRawNotes(ctx, ids)
+		if err != nil {
+			slog.Error(err.Error())
+			continue
+		}
+
+		uploaded := 0
+		for _, ev := range evs {
+			if err := m.Nostr.PublishTo(ctx, ev, []string{relayUrl})[relayUrl]; err != nil {
+				slog.Info("negentropy upload stopped on: "+relayUrl, "error", err.Error())
+				break
+			}
+			uploaded++
+		}
+		slog.Info(fmt.Sprintf("negentropy uploaded %d of %d events to: %s", uploaded, len(evs), relayUrl))
+	}
+}
+
+/**
+ * The filters for our follows, mentions, replies on our notes and the global sample.
+ */
+func (m *Manager) Filters(ctx context.Context) []wrapper.SyncFilter {
+	follows := m.Db.GetFollows(ctx)
+	ownNoteIds := m.Db.GetOwnNoteIds(ctx, time.Now().AddDate(0, 0, -m.Nostr.Sync.RepliesDays).Unix(), m.Nostr.Sync.RepliesNotes)
+
+	return m.Nostr.GetSyncFilters(follows, ownNoteIds)
+}
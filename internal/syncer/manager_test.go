@@ -0,0 +1,142 @@
+package syncer
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"amavis442/nostr-reader/internal/relaytest"
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRunsDoNotOverlap(t *testing.T) {
+	var m Manager
+
+	run, err := m.begin(TriggerTicker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.begin(TriggerApi); err != ErrRunning {
+		t.Log("second run should be refused while the first one runs")
+		t.Fail()
+	}
+	if status := m.busy(); status.ID != run.ID || status.Status != RunRunning {
+		t.Log("status should be the running sync")
+		t.Fail()
+	}
+}
+
+func TestRecordCountsOnlySavedEvents(t *testing.T) {
+	var m Manager
+	run, err := m.begin(TriggerApi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := wrapper.SyncResult{Relays: map[string]wrapper.RelayStats{"wss://a.example.com": {Events: 3, Complete: true}}}
+	for i, kind := range []int{nostr.KindTextNote, nostr.KindTextNote, nostr.KindReaction} {
+		result.Events = append(result.Events, &db.Event{Event: &nostr.Event{ID: strconv.Itoa(i), Kind: kind}})
+	}
+	m.record(run, result, []string{"1", "2"})
+
+	if run.Saved != 2 || run.Kinds[nostr.KindTextNote] != 1 || run.Kinds[nostr.KindReaction] != 1 {
+		t.Logf("only the saved events should be counted, got %d saved and kinds %v", run.Saved, run.Kinds)
+		t.Fail()
+	}
+	if run.Relays["wss://a.example.com"].Events != 3 {
+		t.Log("the relay stats are what the relay sent")
+		t.Fail()
+	}
+}
+
+// Only what a sync needs, saving the events fails
+type failingStore struct {
+	db.Store
+	cursorsSaved int
+}
+
+func (st *failingStore) GetFollows(ctx context.Context) []string { return nil }
+
+func (st *failingStore) GetOwnNoteIds(ctx context.Context, since int64, limit int) []string {
+	return nil
+}
+
+func (st *failingStore) GetSyncCursors(ctx context.Context, filterKey string) map[string]db.SyncCursor {
+	return map[string]db.SyncCursor{}
+}
+
+func (st *failingStore) SaveEvents(ctx context.Context, evs []*db.Event) ([]string, error) {
+	return nil, errors.New("disk full")
+}
+
+func (st *failingStore) SaveSyncCursors(ctx context.Context, filterKey string, cursors map[string]db.SyncCursor) error {
+	st.cursorsSaved++
+	return nil
+}
+
+func TestCursorsStayWhenSavingFails(t *testing.T) {
+	st := &failingStore{}
+	var w wrapper.Wrapper
+	w.SetSyncConfig(nil)
+	m := NewManager(st, &w, 0)
+
+	run, err := m.Run(context.Background(), TriggerApi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != RunFailed {
+		t.Log("the run should fail when the events are not saved")
+		t.Fail()
+	}
+	if st.cursorsSaved != 0 {
+		t.Logf("the cursors should not move past events that are not saved, saved %d times", st.cursorsSaved)
+		t.Fail()
+	}
+}
+
+// The notes only we have
+type uploadStore struct {
+	db.Store
+	notes []*nostr.Event
+}
+
+func (st *uploadStore) GetRawNotes(ctx context.Context, ids []string) ([]*nostr.Event, error) {
+	return st.notes, nil
+}
+
+func TestUploadStopsAtTheFirstRefusal(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	st := &uploadStore{}
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ev := &nostr.Event{Kind: nostr.KindTextNote, Content: "note " + strconv.Itoa(i), Tags: nostr.Tags{}}
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		st.notes = append(st.notes, ev)
+		ids = append(ids, ev.ID)
+	}
+
+	accepting := relaytest.New(t, nil, relaytest.Options{})
+	refusing := relaytest.New(t, nil, relaytest.Options{})
+	refusing.Reject("blocked: no uploads")
+
+	var w wrapper.Wrapper
+	w.SetConfig(&wrapper.WrapperConfig{Relays: map[string]db.Relay{accepting.Url: {Read: true}, refusing.Url: {Read: true}}})
+	defer w.Close()
+	m := NewManager(st, &w, 0)
+
+	m.upload(context.Background(), map[string][]string{accepting.Url: ids, refusing.Url: ids})
+	if accepting.Published() != len(ids) {
+		t.Logf("the relay that takes the notes should get all of them, got %d", accepting.Published())
+		t.Fail()
+	}
+	if refusing.Published() != 1 {
+		t.Logf("a relay that refuses a note should not get the rest, got %d", refusing.Published())
+		t.Fail()
+	}
+}
@@ -71,3 +71,50 @@ func ProcessTags(ev *nostr.Event, pubkey string) (etags []string, ptags []string
 
 	return etags, ptags, hasNotification, isRoot, tree, err
 }
+
+// Report types as described in NIP-56
+var ReportTypes = []string{"nudity", "malware", "profanity", "illegal", "spam", "impersonation", "other"}
+
+func IsReportType(reportType string) bool {
+	for _, t := range ReportTypes {
+		if t == reportType {
+			return true
+		}
+	}
+	return false
+}
+
+// Processes the tags of a kind 1984 report into the reported pubkey, the reported note (if any) and the report type
+func ProcessReportTags(ev *nostr.Event) (targetPubkey string, targetEventId string, reportType string, err error) {
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || len(tag[1]) != 64 {
+			continue
+		}
+		switch tag[0] {
+		case "p":
+			if targetPubkey == "" {
+				targetPubkey = tag[1]
+				if len(tag) > 2 && reportType == "" {
+					reportType = tag[2]
+				}
+			}
+		case "e":
+			if targetEventId == "" {
+				targetEventId = tag[1]
+				if len(tag) > 2 {
+					reportType = tag[2] // Type on the e tag wins, because the report is about the note
+				}
+			}
+		}
+	}
+
+	if targetPubkey == "" {
+		err = errors.New("report has no valid p tag")
+		return
+	}
+	if !IsReportType(reportType) {
+		reportType = "other"
+	}
+
+	return targetPubkey, targetEventId, reportType, err
+}
@@ -96,3 +96,56 @@ func TestProcessTagsShouldHaveNotification(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestProcessReportTagsNote(t *testing.T) {
+	tags := nostr.Tags{}
+	tags = append(tags, nostr.Tag{"e", "0000640f9cce22fb3dfb13204e0eca583f8419e162093efc9e0d734c91e58bcc", "spam"})
+	tags = append(tags, nostr.Tag{"p", "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"})
+
+	ev := &nostr.Event{
+		PubKey: "a1863ef588572c83daeb8946c47ed6a715ce0cdd79248fa3cd3f4183907d85f0",
+		Kind:   1984,
+		Tags:   tags,
+	}
+
+	targetPubkey, targetEventId, reportType, err := ProcessReportTags(ev)
+	if err != nil {
+		t.Log("report should be valid")
+		t.Fail()
+	}
+	if targetPubkey != "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d" {
+		t.Log("targetPubkey should be 3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d")
+		t.Fail()
+	}
+	if targetEventId != "0000640f9cce22fb3dfb13204e0eca583f8419e162093efc9e0d734c91e58bcc" {
+		t.Log("targetEventId should be 0000640f9cce22fb3dfb13204e0eca583f8419e162093efc9e0d734c91e58bcc")
+		t.Fail()
+	}
+	if reportType != "spam" {
+		t.Log("reportType should be spam")
+		t.Fail()
+	}
+}
+
+func TestProcessReportTagsPubkeyOnly(t *testing.T) {
+	tags := nostr.Tags{}
+	tags = append(tags, nostr.Tag{"p", "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d", "whatever"})
+
+	ev := &nostr.Event{Kind: 1984, Tags: tags}
+
+	_, targetEventId, reportType, err := ProcessReportTags(ev)
+	if err != nil || targetEventId != "" {
+		t.Log("report on a pubkey should not have a target event")
+		t.Fail()
+	}
+	if reportType != "other" {
+		t.Log("unknown report types should become other")
+		t.Fail()
+	}
+
+	_, _, _, err = ProcessReportTags(&nostr.Event{Kind: 1984})
+	if err == nil {
+		t.Log("report without p tag should give an error")
+		t.Fail()
+	}
+}
@@ -0,0 +1,132 @@
+package thread
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	// How many times we ask for the replies on the replies we found
+	maxRounds = 3
+	// Relays where we saw a note, to ask for the note and its replies
+	hintLimit = 5
+)
+
+/**
+ * A thread as far as we could get it. Replies of which we do not have the parent are in Orphans and
+ * every note we miss is in MissingIds.
+ */
+type Thread struct {
+	Root       *db.Event   `json:"root"`
+	Orphans    []*db.Event `json:"orphans"`
+	Missing    bool        `json:"missing"`
+	MissingIds []string    `json:"missing_ids"`
+}
+
+/**
+ * Gets a full thread from the relays when a note is opened, instead of only showing what the sync brought in.
+ */
+type Fetcher struct {
+	Db      db.Store
+	Nostr   *wrapper.Wrapper
+	Timeout time.Duration
+}
+
+func NewFetcher(st db.Store, nostrWrapper *wrapper.Wrapper, timeout time.Duration) *Fetcher {
+	return &Fetcher{Db: st, Nostr: nostrWrapper, Timeout: timeout}
+}
+
+/**
+ * Get the root, the replies and the profiles of the authors of the thread the note is in, store them and
+ * return the tree. When the timeout passes we return what we have.
+ */
+func (f *Fetcher) Fetch(ctx context.Context, eventId string, page db.ReplyPage) (Thread, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	hints := f.Db.GetRelayHints(ctx, eventId, hintLimit)
+	if !f.Db.HasNote(ctx, eventId) {
+		f.save(ctx, f.Nostr.GetEventsByIds(fetchCtx, []string{eventId}, hints))
+	}
+
+	rootId := f.Db.GetThreadRoot(ctx, eventId)
+	ids := []string{rootId}
+	if rootId != eventId {
+		hints = append(hints, f.Db.GetRelayHints(ctx, rootId, hintLimit)...)
+		if !f.Db.HasNote(ctx, rootId) {
+			f.save(ctx, f.Nostr.GetEventsByIds(fetchCtx, []string{rootId}, hints))
+		}
+		ids = append(ids, eventId)
+	}
+
+	// Not every client puts the root in a reply, so we also ask for the replies on the replies
+	seen := make(map[string]bool)
+	for round := 0; round < maxRounds && len(ids) > 0 && fetchCtx.Err() == nil; round++ {
+		for _, id := range ids {
+			seen[id] = true
+		}
+		evs := f.Nostr.GetReplies(fetchCtx, ids, hints)
+		f.save(ctx, evs)
+
+		ids = nil
+		for _, ev := range evs {
+			if !seen[ev.Event.ID] {
+				ids = append(ids, ev.Event.ID)
+			}
+		}
+	}
+
+	tree, err := f.Db.GetThread(ctx, rootId, page)
+	if err != nil {
+		return Thread{}, err
+	}
+	pubkeys := make([]string, 0)
+	for _, ev := range append([]*db.Event{tree.Root}, tree.Orphans...) {
+		pubkeys = appendPubkeys(pubkeys, ev)
+	}
+	if missing := f.Db.GetMissingProfiles(ctx, pubkeys); len(missing) > 0 && fetchCtx.Err() == nil {
+		f.save(ctx, f.Nostr.UpdateProfiles(fetchCtx, missing))
+		if tree, err = f.Db.GetThread(ctx, rootId, page); err != nil {
+			return Thread{}, err
+		}
+	}
+
+	return newThread(rootId, tree), nil
+}
+
+func (f *Fetcher) save(ctx context.Context, evs []*db.Event) {
+	if len(evs) == 0 {
+		return
+	}
+	if _, err := f.Db.SaveEvents(ctx, evs); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+/**
+ * The tree comes from the database, we only tell which notes are missing.
+ */
+func newThread(rootId string, tree db.ReplyTree) Thread {
+	thread := Thread{Root: tree.Root, Orphans: tree.Orphans, MissingIds: tree.MissingIds}
+	if thread.Root == nil {
+		thread.MissingIds = append([]string{rootId}, thread.MissingIds...)
+	}
+	thread.Missing = len(thread.MissingIds) > 0
+
+	return thread
+}
+
+// The authors of the note and all the replies under it
+func appendPubkeys(pubkeys []string, ev *db.Event) []string {
+	if ev == nil {
+		return pubkeys
+	}
+	pubkeys = append(pubkeys, ev.Event.PubKey)
+	for _, child := range ev.Children {
+		pubkeys = appendPubkeys(pubkeys, child)
+	}
+	return pubkeys
+}
@@ -0,0 +1,43 @@
+package thread
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func note(id string, createdAt nostr.Timestamp) *db.Event {
+	return &db.Event{Event: &nostr.Event{ID: id, CreatedAt: createdAt}}
+}
+
+func TestNewThread(t *testing.T) {
+	root := note("root", 1)
+	reply := note("reply", 2)
+	root.Children = []*db.Event{reply}
+	orphan := note("orphan", 3)
+
+	thread := newThread("root", db.ReplyTree{Root: root, Orphans: []*db.Event{orphan}, MissingIds: []string{"gone"}})
+	if thread.Root != root || len(thread.Orphans) != 1 {
+		t.Log("the thread should have the tree of the database")
+		t.Fail()
+	}
+	if !thread.Missing || len(thread.MissingIds) != 1 || thread.MissingIds[0] != "gone" {
+		t.Log("the parent of the orphan should be missing")
+		t.Fail()
+	}
+
+	pubkeys := appendPubkeys(nil, root)
+	if len(pubkeys) != 2 {
+		t.Log("the authors of the root and the reply should be there")
+		t.Fail()
+	}
+}
+
+func TestNewThreadWithoutRoot(t *testing.T) {
+	thread := newThread("root", db.ReplyTree{Orphans: []*db.Event{note("reply", 2)}, MissingIds: []string{}})
+	if thread.Root != nil || !thread.Missing || len(thread.Orphans) != 1 || thread.MissingIds[0] != "root" {
+		t.Log("without the root all replies are orphans and the root is missing")
+		t.Fail()
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"amavis442/nostr-reader/internal/relaytest"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func (st *fakeStore) GetSyncCursors(ctx context.Context, filterKey string) map[string]db.SyncCursor {
+	return map[string]db.SyncCursor{}
+}
+
+func (st *fakeStore) SaveSyncCursors(ctx context.Context, filterKey string, cursors map[string]db.SyncCursor) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.cursors == nil {
+		st.cursors = make(map[string]int64)
+	}
+	for relayUrl, cursor := range cursors {
+		st.cursors[relayUrl] = cursor.Since
+	}
+	return nil
+}
+
+func TestLiveFilterKeepsCursorsWhenSavingFails(t *testing.T) {
+	pubkey, evs := authorNotes(t, 3)
+	relayUrl := relaytest.New(t, evs, relaytest.Options{}).Url
+	syncFilter := wrapper.SyncFilter{Key: wrapper.SyncFilterFollows, Filters: nostr.Filters{{Kinds: []int{nostr.KindTextNote}, Authors: []string{pubkey}}}}
+
+	for _, saveErr := range []error{nil, errors.New("disk full")} {
+		st := &fakeStore{saveErr: saveErr}
+		w := testWrapper(t, relayUrl)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		liveFilter(ctx, st, w, syncFilter, 50*time.Millisecond)
+		cancel()
+
+		st.mu.Lock()
+		cursor, moved := st.cursors[relayUrl]
+		st.mu.Unlock()
+		if saveErr == nil && (!moved || cursor != int64(evs[0].CreatedAt)) {
+			t.Logf("the cursor should be at the newest saved note, got %d", cursor)
+			t.Fail()
+		}
+		if saveErr != nil && moved {
+			t.Log("the cursor should not move when the notes are not saved")
+			t.Fail()
+		}
+	}
+}
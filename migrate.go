@@ -0,0 +1,59 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+/**
+ * nostr-reader migrate up|down [steps]|status
+ * Returns the exit code, status fails when the entities and the schema do not have the same columns.
+ */
+func migrateCommand(ctx context.Context, st *db.Storage, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: migrate up|down [steps]|status")
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		if err := st.MigrateUp(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "steps should be a number above 0")
+				return 2
+			}
+			steps = n
+		}
+		if err := st.MigrateDown(steps); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	case "status":
+		status, err := st.GetMigrationStatus()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		fmt.Printf("driver: %s\nversion: %d\ndirty: %t\nlatest: %d\n", st.DbConfig.Driver, status.Version, status.Dirty, status.Latest)
+		if err := st.CheckSchema(ctx); err != nil {
+			fmt.Printf("schema does not match the entities:\n%s\n", err.Error())
+			return 1
+		}
+		fmt.Println("schema matches the entities")
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown migrate command %s\n", args[0])
+		return 2
+	}
+
+	return 0
+}
@@ -6,6 +6,9 @@ import (
 	"amavis442/nostr-reader/internal/db"
 	"amavis442/nostr-reader/internal/http"
 	wrapper "amavis442/nostr-reader/internal/nostr"
+	"amavis442/nostr-reader/internal/outbox"
+	"amavis442/nostr-reader/internal/syncer"
+	"amavis442/nostr-reader/internal/thread"
 	"context"
 	"flag"
 	"fmt"
@@ -17,8 +20,6 @@ import (
 	"sync"
 	"syscall"
 	"time"
-
-	"github.com/nbd-wtf/go-nostr"
 )
 
 const name = "nostr-reader"
@@ -75,10 +76,12 @@ func main() {
 	versionPtr := flag.Bool("version", false, "Show version")
 	namePtr := flag.Bool("name", false, "Show exec name")
 	syncIntervalPtr := flag.Int("sync", 5, "What is the time (in minutes) between sync of relays to local database?")
-	cleanPtr := flag.Bool("clean", false, "Clean database after x days retention?")
+	cleanPtr := flag.Bool("clean", false, "Clean database with the retention rules at startup? It is also done every retentioninterval hours")
+	livePtr := flag.Bool("live", false, "Keep subscriptions open on the relays instead of polling them every sync interval?")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] [name ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s migrate up|down [steps]|status\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
@@ -109,6 +112,7 @@ func main() {
 	slog.Info("Running in dev mode ", "mode", *modePtr)
 	slog.Info("Cleaning database ", "cleanit", *cleanPtr)
 	slog.Info("Sync interval is: " + fmt.Sprint(*syncIntervalPtr) + " minutes")
+	slog.Info("Live mode ", "live", *livePtr)
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
@@ -130,10 +134,13 @@ func main() {
 	var nostrWrapper wrapper.Wrapper
 
 	nostrWrapper.SetConfig(cfg.Nostr)
+	defer nostrWrapper.Close()
+	nostrWrapper.SetSyncConfig(cfg.Sync)
 
 	var st db.Storage
 	st.SetEnvironment(cfg.Env)
 	st.Pubkey = cfg.Nostr.PubKey
+	st.Zappers = wrapper.NewZappers().Pubkey
 
 	err = st.Connect(ctx, cfg.Database) // Does not make a connection immediately but prepares so it does not yet know if the pg server is available.
 
@@ -142,83 +149,217 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "migrate" {
+		os.Exit(migrateCommand(ctx, &st, flag.Args()[1:]))
+	}
+
+	if err := st.MigrateUp(); err != nil {
+		slog.Error("Migrating the database failed", "error", err.Error())
+		os.Exit(1)
+	}
+	if err := st.CheckSchema(ctx); err != nil {
+		slog.Warn("The database schema does not match the entities", "error", err.Error())
+	}
+
 	if cleanStorage {
-		slog.Info(fmt.Sprintf("Cleaning database entries older then %d days.", st.DbConfig.Retention))
-		_ = st.Clean(ctx)
+		slog.Info("Cleaning database with the retention rules", "rules", st.RetentionRules())
+		if _, err := st.ApplyRetention(ctx, false); err != nil {
+			slog.Error(err.Error())
+		}
 	}
 	relays := st.GetRelays(ctx)
 	nostrWrapper.UpdateRelays(relays)
+	nostrWrapper.SetRelayHealth(st.GetRelayHealth(ctx))
+
+	syncManager := syncer.NewManager(&st, &nostrWrapper, 120*time.Second)
+	publisher := outbox.NewOutbox(&st, &nostrWrapper)
+	threadFetcher := thread.NewFetcher(&st, &nostrWrapper, 15*time.Second)
 
 	var wg sync.WaitGroup
 
-	intervalTimer := time.Duration(*syncIntervalPtr * 60)
-	ticker := time.NewTicker(intervalTimer * time.Second)
+	wg.Add(1)
+	go relayHealthTask(&wg, ctx, &st, &nostrWrapper, time.Minute)
 
-	// Creating channel using make
-	tickerChan := make(chan bool)
+	wg.Add(1)
+	go outboxTask(&wg, ctx, publisher, 30*time.Second)
 
-	go func() {
-		for {
-			select {
-			case <-tickerChan:
-				return
-			// interval task
-			case tm := <-ticker.C:
-				slog.Info("The Current time is", "time", tm)
-				wg.Add(1)
-				go intervalTask(&wg, ctx, &st, &nostrWrapper, 120, *disableSyncPtr)
+	wg.Add(1)
+	go retentionTask(&wg, ctx, &st, time.Duration(st.DbConfig.RetentionInterval)*time.Hour)
+
+	if !*disableSyncPtr {
+		wg.Add(1)
+		go backfillTask(&wg, ctx, &st, &nostrWrapper, 30*time.Second)
+
+		wg.Add(1)
+		go resolverTask(&wg, ctx, &st, &nostrWrapper, 30*time.Second)
+
+		wg.Add(1)
+		go profileTask(&wg, ctx, &st, &nostrWrapper, 5*time.Minute)
+	}
+
+	if *livePtr && !*disableSyncPtr {
+		wg.Add(1)
+		go liveTask(&wg, ctx, &st, &nostrWrapper, syncManager, 10*time.Second)
+	} else {
+		intervalTimer := time.Duration(*syncIntervalPtr * 60)
+		ticker := time.NewTicker(intervalTimer * time.Second)
+
+		// Creating channel using make
+		tickerChan := make(chan bool)
+
+		go func() {
+			for {
+				select {
+				case <-tickerChan:
+					return
+				// interval task
+				case tm := <-ticker.C:
+					slog.Info("The Current time is", "time", tm)
+					wg.Add(1)
+					go intervalTask(&wg, ctx, syncManager, *disableSyncPtr)
+				}
 			}
-		}
-	}()
+		}()
+	}
 
 	var httpServer http.HttpServer
 	httpServer.DevMode = devMode
 	httpServer.Server = cfg.Server
 	httpServer.Database = &st
 	httpServer.Nostr = &nostrWrapper
+	httpServer.Sync = syncManager
+	httpServer.Outbox = publisher
+	httpServer.Thread = threadFetcher
 
 	httpServer.Start()
 
 	wg.Wait()
 }
 
-func intervalTask(wg *sync.WaitGroup, ctx context.Context, st *db.Storage, nostrWrapper *wrapper.Wrapper, timeOut int, syncDisabled bool) {
+func intervalTask(wg *sync.WaitGroup, ctx context.Context, syncManager *syncer.Manager, syncDisabled bool) {
+	defer wg.Done()
+
 	if syncDisabled {
 		return
 	}
 
-	tOut := time.Duration(timeOut) * time.Second
-	ctx, cancel := context.WithTimeout(ctx, tOut)
-	defer func() {
-		wg.Done()
-		cancel()
-	}()
-
-	createdAt := st.GetLastTimeStamp(ctx)
-	t := time.Unix(createdAt, 0)
-	slog.Info(fmt.Sprint("TimeStamps: ", createdAt, t.UTC()))
-	filter := nostrWrapper.GetEventData(createdAt, false)
-	evs := nostrWrapper.GetEvents(ctx, filter)
+	if _, err := syncManager.Run(ctx, syncer.TriggerTicker); err != nil {
+		slog.Info(err.Error())
+	}
+}
 
+/**
+ * Save the events. The root and reply notes we do not have yet are picked up by the resolver.
+ */
+func storeEvents(ctx context.Context, st db.Store, evs []*db.Event) error {
 	_, err := st.SaveEvents(ctx, evs)
 	if err != nil {
 		slog.Error(err.Error())
 	}
+	return err
+}
+
+/**
+ * Live mode: the relays stream their events to us and we save them in batches.
+ * The frontend still pulls the notes from the database, so reading stays calm.
+ * Every refresh the filters are build again, only the ones that changed by new follows and notes are
+ * subscribed again.
+ */
+func liveTask(wg *sync.WaitGroup, ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, syncManager *syncer.Manager, flushInterval time.Duration) {
+	defer wg.Done()
+
+	const refresh = 15 * time.Minute
 
-	if len(db.Missing_event_ids) > 0 {
-		slog.Info("Sniping missing events...........")
-		//need to try to get them
-		filter = nostr.Filter{
-			IDs: db.Missing_event_ids,
+	live := wrapper.NewLiveFilters(func(ctx context.Context, syncFilter wrapper.SyncFilter) {
+		liveFilter(ctx, st, nostrWrapper, syncFilter, flushInterval)
+	})
+	defer live.Stop()
+
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		if started := live.Update(ctx, syncManager.Filters(ctx)); started > 0 {
+			slog.Info("Live filters subscribed", "count", started)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
 		}
-		evs := nostrWrapper.GetEvents(ctx, filter)
+	}
+}
+
+/**
+ * Stream one sync filter and save the events in batches, moving the cursor of the relays they came from.
+ */
+func liveFilter(ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, syncFilter wrapper.SyncFilter, flushInterval time.Duration) {
+	const batchSize = 500
+
+	cursors := st.GetSyncCursors(ctx, syncFilter.Key)
+
+	events := make(chan *db.Event, batchSize)
+	go nostrWrapper.Stream(ctx, syncFilter, cursors, events)
 
-		_, err := st.SaveEvents(ctx, evs)
-		if err != nil {
-			log.Println(err)
+	batch := make(map[string]*db.Event)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		now := time.Now().Unix()
+		evs := make([]*db.Event, 0, len(batch))
+		cursors := make(map[string]int64)
+		for _, ev := range batch {
+			evs = append(evs, ev)
+			createdAt := min(ev.Event.CreatedAt.Time().Unix(), now) // Do not trust timestamps in the future
+			for _, url := range ev.Urls {
+				if createdAt > cursors[url] {
+					cursors[url] = createdAt
+				}
+			}
+		}
+		batch = make(map[string]*db.Event)
+
+		// The stream context can already be done, but we still want to save what we have
+		syncCtx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+		slog.Info("Saving streamed events", "filter", syncFilter.Key, "count", len(evs))
+		if err := storeEvents(syncCtx, st, evs); err != nil {
+			return // Not all events are stored, the cursors stay so the next sync gets them again
+		}
+
+		// Only the since moves, a gap of a sync that was cut off is still open
+		saved := st.GetSyncCursors(syncCtx, syncFilter.Key)
+		next := make(map[string]db.SyncCursor, len(cursors))
+		for url, since := range cursors {
+			cursor := saved[url]
+			cursor.Since = since
+			next[url] = cursor
+		}
+		if err := st.SaveSyncCursors(syncCtx, syncFilter.Key, next); err != nil {
+			slog.Error(err.Error())
 		}
 	}
 
-	slog.Info("Done syncing")
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
 
+	for {
+		select {
+		case ev := <-events:
+			if existing, ok := batch[ev.Event.ID]; ok { // Same event from another relay
+				existing.Urls = append(existing.Urls, ev.Urls...)
+				continue
+			}
+			batch[ev.Event.ID] = ev
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			flush()
+			return
+		}
+	}
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/outbox"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/**
+ * Send our events again to the relays that did not take them, when their backoff has passed.
+ */
+func outboxTask(wg *sync.WaitGroup, ctx context.Context, publisher *outbox.Outbox, interval time.Duration) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := publisher.Retry(ctx, 100); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Only authors with notes in this period are refreshed, older ones are not in the feed anymore
+const profileSeenDays = 7
+
+/**
+ * Gets the profiles of the authors in the feed we do not have or got longer ago than the ttl, in batches.
+ * Authors without a profile on the relays are not asked again within the ttl.
+ */
+func profileTask(wg *sync.WaitGroup, ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, interval time.Duration) {
+	defer wg.Done()
+
+	tried := make(map[string]time.Time)
+
+	for {
+		ttl := time.Duration(nostrWrapper.Sync.ProfileTTL) * time.Hour
+		batchSize := nostrWrapper.Sync.ProfileBatch
+
+		for pubkey, at := range tried {
+			if time.Since(at) > ttl {
+				delete(tried, pubkey)
+			}
+		}
+
+		stale, err := st.GetStaleProfiles(ctx, time.Now().Add(-ttl), time.Now().AddDate(0, 0, -profileSeenDays).Unix(), batchSize+len(tried))
+		if err != nil {
+			slog.Error(err.Error())
+		}
+
+		batch := make([]string, 0, batchSize)
+		for _, pubkey := range stale {
+			if _, ok := tried[pubkey]; !ok && len(batch) < batchSize {
+				batch = append(batch, pubkey)
+			}
+		}
+
+		if len(batch) > 0 {
+			refreshProfiles(ctx, st, nostrWrapper, batch)
+			for _, pubkey := range batch {
+				tried[pubkey] = time.Now()
+			}
+			if len(batch) == batchSize {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func refreshProfiles(ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, pubkeys []string) {
+	slog.Info("Refreshing profiles", "count", len(pubkeys))
+
+	evs := nostrWrapper.UpdateProfiles(ctx, pubkeys)
+	for _, ev := range evs {
+		if err := st.SaveProfile(ctx, ev); err != nil { // One broken profile should not stop the rest
+			slog.Warn(err.Error(), "pubkey", ev.Event.PubKey)
+		}
+	}
+
+	if err := st.MarkProfilesFetched(ctx, pubkeys); err != nil {
+		slog.Error(err.Error())
+	}
+}
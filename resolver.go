@@ -0,0 +1,83 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	wrapper "amavis442/nostr-reader/internal/nostr"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	resolverBatchSize   = 50
+	resolverMaxAttempts = 8
+)
+
+/**
+ * Tries to get the root and reply notes we do not have yet. Notes which are not found are tried again later,
+ * every time waiting twice as long, until we give up after resolverMaxAttempts.
+ */
+func resolverTask(wg *sync.WaitGroup, ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, interval time.Duration) {
+	defer wg.Done()
+
+	for {
+		missing, err := st.GetDueMissingEvents(ctx, resolverBatchSize, resolverMaxAttempts)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		if len(missing) > 0 {
+			err := resolveMissingEvents(ctx, st, nostrWrapper, missing)
+			if err != nil {
+				slog.Error(err.Error())
+			}
+			// A full batch can mean there is more, but after an error we wait so we do not keep hammering
+			if err == nil && len(missing) == resolverBatchSize {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+/**
+ * When the notes can not be saved the whole batch counts as not found, so it waits for its next retry.
+ */
+func resolveMissingEvents(ctx context.Context, st db.Store, nostrWrapper *wrapper.Wrapper, missing []db.MissingEvent) error {
+	ids := make([]string, 0, len(missing))
+	hints := make([]string, 0)
+	for _, m := range missing {
+		ids = append(ids, m.EventId)
+		hints = append(hints, m.RelayHints...)
+	}
+	slog.Info("Sniping missing events...........", "count", len(ids))
+
+	evs := nostrWrapper.GetEventsByIds(ctx, ids, hints)
+	if _, err := st.SaveEvents(ctx, evs); err != nil {
+		return errors.Join(err, st.MissingEventsNotFound(ctx, missing))
+	}
+
+	found := make(map[string]bool)
+	resolved := make([]string, 0, len(evs))
+	for _, ev := range evs {
+		found[ev.Event.ID] = true
+		resolved = append(resolved, ev.Event.ID)
+	}
+	if err := st.ResolveMissingEvents(ctx, resolved); err != nil {
+		slog.Error(err.Error())
+	}
+
+	notFound := make([]db.MissingEvent, 0)
+	for _, m := range missing {
+		if !found[m.EventId] {
+			notFound = append(notFound, m)
+		}
+	}
+	return st.MissingEventsNotFound(ctx, notFound)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"amavis442/nostr-reader/internal/relaytest"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (st *fakeStore) GetDueMissingEvents(ctx context.Context, limit int, maxAttempts int) ([]db.MissingEvent, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.dueCalls++
+	return st.due, nil
+}
+
+func (st *fakeStore) ResolveMissingEvents(ctx context.Context, eventIds []string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.resolved = append(st.resolved, eventIds...)
+	return nil
+}
+
+func (st *fakeStore) MissingEventsNotFound(ctx context.Context, missing []db.MissingEvent) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.notFound = append(st.notFound, missing...)
+	return nil
+}
+
+func TestResolverFindsMissingEvents(t *testing.T) {
+	_, evs := authorNotes(t, 3)
+	st := &fakeStore{}
+	missing := []db.MissingEvent{{EventId: evs[0].ID}, {EventId: evs[1].ID}, {EventId: "gone"}}
+
+	if err := resolveMissingEvents(context.Background(), st, testWrapper(t, relaytest.New(t, evs, relaytest.Options{}).Url), missing); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.resolved) != 2 || !st.saved[evs[0].ID] || !st.saved[evs[1].ID] {
+		t.Logf("the 2 notes the relay has should be saved and resolved, got %d", len(st.resolved))
+		t.Fail()
+	}
+	if len(st.notFound) != 1 || st.notFound[0].EventId != "gone" {
+		t.Log("the note no relay has should get another attempt")
+		t.Fail()
+	}
+}
+
+func TestResolverCountsAnAttemptWhenSaveFails(t *testing.T) {
+	_, evs := authorNotes(t, 2)
+	st := &fakeStore{saveErr: errors.New("disk full")}
+	missing := []db.MissingEvent{{EventId: evs[0].ID}, {EventId: evs[1].ID}}
+
+	if err := resolveMissingEvents(context.Background(), st, testWrapper(t, relaytest.New(t, evs, relaytest.Options{}).Url), missing); err == nil {
+		t.Log("the error of the store should be returned")
+		t.Fail()
+	}
+	if len(st.resolved) != 0 || len(st.notFound) != 2 {
+		t.Logf("nothing is resolved and the whole batch should get an attempt, got %d", len(st.notFound))
+		t.Fail()
+	}
+}
+
+func TestResolverWaitsAfterAFailedFullBatch(t *testing.T) {
+	st := &fakeStore{saveErr: errors.New("disk full")}
+	for i := 0; i < resolverBatchSize; i++ {
+		st.due = append(st.due, db.MissingEvent{EventId: "missing" + strconv.Itoa(i)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	resolverTask(&wg, ctx, st, testWrapper(t), time.Hour)
+
+	if st.dueCalls != 1 {
+		t.Logf("a full batch that could not be saved should not be asked again right away, asked %d times", st.dueCalls)
+		t.Fail()
+	}
+}
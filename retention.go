@@ -0,0 +1,30 @@
+package main
+
+import (
+	"amavis442/nostr-reader/internal/db"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/**
+ * Delete the notes we do not keep any longer with the retention rules, every interval.
+ */
+func retentionTask(wg *sync.WaitGroup, ctx context.Context, st db.Store, interval time.Duration) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := st.ApplyRetention(ctx, false); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+	}
+}